@@ -16,9 +16,51 @@
 package config
 
 import (
+	"encoding/base64"
 	"fmt"
+	"regexp"
+	"slices"
+	"time"
 
 	"github.com/BurntSushi/toml"
+	"github.com/cloudbase/garm-provider-gcp/internal/util"
+)
+
+const (
+	// DefaultRetryMaxAttempts is the default number of attempts made for
+	// a GCP API call that fails with a transient error.
+	DefaultRetryMaxAttempts int = 5
+	// DefaultRetryBaseDelay is the default delay before the first retry.
+	// The delay doubles with every subsequent attempt.
+	DefaultRetryBaseDelay time.Duration = time.Second
+	// DefaultOperationTimeout is the default amount of time we wait for a
+	// GCP operation to finish.
+	DefaultOperationTimeout time.Duration = 5 * time.Minute
+	// DefaultPollInterval is the default time between two checks of the
+	// status of an instance.
+	DefaultPollInterval time.Duration = 5 * time.Second
+	// DefaultStatusTimeout is the default amount of time we wait for an
+	// instance to reach the expected status.
+	DefaultStatusTimeout time.Duration = 5 * time.Minute
+	// DefaultDeleteConcurrency is the default number of instances deleted
+	// in parallel when removing all the instances of a controller.
+	DefaultDeleteConcurrency int = 8
+	// DefaultListPageSize is the default number of instances requested per
+	// page when listing instances.
+	DefaultListPageSize int = 500
+	// maxListPageSize is the largest page size accepted by the GCP API.
+	maxListPageSize int = 500
+	// maxLabels is the maximum number of labels GCP allows on a resource.
+	maxLabels int = 64
+)
+
+// zoneRegex matches GCP zone names, for example europe-west1-d.
+var zoneRegex = regexp.MustCompile(`^[a-z]+-[a-z]+[0-9]+-[a-z]$`)
+
+var (
+	// labelKeyRegex and labelValueRegex match the GCP label requirements.
+	labelKeyRegex   = regexp.MustCompile(`^\p{Ll}[\p{Ll}0-9_-]{0,62}$`)
+	labelValueRegex = regexp.MustCompile(`^[\p{Ll}0-9_-]{0,63}$`)
 )
 
 func NewConfig(cfgFile string) (*Config, error) {
@@ -34,18 +76,75 @@ func NewConfig(cfgFile string) (*Config, error) {
 }
 
 type Config struct {
-	ProjectId        string `toml:"project_id"`
-	Zone             string `toml:"zone"`
-	CredentialsFile  string `toml:"credentials_file"`
-	NetworkID        string `toml:"network_id"`
-	SubnetworkID     string `toml:"subnetwork_id"`
-	ExternalIPAccess bool   `toml:"external_ip_access"`
+	ProjectId string `toml:"project_id"`
+	Zone      string `toml:"zone"`
+	// Zones is an optional list of additional zones. If the primary zone does
+	// not have enough resources to create an instance, the zones are tried in
+	// order.
+	Zones []string `toml:"zones"`
+	// RegionWide makes the provider list the instances of a pool in all the
+	// configured zones with a single aggregated list call, instead of one
+	// call per zone.
+	RegionWide      bool   `toml:"region_wide"`
+	CredentialsFile string `toml:"credentials_file"`
+	// CredentialsJSON holds the service account key as a JSON string. It is an
+	// alternative to CredentialsFile.
+	CredentialsJSON string `toml:"credentials_json"`
+	// CredentialsBase64 holds the base64 encoded service account key. It is an
+	// alternative to CredentialsFile.
+	CredentialsBase64 string `toml:"credentials_base64"`
+	NetworkID         string `toml:"network_id"`
+	SubnetworkID      string `toml:"subnetwork_id"`
+	ExternalIPAccess  bool   `toml:"external_ip_access"`
+	// DiskType is the default boot disk type of the instances. It can be
+	// overridden per pool with the disktype extra spec.
+	DiskType string `toml:"disk_type"`
+	// NetworkTier is the default network tier of the external IPs of the
+	// instances (PREMIUM or STANDARD). It can be overridden per pool with
+	// the external_ip_network_tier extra spec.
+	NetworkTier string `toml:"network_tier"`
+	// ApiEndpoint overrides the endpoint of the compute API, for example to
+	// use a Private Service Connect endpoint or an emulator.
+	ApiEndpoint string `toml:"api_endpoint"`
+	// UseSuspend makes the provider suspend instances instead of stopping
+	// them. Suspended instances keep their memory and resume faster.
+	UseSuspend bool `toml:"use_suspend"`
+	// RetryMaxAttempts is the maximum number of attempts made for a GCP API
+	// call that fails with a transient error.
+	RetryMaxAttempts int `toml:"retry_max_attempts"`
+	// RetryBaseDelay is the delay before the first retry of a failed GCP API
+	// call. It is parsed from a duration string, for example "500ms".
+	RetryBaseDelay time.Duration `toml:"retry_base_delay"`
+	// OperationTimeout is the maximum amount of time we wait for a GCP
+	// operation (create, delete, start, stop) to finish.
+	OperationTimeout time.Duration `toml:"operation_timeout"`
+	// DeleteConcurrency is the maximum number of instances deleted in
+	// parallel when garm removes all the instances of a controller.
+	DeleteConcurrency int `toml:"delete_concurrency"`
+	// ListPageSize is the maximum number of instances returned by the GCP
+	// API in a single page when listing instances.
+	ListPageSize int `toml:"list_page_size"`
+	// DefaultLabels are added to every instance and boot disk created by the
+	// provider. The custom labels of a pool take precedence over them.
+	DefaultLabels map[string]string `toml:"default_labels"`
+	// WaitForRunning makes the provider wait until a new instance is RUNNING,
+	// instead of only waiting for the insert operation to finish.
+	WaitForRunning bool `toml:"wait_for_running"`
+	// PollInterval is the time between two checks of the status of an
+	// instance, for example while waiting for it to be running.
+	PollInterval time.Duration `toml:"poll_interval"`
+	// StatusTimeout is the maximum amount of time we wait for an instance
+	// to reach the expected status.
+	StatusTimeout time.Duration `toml:"status_timeout"`
 }
 
 func (c *Config) Validate() error {
 	if c.Zone == "" {
 		return fmt.Errorf("missing region")
 	}
+	if !zoneRegex.MatchString(c.Zone) {
+		return fmt.Errorf("invalid zone format: %s", c.Zone)
+	}
 	if c.ProjectId == "" {
 		return fmt.Errorf("missing project_id")
 	}
@@ -55,5 +154,154 @@ func (c *Config) Validate() error {
 	if c.SubnetworkID == "" {
 		return fmt.Errorf("missing subnetwork_id")
 	}
+	// The subnetwork of the instances is regional, so all the zones must be
+	// in the region of the primary zone.
+	region, err := util.RegionFromZone(c.Zone)
+	if err != nil {
+		return fmt.Errorf("failed to get the region of zone %s: %w", c.Zone, err)
+	}
+	for _, zone := range c.Zones {
+		if zone == "" {
+			return fmt.Errorf("zones cannot contain empty values")
+		}
+		if !zoneRegex.MatchString(zone) {
+			return fmt.Errorf("invalid zone format: %s", zone)
+		}
+		zoneRegion, err := util.RegionFromZone(zone)
+		if err != nil {
+			return fmt.Errorf("failed to get the region of zone %s: %w", zone, err)
+		}
+		if zoneRegion != region {
+			return fmt.Errorf("zone %s is not in region %s of zone %s", zone, region, c.Zone)
+		}
+	}
+	credentialSources := 0
+	for _, source := range []string{c.CredentialsFile, c.CredentialsJSON, c.CredentialsBase64} {
+		if source != "" {
+			credentialSources++
+		}
+	}
+	if credentialSources > 1 {
+		return fmt.Errorf("only one of credentials_file, credentials_json or credentials_base64 can be set")
+	}
+	if c.CredentialsBase64 != "" {
+		if _, err := base64.StdEncoding.DecodeString(c.CredentialsBase64); err != nil {
+			return fmt.Errorf("invalid credentials_base64: %w", err)
+		}
+	}
+	if c.RetryMaxAttempts < 0 {
+		return fmt.Errorf("retry_max_attempts cannot be negative")
+	}
+	if c.RetryBaseDelay < 0 {
+		return fmt.Errorf("retry_base_delay cannot be negative")
+	}
+	if c.OperationTimeout < 0 {
+		return fmt.Errorf("operation_timeout cannot be negative")
+	}
+	if c.PollInterval < 0 {
+		return fmt.Errorf("poll_interval cannot be negative")
+	}
+	if c.StatusTimeout < 0 {
+		return fmt.Errorf("status_timeout cannot be negative")
+	}
+	switch c.NetworkTier {
+	case "", "PREMIUM", "STANDARD":
+	default:
+		return fmt.Errorf("network_tier must be one of PREMIUM or STANDARD")
+	}
+	if c.DeleteConcurrency < 0 {
+		return fmt.Errorf("delete_concurrency cannot be negative")
+	}
+	if c.ListPageSize < 0 || c.ListPageSize > maxListPageSize {
+		return fmt.Errorf("list_page_size must be between 0 and %d", maxListPageSize)
+	}
+	if len(c.DefaultLabels) > maxLabels {
+		return fmt.Errorf("default_labels cannot have more than %d labels", maxLabels)
+	}
+	for key, value := range c.DefaultLabels {
+		if !labelKeyRegex.MatchString(key) {
+			return fmt.Errorf("default_labels key '%s' does not match requirements", key)
+		}
+		if !labelValueRegex.MatchString(value) {
+			return fmt.Errorf("default_labels value '%s' does not match requirements", value)
+		}
+	}
 	return nil
 }
+
+// GetCredentialsJSON returns the inline service account key set through
+// credentials_json or credentials_base64, or nil if none is set.
+func (c *Config) GetCredentialsJSON() ([]byte, error) {
+	switch {
+	case c.CredentialsJSON != "":
+		return []byte(c.CredentialsJSON), nil
+	case c.CredentialsBase64 != "":
+		jsonKey, err := base64.StdEncoding.DecodeString(c.CredentialsBase64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode credentials_base64: %w", err)
+		}
+		return jsonKey, nil
+	}
+	return nil, nil
+}
+
+// GetZones returns the primary zone followed by the additional zones, without
+// duplicates.
+func (c *Config) GetZones() []string {
+	zones := []string{c.Zone}
+	for _, zone := range c.Zones {
+		if !slices.Contains(zones, zone) {
+			zones = append(zones, zone)
+		}
+	}
+	return zones
+}
+
+func (c *Config) GetRetryMaxAttempts() int {
+	if c.RetryMaxAttempts == 0 {
+		return DefaultRetryMaxAttempts
+	}
+	return c.RetryMaxAttempts
+}
+
+func (c *Config) GetRetryBaseDelay() time.Duration {
+	if c.RetryBaseDelay == 0 {
+		return DefaultRetryBaseDelay
+	}
+	return c.RetryBaseDelay
+}
+
+func (c *Config) GetOperationTimeout() time.Duration {
+	if c.OperationTimeout == 0 {
+		return DefaultOperationTimeout
+	}
+	return c.OperationTimeout
+}
+
+func (c *Config) GetPollInterval() time.Duration {
+	if c.PollInterval == 0 {
+		return DefaultPollInterval
+	}
+	return c.PollInterval
+}
+
+func (c *Config) GetStatusTimeout() time.Duration {
+	if c.StatusTimeout == 0 {
+		return DefaultStatusTimeout
+	}
+	return c.StatusTimeout
+}
+
+func (c *Config) GetDeleteConcurrency() int {
+	if c.DeleteConcurrency == 0 {
+		return DefaultDeleteConcurrency
+	}
+	return c.DeleteConcurrency
+}
+
+func (c *Config) GetListPageSize() int {
+	if c.ListPageSize == 0 {
+		return DefaultListPageSize
+	}
+	return c.ListPageSize
+}
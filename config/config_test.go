@@ -16,9 +16,11 @@
 package config
 
 import (
+	"encoding/base64"
 	"fmt"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -41,6 +43,114 @@ func TestConfig_Validate(t *testing.T) {
 			},
 			errString: nil,
 		},
+		{
+			name: "MissingCredentialsFile",
+			config: &Config{
+				Zone:         "europe-west1-d",
+				ProjectId:    "my-project",
+				NetworkID:    "my-network",
+				SubnetworkID: "my-subnetwork",
+			},
+			errString: nil,
+		},
+		{
+			name: "CredentialsJSON",
+			config: &Config{
+				Zone:            "europe-west1-d",
+				ProjectId:       "my-project",
+				NetworkID:       "my-network",
+				SubnetworkID:    "my-subnetwork",
+				CredentialsJSON: `{"type": "service_account"}`,
+			},
+			errString: nil,
+		},
+		{
+			name: "CredentialsBase64",
+			config: &Config{
+				Zone:              "europe-west1-d",
+				ProjectId:         "my-project",
+				NetworkID:         "my-network",
+				SubnetworkID:      "my-subnetwork",
+				CredentialsBase64: "eyJ0eXBlIjogInNlcnZpY2VfYWNjb3VudCJ9",
+			},
+			errString: nil,
+		},
+		{
+			name: "InvalidCredentialsBase64",
+			config: &Config{
+				Zone:              "europe-west1-d",
+				ProjectId:         "my-project",
+				NetworkID:         "my-network",
+				SubnetworkID:      "my-subnetwork",
+				CredentialsBase64: "not base64!",
+			},
+			errString: fmt.Errorf("invalid credentials_base64: %w", base64.CorruptInputError(3)),
+		},
+		{
+			name: "MultipleCredentialSources",
+			config: &Config{
+				Zone:            "europe-west1-d",
+				ProjectId:       "my-project",
+				NetworkID:       "my-network",
+				SubnetworkID:    "my-subnetwork",
+				CredentialsFile: "path/to/credentials.json",
+				CredentialsJSON: `{"type": "service_account"}`,
+			},
+			errString: fmt.Errorf("only one of credentials_file, credentials_json or credentials_base64 can be set"),
+		},
+		{
+			name: "EmptyZone",
+			config: &Config{
+				Zone:         "europe-west1-d",
+				Zones:        []string{"europe-west1-b", ""},
+				ProjectId:    "my-project",
+				NetworkID:    "my-network",
+				SubnetworkID: "my-subnetwork",
+			},
+			errString: fmt.Errorf("zones cannot contain empty values"),
+		},
+		{
+			name: "ZoneWithTwoDigitRegion",
+			config: &Config{
+				Zone:         "europe-west12-a",
+				ProjectId:    "my-project",
+				NetworkID:    "my-network",
+				SubnetworkID: "my-subnetwork",
+			},
+			errString: nil,
+		},
+		{
+			name: "InvalidZoneFormat",
+			config: &Config{
+				Zone:         "europe-west1",
+				ProjectId:    "my-project",
+				NetworkID:    "my-network",
+				SubnetworkID: "my-subnetwork",
+			},
+			errString: fmt.Errorf("invalid zone format: europe-west1"),
+		},
+		{
+			name: "InvalidZonesFormat",
+			config: &Config{
+				Zone:         "europe-west1-d",
+				Zones:        []string{"europe-west1-b", "Europe-West1-C"},
+				ProjectId:    "my-project",
+				NetworkID:    "my-network",
+				SubnetworkID: "my-subnetwork",
+			},
+			errString: fmt.Errorf("invalid zone format: Europe-West1-C"),
+		},
+		{
+			name: "ZonesInAnotherRegion",
+			config: &Config{
+				Zone:         "europe-west1-d",
+				Zones:        []string{"europe-west1-b", "europe-west4-a"},
+				ProjectId:    "my-project",
+				NetworkID:    "my-network",
+				SubnetworkID: "my-subnetwork",
+			},
+			errString: fmt.Errorf("zone europe-west4-a is not in region europe-west1 of zone europe-west1-d"),
+		},
 		{
 			name: "MissingRegion",
 			config: &Config{
@@ -84,6 +194,138 @@ func TestConfig_Validate(t *testing.T) {
 			},
 			errString: fmt.Errorf("missing subnetwork_id"),
 		},
+		{
+			name: "NegativeRetryMaxAttempts",
+			config: &Config{
+				Zone:             "europe-west1-d",
+				ProjectId:        "my-project",
+				NetworkID:        "my-network",
+				SubnetworkID:     "my-subnetwork",
+				RetryMaxAttempts: -1,
+			},
+			errString: fmt.Errorf("retry_max_attempts cannot be negative"),
+		},
+		{
+			name: "InvalidNetworkTier",
+			config: &Config{
+				Zone:         "europe-west1-d",
+				ProjectId:    "my-project",
+				NetworkID:    "my-network",
+				SubnetworkID: "my-subnetwork",
+				NetworkTier:  "BASIC",
+			},
+			errString: fmt.Errorf("network_tier must be one of PREMIUM or STANDARD"),
+		},
+		{
+			name: "NegativeDeleteConcurrency",
+			config: &Config{
+				Zone:              "europe-west1-d",
+				ProjectId:         "my-project",
+				NetworkID:         "my-network",
+				SubnetworkID:      "my-subnetwork",
+				DeleteConcurrency: -1,
+			},
+			errString: fmt.Errorf("delete_concurrency cannot be negative"),
+		},
+		{
+			name: "NegativeListPageSize",
+			config: &Config{
+				Zone:         "europe-west1-d",
+				ProjectId:    "my-project",
+				NetworkID:    "my-network",
+				SubnetworkID: "my-subnetwork",
+				ListPageSize: -1,
+			},
+			errString: fmt.Errorf("list_page_size must be between 0 and 500"),
+		},
+		{
+			name: "ListPageSizeTooLarge",
+			config: &Config{
+				Zone:         "europe-west1-d",
+				ProjectId:    "my-project",
+				NetworkID:    "my-network",
+				SubnetworkID: "my-subnetwork",
+				ListPageSize: 501,
+			},
+			errString: fmt.Errorf("list_page_size must be between 0 and 500"),
+		},
+		{
+			name: "NegativeRetryBaseDelay",
+			config: &Config{
+				Zone:           "europe-west1-d",
+				ProjectId:      "my-project",
+				NetworkID:      "my-network",
+				SubnetworkID:   "my-subnetwork",
+				RetryBaseDelay: -time.Second,
+			},
+			errString: fmt.Errorf("retry_base_delay cannot be negative"),
+		},
+		{
+			name: "NegativeOperationTimeout",
+			config: &Config{
+				Zone:             "europe-west1-d",
+				ProjectId:        "my-project",
+				NetworkID:        "my-network",
+				SubnetworkID:     "my-subnetwork",
+				OperationTimeout: -time.Second,
+			},
+			errString: fmt.Errorf("operation_timeout cannot be negative"),
+		},
+		{
+			name: "NegativePollInterval",
+			config: &Config{
+				Zone:         "europe-west1-d",
+				ProjectId:    "my-project",
+				NetworkID:    "my-network",
+				SubnetworkID: "my-subnetwork",
+				PollInterval: -time.Second,
+			},
+			errString: fmt.Errorf("poll_interval cannot be negative"),
+		},
+		{
+			name: "NegativeStatusTimeout",
+			config: &Config{
+				Zone:          "europe-west1-d",
+				ProjectId:     "my-project",
+				NetworkID:     "my-network",
+				SubnetworkID:  "my-subnetwork",
+				StatusTimeout: -time.Minute,
+			},
+			errString: fmt.Errorf("status_timeout cannot be negative"),
+		},
+		{
+			name: "DefaultLabels",
+			config: &Config{
+				Zone:          "europe-west1-d",
+				ProjectId:     "my-project",
+				NetworkID:     "my-network",
+				SubnetworkID:  "my-subnetwork",
+				DefaultLabels: map[string]string{"team": "ci", "cost-center": ""},
+			},
+			errString: nil,
+		},
+		{
+			name: "InvalidDefaultLabelKey",
+			config: &Config{
+				Zone:          "europe-west1-d",
+				ProjectId:     "my-project",
+				NetworkID:     "my-network",
+				SubnetworkID:  "my-subnetwork",
+				DefaultLabels: map[string]string{"Team": "ci"},
+			},
+			errString: fmt.Errorf("default_labels key 'Team' does not match requirements"),
+		},
+		{
+			name: "InvalidDefaultLabelValue",
+			config: &Config{
+				Zone:          "europe-west1-d",
+				ProjectId:     "my-project",
+				NetworkID:     "my-network",
+				SubnetworkID:  "my-subnetwork",
+				DefaultLabels: map[string]string{"team": "CI/CD"},
+			},
+			errString: fmt.Errorf("default_labels value 'CI/CD' does not match requirements"),
+		},
 	}
 
 	for _, tc := range tests {
@@ -103,10 +345,23 @@ func TestNewConfig(t *testing.T) {
 	mockData := `
 	project_id = "garm-testing"
 	zone = "europe-west1-d"
+	zones = ["europe-west1-b", "europe-west1-c"]
+	region_wide = true
+	use_suspend = true
 	network_id = "projects/garm-testing/global/networks/garm"
 	subnetwork_id = "projects/garm-testing/regions/europe-west1/subnetworks/garm"
 	credentials_file = "/home/ubuntu/service-account-key.json"
 	external_ip_access = true
+	disk_type = "pd-balanced"
+	network_tier = "STANDARD"
+	api_endpoint = "https://compute-myendpoint.p.googleapis.com"
+	retry_max_attempts = 3
+	retry_base_delay = "500ms"
+	operation_timeout = "10m"
+	poll_interval = "10s"
+	status_timeout = "3m"
+	delete_concurrency = 4
+	list_page_size = 100
 	`
 	// Create a temporary file
 	tmpFile, err := os.CreateTemp("", "config-*.toml")
@@ -125,8 +380,84 @@ func TestNewConfig(t *testing.T) {
 	// Validate the content of the Config object
 	require.Equal(t, "garm-testing", cfg.ProjectId, "ProjectId value did not match expected")
 	require.Equal(t, "europe-west1-d", cfg.Zone, "Zone value did not match expected")
+	require.Equal(t, []string{"europe-west1-b", "europe-west1-c"}, cfg.Zones, "Zones value did not match expected")
+	require.Equal(t, true, cfg.RegionWide, "RegionWide value did not match expected")
+	require.Equal(t, true, cfg.UseSuspend, "UseSuspend value did not match expected")
 	require.Equal(t, "projects/garm-testing/global/networks/garm", cfg.NetworkID, "NetworkId value did not match expected")
 	require.Equal(t, "projects/garm-testing/regions/europe-west1/subnetworks/garm", cfg.SubnetworkID, "SubnetworkId value did not match expected")
 	require.Equal(t, "/home/ubuntu/service-account-key.json", cfg.CredentialsFile, "CredentialsFile value did not match expected")
 	require.Equal(t, true, cfg.ExternalIPAccess, "ExternalIpAccess value did not match expected")
+	require.Equal(t, "pd-balanced", cfg.DiskType, "DiskType value did not match expected")
+	require.Equal(t, "STANDARD", cfg.NetworkTier, "NetworkTier value did not match expected")
+	require.Equal(t, "https://compute-myendpoint.p.googleapis.com", cfg.ApiEndpoint, "ApiEndpoint value did not match expected")
+	require.Equal(t, 3, cfg.GetRetryMaxAttempts(), "RetryMaxAttempts value did not match expected")
+	require.Equal(t, 500*time.Millisecond, cfg.GetRetryBaseDelay(), "RetryBaseDelay value did not match expected")
+	require.Equal(t, 10*time.Minute, cfg.GetOperationTimeout(), "OperationTimeout value did not match expected")
+	require.Equal(t, 10*time.Second, cfg.GetPollInterval(), "PollInterval value did not match expected")
+	require.Equal(t, 3*time.Minute, cfg.GetStatusTimeout(), "StatusTimeout value did not match expected")
+	require.Equal(t, 4, cfg.GetDeleteConcurrency(), "DeleteConcurrency value did not match expected")
+	require.Equal(t, 100, cfg.GetListPageSize(), "ListPageSize value did not match expected")
+}
+
+func TestConfigDefaults(t *testing.T) {
+	cfg := &Config{}
+	require.Equal(t, DefaultRetryMaxAttempts, cfg.GetRetryMaxAttempts())
+	require.Equal(t, DefaultRetryBaseDelay, cfg.GetRetryBaseDelay())
+	require.Equal(t, DefaultOperationTimeout, cfg.GetOperationTimeout())
+	require.Equal(t, DefaultPollInterval, cfg.GetPollInterval())
+	require.Equal(t, DefaultStatusTimeout, cfg.GetStatusTimeout())
+	require.Equal(t, DefaultDeleteConcurrency, cfg.GetDeleteConcurrency())
+	require.Equal(t, DefaultListPageSize, cfg.GetListPageSize())
+}
+
+func TestNewConfigExternalIPAccessDefault(t *testing.T) {
+	mockData := `
+	project_id = "garm-testing"
+	zone = "europe-west1-d"
+	network_id = "projects/garm-testing/global/networks/garm"
+	subnetwork_id = "projects/garm-testing/regions/europe-west1/subnetworks/garm"
+	`
+	tmpFile, err := os.CreateTemp("", "config-*.toml")
+	require.NoError(t, err, "Failed to create temporary file")
+	defer os.Remove(tmpFile.Name())
+
+	_, err = tmpFile.WriteString(mockData)
+	require.NoError(t, err, "Failed to write to temporary file")
+	err = tmpFile.Close()
+	require.NoError(t, err, "Failed to close temporary file")
+
+	cfg, err := NewConfig(tmpFile.Name())
+	require.NoError(t, err, "NewConfig returned an error")
+	require.False(t, cfg.ExternalIPAccess, "ExternalIpAccess should default to false")
+}
+
+func TestGetCredentialsJSON(t *testing.T) {
+	credentialsJSON := `{"type": "service_account"}`
+
+	cfg := &Config{}
+	jsonKey, err := cfg.GetCredentialsJSON()
+	require.NoError(t, err)
+	require.Nil(t, jsonKey)
+
+	cfg = &Config{CredentialsJSON: credentialsJSON}
+	jsonKey, err = cfg.GetCredentialsJSON()
+	require.NoError(t, err)
+	require.Equal(t, credentialsJSON, string(jsonKey))
+
+	cfg = &Config{CredentialsBase64: base64.StdEncoding.EncodeToString([]byte(credentialsJSON))}
+	jsonKey, err = cfg.GetCredentialsJSON()
+	require.NoError(t, err)
+	require.Equal(t, credentialsJSON, string(jsonKey))
+
+	cfg = &Config{CredentialsBase64: "not base64!"}
+	_, err = cfg.GetCredentialsJSON()
+	require.ErrorContains(t, err, "failed to decode credentials_base64")
+}
+
+func TestGetZones(t *testing.T) {
+	cfg := &Config{Zone: "europe-west1-d"}
+	require.Equal(t, []string{"europe-west1-d"}, cfg.GetZones())
+
+	cfg.Zones = []string{"europe-west1-b", "europe-west1-d", "europe-west1-c"}
+	require.Equal(t, []string{"europe-west1-d", "europe-west1-b", "europe-west1-c"}, cfg.GetZones())
 }
@@ -17,8 +17,16 @@ package client
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
+	"math/rand"
 	"os"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"time"
 
 	compute "cloud.google.com/go/compute/apiv1"
 	"cloud.google.com/go/compute/apiv1/computepb"
@@ -28,8 +36,11 @@ import (
 	"github.com/cloudbase/garm-provider-gcp/internal/util"
 	"github.com/googleapis/gax-go/v2"
 	"github.com/googleapis/gax-go/v2/apierror"
+	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	gcompute "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 	"google.golang.org/protobuf/proto"
 )
@@ -37,14 +48,120 @@ import (
 const (
 	linuxUserData        string = "user-data"
 	windowsStartupScript string = "sysprep-specialize-script-ps1"
-	accessConfigType     string = "ONE_TO_ONE_NAT"
+	// windowsStartupScriptPs1 runs on every boot. It can be used instead of
+	// windowsStartupScript for custom images that already ran sysprep.
+	windowsStartupScriptPs1 string = "windows-startup-script-ps1"
+	accessConfigType        string = "ONE_TO_ONE_NAT"
+	ipv6AccessConfigType    string = "DIRECT_IPV6"
+	dualStackType           string = "IPV4_IPV6"
+	localSSDDiskType        string = "local-ssd"
+	// stockoutErrorCode is the error code GCP returns when a zone does not have
+	// enough resources to fulfill the request. It is also the prefix of
+	// ZONE_RESOURCE_POOL_EXHAUSTED_WITH_DETAILS.
+	stockoutErrorCode string = "ZONE_RESOURCE_POOL_EXHAUSTED"
+	poolIDLabel       string = "garmpoolid"
+	controllerIDLabel string = "garmcontrollerid"
+)
+
+// reservedMetadataKeys are the metadata keys set by the provider. Custom
+// metadata items using these keys are ignored.
+var reservedMetadataKeys = map[string]bool{
+	linuxUserData:                   true,
+	windowsStartupScript:            true,
+	windowsStartupScriptPs1:         true,
+	"runner_name":                   true,
+	"ssh-keys":                      true,
+	"enable-windows-ssh":            true,
+	"sysprep-specialize-script-cmd": true,
+}
+
+var (
+	WaitOp                 = (*compute.Operation).Wait
+	OperationProto         = (*compute.Operation).Proto
+	NextIt                 = (*compute.InstanceIterator).Next
+	NextAggregatedIt       = (*compute.InstancesScopedListPairIterator).Next
+	FindDefaultCredentials = google.FindDefaultCredentials
+)
+
+var (
+	// ErrNotFound is returned when the requested resource does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrQuotaExceeded is returned when the project ran out of quota for
+	// the requested resources.
+	ErrQuotaExceeded = errors.New("quota exceeded")
+	// ErrRateLimited is returned when the GCP API kept rejecting requests
+	// because they were sent too fast.
+	ErrRateLimited = errors.New("rate limited")
+	// ErrResourcesExhausted is returned when none of the configured zones
+	// have enough resources available to create the instance.
+	ErrResourcesExhausted = errors.New("zone resources exhausted")
+	// ErrPermissionDenied is returned when the credentials used by the
+	// provider are not allowed to perform the operation.
+	ErrPermissionDenied = errors.New("permission denied")
+	// ErrAlreadyExists is returned when a resource with the same name
+	// already exists.
+	ErrAlreadyExists = errors.New("already exists")
+	// ErrUnauthenticated is returned when the credentials used by the
+	// provider are missing, invalid or expired.
+	ErrUnauthenticated = errors.New("unauthenticated")
+	// ErrInstanceNotRunning is returned when wait_for_running is set and
+	// a new instance does not reach the RUNNING state.
+	ErrInstanceNotRunning = errors.New("instance is not running")
 )
 
 var (
-	WaitOp = (*compute.Operation).Wait
-	NextIt = (*compute.InstanceIterator).Next
+	// quotaReasons are the error reasons returned by the GCP API when a
+	// quota is exhausted.
+	quotaReasons = map[string]bool{
+		"quotaExceeded": true,
+	}
+	// rateLimitReasons are the error reasons returned by the GCP API when
+	// requests are sent faster than the API allows. Unlike an exhausted
+	// quota, these clear up on their own.
+	rateLimitReasons = map[string]bool{
+		"rateLimitExceeded":     true,
+		"userRateLimitExceeded": true,
+	}
+)
+
+var (
+	// retryableHTTPCodes are the HTTP status codes returned by the GCP API
+	// for errors that are usually transient.
+	retryableHTTPCodes = map[int]bool{
+		429: true,
+		500: true,
+		502: true,
+		503: true,
+	}
+	// retryableReasons are the error reasons returned by the GCP API
+	// for errors that are usually transient.
+	retryableReasons = map[string]bool{
+		"rateLimitExceeded":     true,
+		"userRateLimitExceeded": true,
+		"backendError":          true,
+	}
 )
 
+func isRetryableError(err error) bool {
+	var apiErr *apierror.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	if retryableHTTPCodes[apiErr.HTTPCode()] || retryableReasons[apiErr.Reason()] {
+		return true
+	}
+
+	var gErr *googleapi.Error
+	if errors.As(err, &gErr) {
+		for _, item := range gErr.Errors {
+			if retryableReasons[item.Reason] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func getHTTPClientOptionFromCredentialsFile(ctx context.Context, credentialsFile string) (option.ClientOption, error) {
 	jsonKey, err := os.ReadFile(credentialsFile)
 	if err != nil {
@@ -60,31 +177,64 @@ func getHTTPClientOptionFromCredentialsFile(ctx context.Context, credentialsFile
 	return option.WithHTTPClient(client), nil
 }
 
-func NewGcpCli(ctx context.Context, cfg *config.Config) (*GcpCli, error) {
-	var authOptions []option.ClientOption
-
+// getClientOptions returns the authentication options of the compute client. If
+// a credentials file or inline credentials are configured, they are used. Otherwise
+// we rely on the Application Default Credentials, which cover GOOGLE_APPLICATION_CREDENTIALS,
+// Workload Identity on GKE and the service account attached to a GCE instance.
+func getClientOptions(ctx context.Context, cfg *config.Config) ([]option.ClientOption, error) {
 	if cfg.CredentialsFile != "" {
 		clientOption, err := getHTTPClientOptionFromCredentialsFile(ctx, cfg.CredentialsFile)
 		if err != nil {
 			// Explicit credentials were set, but failed to create the client.
 			return nil, fmt.Errorf("failed to get http client option: %w", err)
 		}
-		authOptions = append(authOptions, clientOption)
+		return []option.ClientOption{clientOption}, nil
 	}
-	creds, err := google.FindDefaultCredentials(ctx, gcompute.CloudPlatformScope)
-	if err != nil && len(authOptions) == 0 {
+
+	jsonKey, err := cfg.GetCredentialsJSON()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get credentials: %w", err)
+	}
+	if jsonKey != nil {
+		return []option.ClientOption{option.WithCredentialsJSON(jsonKey)}, nil
+	}
+
+	creds, err := FindDefaultCredentials(ctx, gcompute.CloudPlatformScope)
+	if err != nil {
 		return nil, fmt.Errorf("failed to find default credentials and no credentials file supplied: %w", err)
 	}
-	authOptions = append(authOptions, option.WithCredentials(creds))
+	return []option.ClientOption{option.WithCredentials(creds)}, nil
+}
+
+func NewGcpCli(ctx context.Context, cfg *config.Config) (*GcpCli, error) {
+	authOptions, err := getClientOptions(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.ApiEndpoint != "" {
+		authOptions = append(authOptions, option.WithEndpoint(cfg.ApiEndpoint))
+	}
 
-	// Now use this client to create a Compute Engine client
+	// Now use this client to create a Compute Engine client. The Compute Engine
+	// API is only served over REST, so the client library does not provide a
+	// gRPC transport we could switch to.
 	computeClient, err := compute.NewInstancesRESTClient(ctx, authOptions...)
 	if err != nil {
 		return nil, fmt.Errorf("error creating compute service: %w", err)
 	}
+	disksClient, err := compute.NewDisksRESTClient(ctx, authOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("error creating disks service: %w", err)
+	}
+	imagesClient, err := compute.NewImagesRESTClient(ctx, authOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("error creating images service: %w", err)
+	}
 	gcpCli := &GcpCli{
-		cfg:    cfg,
-		client: computeClient,
+		cfg:          cfg,
+		client:       computeClient,
+		disksClient:  disksClient,
+		imagesClient: imagesClient,
 	}
 
 	return gcpCli, nil
@@ -95,13 +245,35 @@ type ClientInterface interface {
 	Start(ctx context.Context, req *computepb.StartInstanceRequest, opts ...gax.CallOption) (*compute.Operation, error)
 	Stop(ctx context.Context, req *computepb.StopInstanceRequest, opts ...gax.CallOption) (*compute.Operation, error)
 	Delete(ctx context.Context, req *computepb.DeleteInstanceRequest, opts ...gax.CallOption) (*compute.Operation, error)
+	Reset(ctx context.Context, req *computepb.ResetInstanceRequest, opts ...gax.CallOption) (*compute.Operation, error)
+	Suspend(ctx context.Context, req *computepb.SuspendInstanceRequest, opts ...gax.CallOption) (*compute.Operation, error)
+	Resume(ctx context.Context, req *computepb.ResumeInstanceRequest, opts ...gax.CallOption) (*compute.Operation, error)
 	List(ctx context.Context, req *computepb.ListInstancesRequest, opts ...gax.CallOption) *compute.InstanceIterator
+	AggregatedList(ctx context.Context, req *computepb.AggregatedListInstancesRequest, opts ...gax.CallOption) *compute.InstancesScopedListPairIterator
 	Get(ctx context.Context, req *computepb.GetInstanceRequest, opts ...gax.CallOption) (*computepb.Instance, error)
+	GetSerialPortOutput(ctx context.Context, req *computepb.GetSerialPortOutputInstanceRequest, opts ...gax.CallOption) (*computepb.SerialPortOutput, error)
+	SetDeletionProtection(ctx context.Context, req *computepb.SetDeletionProtectionInstanceRequest, opts ...gax.CallOption) (*compute.Operation, error)
+	SetMachineType(ctx context.Context, req *computepb.SetMachineTypeInstanceRequest, opts ...gax.CallOption) (*compute.Operation, error)
+}
+
+// DisksClientInterface is the subset of the compute disks API used by the
+// provider.
+type DisksClientInterface interface {
+	Resize(ctx context.Context, req *computepb.ResizeDiskRequest, opts ...gax.CallOption) (*compute.Operation, error)
+}
+
+// ImagesClientInterface is the subset of the compute images API used by the
+// provider.
+type ImagesClientInterface interface {
+	Get(ctx context.Context, req *computepb.GetImageRequest, opts ...gax.CallOption) (*computepb.Image, error)
+	GetFromFamily(ctx context.Context, req *computepb.GetFromFamilyImageRequest, opts ...gax.CallOption) (*computepb.Image, error)
 }
 
 type GcpCli struct {
-	cfg    *config.Config
-	client ClientInterface
+	cfg          *config.Config
+	client       ClientInterface
+	disksClient  DisksClientInterface
+	imagesClient ImagesClientInterface
 }
 
 func (g GcpCli) Config() *config.Config {
@@ -116,10 +288,192 @@ func (g *GcpCli) SetClient(client ClientInterface) {
 	g.client = client
 }
 
+func (g *GcpCli) SetDisksClient(client DisksClientInterface) {
+	g.disksClient = client
+}
+
+func (g *GcpCli) SetImagesClient(client ImagesClientInterface) {
+	g.imagesClient = client
+}
+
 func (g *GcpCli) SetConfig(cfg *config.Config) {
 	g.cfg = cfg
 }
 
+// withRetry calls fn until it succeeds, returns a non retryable error or the
+// maximum number of attempts is reached. The delay between attempts grows
+// exponentially and has a random jitter added to it.
+func (g *GcpCli) withRetry(ctx context.Context, fn func() error) error {
+	maxAttempts := g.cfg.GetRetryMaxAttempts()
+	delay := g.cfg.GetRetryBaseDelay()
+
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !isRetryableError(err) || attempt >= maxAttempts {
+			return err
+		}
+
+		jitter := time.Duration(rand.Int63n(int64(delay)/2 + 1))
+		select {
+		case <-ctx.Done():
+			return errors.Join(err, ctx.Err())
+		case <-time.After(delay + jitter):
+		}
+		delay *= 2
+	}
+}
+
+// isStockoutError returns true if the error means that the zone does not have
+// enough resources available to create the instance.
+func isStockoutError(err error) bool {
+	return strings.Contains(err.Error(), stockoutErrorCode)
+}
+
+// wrapAPIError wraps errors returned by the GCP API with one of the ErrNotFound,
+// ErrQuotaExceeded, ErrRateLimited, ErrResourcesExhausted, ErrPermissionDenied,
+// ErrAlreadyExists or ErrUnauthenticated sentinel errors, so callers can
+// inspect them with errors.Is. Other errors are returned unchanged.
+func wrapAPIError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if isQuotaError(err) {
+		return fmt.Errorf("%w: %w", ErrQuotaExceeded, err)
+	}
+	if isRateLimitError(err) {
+		return fmt.Errorf("%w: %w", ErrRateLimited, err)
+	}
+	if isStockoutError(err) {
+		return fmt.Errorf("%w: %w", ErrResourcesExhausted, err)
+	}
+
+	var apiErr *apierror.APIError
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+	switch apiErr.HTTPCode() {
+	case 401:
+		return fmt.Errorf("%w: %w", ErrUnauthenticated, err)
+	case 404:
+		return fmt.Errorf("%w: %w", ErrNotFound, err)
+	case 403:
+		return fmt.Errorf("%w: %w", ErrPermissionDenied, err)
+	case 409:
+		return fmt.Errorf("%w: %w", ErrAlreadyExists, err)
+	}
+	return err
+}
+
+// isQuotaError returns true if the error was caused by an exhausted quota.
+// Quota errors of failed operations only show up in the error message.
+func isQuotaError(err error) bool {
+	if strings.Contains(err.Error(), "QUOTA_EXCEEDED") {
+		return true
+	}
+	var apiErr *apierror.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	if quotaReasons[apiErr.Reason()] {
+		return true
+	}
+	var gErr *googleapi.Error
+	if errors.As(err, &gErr) {
+		for _, item := range gErr.Errors {
+			if quotaReasons[item.Reason] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isRateLimitError returns true if the error was caused by sending requests to
+// the GCP API too fast.
+func isRateLimitError(err error) bool {
+	var apiErr *apierror.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	if apiErr.HTTPCode() == 429 || rateLimitReasons[apiErr.Reason()] {
+		return true
+	}
+	var gErr *googleapi.Error
+	if errors.As(err, &gErr) {
+		for _, item := range gErr.Errors {
+			if rateLimitReasons[item.Reason] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isNotFoundError returns true if the error is a 404 returned by the GCP API.
+func isNotFoundError(err error) bool {
+	var asApiErr *apierror.APIError
+	return errors.As(err, &asApiErr) && asApiErr.HTTPCode() == 404
+}
+
+// waitForRunning polls the instance until GCP reports it as RUNNING and returns
+// it. It gives up once the status timeout expires, or as soon as the instance
+// is stopped, as it will not start on its own.
+func (g *GcpCli) waitForRunning(ctx context.Context, zone, name string) (*computepb.Instance, error) {
+	timeout := g.cfg.GetStatusTimeout()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req := &computepb.GetInstanceRequest{
+		Project:  g.cfg.ProjectId,
+		Zone:     zone,
+		Instance: name,
+	}
+	ticker := time.NewTicker(g.cfg.GetPollInterval())
+	defer ticker.Stop()
+	for {
+		var instance *computepb.Instance
+		err := g.withRetry(ctx, func() error {
+			var err error
+			instance, err = g.client.Get(ctx, req)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get instance %s while waiting for it to be running: %w", name, errors.Join(ErrInstanceNotRunning, wrapAPIError(err)))
+		}
+		status := instance.GetStatus()
+		if status == "RUNNING" {
+			return instance, nil
+		}
+		if util.MapGcpStatus(status) == params.InstanceStopped {
+			return nil, fmt.Errorf("instance %s is %s instead of RUNNING: %w", name, status, ErrInstanceNotRunning)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("timed out after %s waiting for instance %s to be running (current status: %s): %w", timeout, name, status, errors.Join(ErrInstanceNotRunning, ctx.Err()))
+		case <-ticker.C:
+		}
+	}
+}
+
+// waitOp waits for the operation to finish, giving up once the configured
+// operation timeout expires.
+func (g *GcpCli) waitOp(ctx context.Context, op *compute.Operation, operation, instance string) error {
+	timeout := g.cfg.GetOperationTimeout()
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if err := WaitOp(op, waitCtx); err != nil {
+		// Only blame the operation timeout if the caller's context is still
+		// alive. Otherwise the caller gave up first.
+		if ctx.Err() == nil && errors.Is(waitCtx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("timed out after %s waiting for %s operation on instance %s: %w", timeout, operation, instance, err)
+		}
+		return err
+	}
+	return nil
+}
+
 func (g *GcpCli) CreateInstance(ctx context.Context, spec *spec.RunnerSpec) (*computepb.Instance, error) {
 	if spec == nil {
 		return nil, fmt.Errorf("invalid nil runner spec")
@@ -132,156 +486,486 @@ func (g *GcpCli) CreateInstance(ctx context.Context, spec *spec.RunnerSpec) (*co
 
 	name := util.GetInstanceName(spec.BootstrapParams.Name)
 
+	externalIPAccess := g.cfg.ExternalIPAccess
+	if spec.EnableExternalIP != nil {
+		externalIPAccess = *spec.EnableExternalIP
+	}
+
+	region, err := util.RegionFromZone(spec.Zone)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get region: %w", err)
+	}
+
+	// Shared VPC networks are owned by the host project.
+	networkProject := g.cfg.ProjectId
+	if spec.HostProjectID != "" {
+		networkProject = spec.HostProjectID
+	}
+
 	inst := &computepb.Instance{
-		Name:        proto.String(name),
-		MachineType: proto.String(util.GetMachineType(g.cfg.Zone, spec.BootstrapParams.Flavor)),
-		Disks:       generateBootDisk(spec.DiskSize, spec.BootstrapParams.Image, spec.SourceSnapshot, spec.DiskType, spec.CustomLabels),
-		DisplayDevice: &computepb.DisplayDevice{
-			EnableDisplay: proto.Bool(spec.DisplayDevice),
-		},
-		NetworkInterfaces: []*computepb.NetworkInterface{
-			{
-				Network: proto.String(g.cfg.NetworkID),
-				NicType: proto.String(spec.NicType),
-				AccessConfigs: []*computepb.AccessConfig{
-					{
-						// The type of configuration. In accessConfigs (IPv4), the default and only option is ONE_TO_ONE_NAT.
-						Type: proto.String(accessConfigType),
-					},
-				},
-				Subnetwork: &spec.SubnetworkID,
-			},
-		},
-		Metadata: &computepb.Metadata{
-			Items: []*computepb.Items{
-				{
-					Key:   proto.String(selectStartupScript(spec.BootstrapParams.OSType)),
-					Value: proto.String(udata),
-				},
-				{
-					Key:   proto.String("runner_name"),
-					Value: proto.String(spec.BootstrapParams.Name),
-				},
-				{
-					Key:   proto.String("ssh-keys"),
-					Value: proto.String(spec.SSHKeys),
-				},
-			},
-		},
-		Labels: spec.CustomLabels,
+		Name:              proto.String(name),
+		NetworkInterfaces: generateNetworkInterfaces(spec, networkProject, region, externalIPAccess),
+		Metadata:          &computepb.Metadata{},
+		Labels:            spec.CustomLabels,
 		Tags: &computepb.Tags{
 			Items: spec.NetworkTags,
 		},
 		ServiceAccounts: spec.ServiceAccounts,
+		Scheduling:      generateScheduling(spec),
 	}
 
-	if !g.cfg.ExternalIPAccess {
-		inst.NetworkInterfaces[0].AccessConfigs = nil
-	}
+	inst.Metadata.Items = appendMetadataItem(inst.Metadata.Items, selectStartupScript(spec), udata)
+	inst.Metadata.Items = appendMetadataItem(inst.Metadata.Items, "runner_name", spec.BootstrapParams.Name)
 
-	if spec.BootstrapParams.OSType == params.Windows && len(spec.SSHKeys) > 0 {
+	if spec.EnableOSLogin {
+		// With OS Login, access to the instance is managed through IAM, so
+		// we don't inject any SSH keys.
 		inst.Metadata.Items = append(inst.Metadata.Items, &computepb.Items{
-			Key:   proto.String("enable-windows-ssh"),
+			Key:   proto.String("enable-oslogin"),
 			Value: proto.String("TRUE"),
 		})
+	} else {
+		inst.Metadata.Items = appendMetadataItem(inst.Metadata.Items, "ssh-keys", spec.SSHKeys)
+	}
+
+	if spec.BlockProjectSSHKeys {
 		inst.Metadata.Items = append(inst.Metadata.Items, &computepb.Items{
-			Key:   proto.String("sysprep-specialize-script-cmd"),
-			Value: proto.String("googet -noconfirm=true install google-compute-engine-ssh"),
+			Key:   proto.String("block-project-ssh-keys"),
+			Value: proto.String("TRUE"),
 		})
 	}
 
-	insertReq := &computepb.InsertInstanceRequest{
-		Project:          g.cfg.ProjectId,
-		Zone:             g.cfg.Zone,
-		InstanceResource: inst,
+	if len(spec.ResourceManagerTags) > 0 {
+		inst.Params = &computepb.InstanceParams{
+			ResourceManagerTags: spec.ResourceManagerTags,
+		}
 	}
 
-	op, err := g.client.Insert(ctx, insertReq)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create instance %s: %w", insertReq, err)
+	if spec.DisplayDevice {
+		inst.DisplayDevice = &computepb.DisplayDevice{
+			EnableDisplay: proto.Bool(true),
+		}
+	}
+
+	if spec.Description != "" {
+		inst.Description = proto.String(spec.Description)
+	}
+
+	if spec.Hostname != "" {
+		inst.Hostname = proto.String(spec.Hostname)
+	}
+
+	if spec.SourceMachineImage != "" {
+		inst.SourceMachineImage = proto.String(spec.SourceMachineImage)
+	}
+
+	if spec.MinCpuPlatform != "" {
+		inst.MinCpuPlatform = proto.String(spec.MinCpuPlatform)
+	}
+
+	if spec.DeletionProtection {
+		inst.DeletionProtection = proto.Bool(true)
+	}
+
+	if len(spec.ResourcePolicies) > 0 {
+		inst.ResourcePolicies = spec.ResourcePolicies
+	}
+
+	if spec.CanIPForward {
+		inst.CanIpForward = proto.Bool(true)
+	}
+
+	if spec.ThreadsPerCore > 0 || spec.VisibleCoreCount > 0 {
+		inst.AdvancedMachineFeatures = &computepb.AdvancedMachineFeatures{}
+		if spec.ThreadsPerCore > 0 {
+			inst.AdvancedMachineFeatures.ThreadsPerCore = proto.Int32(int32(spec.ThreadsPerCore))
+		}
+		if spec.VisibleCoreCount > 0 {
+			inst.AdvancedMachineFeatures.VisibleCoreCount = proto.Int32(int32(spec.VisibleCoreCount))
+		}
+	}
+
+	if spec.EnableConfidentialCompute {
+		inst.ConfidentialInstanceConfig = &computepb.ConfidentialInstanceConfig{
+			EnableConfidentialCompute: proto.Bool(true),
+		}
 	}
 
-	if err = WaitOp(op, ctx); err != nil {
-		return nil, fmt.Errorf("failed to wait for operation: %w", err)
+	if spec.EnableSecureBoot || spec.EnableVtpm || spec.EnableIntegrityMonitoring {
+		// Options that are not requested are left unset, so GCP applies
+		// its defaults for them.
+		inst.ShieldedInstanceConfig = &computepb.ShieldedInstanceConfig{}
+		if spec.EnableSecureBoot {
+			inst.ShieldedInstanceConfig.EnableSecureBoot = proto.Bool(true)
+		}
+		if spec.EnableVtpm {
+			inst.ShieldedInstanceConfig.EnableVtpm = proto.Bool(true)
+		}
+		if spec.EnableIntegrityMonitoring {
+			inst.ShieldedInstanceConfig.EnableIntegrityMonitoring = proto.Bool(true)
+		}
+	}
+
+	if spec.BootstrapParams.OSType == params.Windows {
+		var sysprepCmds []string
+		if len(spec.SSHKeys) > 0 {
+			inst.Metadata.Items = append(inst.Metadata.Items, &computepb.Items{
+				Key:   proto.String("enable-windows-ssh"),
+				Value: proto.String("TRUE"),
+			})
+			sysprepCmds = append(sysprepCmds, "googet -noconfirm=true install google-compute-engine-ssh")
+		}
+		if spec.WaitForGuestAttributes {
+			inst.Metadata.Items = appendMetadataItem(inst.Metadata.Items, "enable-guest-attributes", "TRUE")
+		}
+		// Metadata keys must be unique, so all the commands go in a single
+		// cmd script, one per line.
+		sysprepCmds = append(sysprepCmds, spec.WindowsExtraSysprepCmds...)
+		inst.Metadata.Items = appendMetadataItem(inst.Metadata.Items, "sysprep-specialize-script-cmd", strings.Join(sysprepCmds, "\r\n"))
+	}
+
+	inst.Metadata.Items = append(inst.Metadata.Items, generateCustomMetadata(inst.Metadata.Items, spec.CustomMetadata)...)
+
+	if missing := g.missingNicGuestOSFeatures(ctx, spec); len(missing) > 0 {
+		slog.WarnContext(ctx, "image does not declare support for the requested nic type", "image", spec.BootstrapParams.Image, "missing_guest_os_features", missing)
 	}
 
-	return inst, nil
+	if spec.SourceInstanceTemplate != "" {
+		// The machine configuration comes from the template. We only set what
+		// is needed to bootstrap and track the runner.
+		inst = &computepb.Instance{
+			Name:        inst.Name,
+			Description: inst.Description,
+			Metadata:    inst.Metadata,
+			Labels:      inst.Labels,
+		}
+	}
+
+	// Try the configured zones in order, moving on to the next zone only if
+	// the current one has no capacity left for the instance.
+	zones := g.cfg.GetZones()
+	for idx, zone := range zones {
+		spec.Zone = zone
+		insertReq := &computepb.InsertInstanceRequest{
+			Project:          g.cfg.ProjectId,
+			Zone:             zone,
+			InstanceResource: inst,
+		}
+		if spec.SourceInstanceTemplate != "" {
+			insertReq.SourceInstanceTemplate = proto.String(spec.SourceInstanceTemplate)
+		} else {
+			inst.MachineType = proto.String(util.GetMachineType(zone, spec.BootstrapParams.Flavor))
+			inst.Disks = generateDisks(spec)
+		}
+
+		err = g.insertInstance(ctx, insertReq)
+		if err == nil {
+			inst.Zone = proto.String(zone)
+			if g.cfg.WaitForRunning {
+				return g.waitForRunning(ctx, zone, name)
+			}
+			return inst, nil
+		}
+		err = wrapAPIError(err)
+		if errors.Is(err, ErrAlreadyExists) {
+			// A previous attempt already created the instance. Return it so
+			// retries are idempotent.
+			existing, existingZone, getErr := g.findInstance(ctx, name)
+			if getErr != nil {
+				return nil, fmt.Errorf("instance %s already exists but could not be retrieved: %w", name, errors.Join(err, getErr))
+			}
+			if g.cfg.WaitForRunning {
+				return g.waitForRunning(ctx, existingZone, name)
+			}
+			return existing, nil
+		}
+		if !isStockoutError(err) || idx == len(zones)-1 {
+			return nil, err
+		}
+	}
+
+	return nil, fmt.Errorf("no zones configured")
 }
 
-func (g *GcpCli) GetInstance(ctx context.Context, instanceName string) (*computepb.Instance, error) {
-	req := &computepb.GetInstanceRequest{
-		Project:  g.cfg.ProjectId,
-		Zone:     g.cfg.Zone,
-		Instance: util.GetInstanceName(instanceName),
+func (g *GcpCli) insertInstance(ctx context.Context, insertReq *computepb.InsertInstanceRequest) error {
+	var op *compute.Operation
+	err := g.withRetry(ctx, func() error {
+		var err error
+		op, err = g.client.Insert(ctx, insertReq)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create instance %s: %w", insertReq, err)
+	}
+
+	// Log the operation before waiting for it, so it can be looked up in the
+	// audit logs even if the wait fails.
+	opProto := OperationProto(op)
+	slog.InfoContext(ctx, "started insert operation", "instance", insertReq.InstanceResource.GetName(), "zone", insertReq.Zone, "operation", opProto.GetName(), "self_link", opProto.GetSelfLink())
+
+	if err = g.waitOp(ctx, op, "insert", insertReq.InstanceResource.GetName()); err != nil {
+		return fmt.Errorf("failed to wait for operation: %w", err)
 	}
 
-	instance, err := g.client.Get(ctx, req)
+	return nil
+}
+
+func (g *GcpCli) GetInstance(ctx context.Context, instanceName string) (*computepb.Instance, error) {
+	instance, _, err := g.findInstance(ctx, util.GetInstanceName(instanceName))
 	if err != nil {
-		return nil, fmt.Errorf("failed to get instance: %v", err)
+		return nil, fmt.Errorf("failed to get instance: %w", wrapAPIError(err))
 	}
 
 	return instance, nil
 }
 
+// findInstance looks for the instance in each of the configured zones and returns
+// it together with the zone it was found in. If the instance does not exist in
+// any of the zones, the 404 error of the last zone is returned.
+func (g *GcpCli) findInstance(ctx context.Context, name string) (*computepb.Instance, string, error) {
+	var lastErr error
+	for _, zone := range g.cfg.GetZones() {
+		req := &computepb.GetInstanceRequest{
+			Project:  g.cfg.ProjectId,
+			Zone:     zone,
+			Instance: name,
+		}
+
+		var instance *computepb.Instance
+		err := g.withRetry(ctx, func() error {
+			var err error
+			instance, err = g.client.Get(ctx, req)
+			return err
+		})
+		if err == nil {
+			return instance, zone, nil
+		}
+		if !isNotFoundError(err) {
+			return nil, "", err
+		}
+		lastErr = err
+	}
+
+	return nil, "", lastErr
+}
+
+// findInstanceZone returns the zone of the instance. When a single zone is
+// configured, it is returned without querying the API.
+func (g *GcpCli) findInstanceZone(ctx context.Context, name string) (string, error) {
+	zones := g.cfg.GetZones()
+	if len(zones) == 1 {
+		return zones[0], nil
+	}
+
+	_, zone, err := g.findInstance(ctx, name)
+	if err != nil {
+		return "", fmt.Errorf("failed to find the zone of instance %s: %w", name, err)
+	}
+	return zone, nil
+}
+
 func (g *GcpCli) ListDescribedInstances(ctx context.Context, poolID string) ([]*computepb.Instance, error) {
-	label := fmt.Sprintf("labels.garmpoolid=%s", poolID)
-	req := &computepb.ListInstancesRequest{
-		Project: g.cfg.ProjectId,
-		Zone:    g.cfg.Zone,
-		Filter:  &label,
+	return g.ListInstancesByLabel(ctx, poolIDLabel, poolID)
+}
+
+// ListDescribedInstancesAggregated lists the instances of a pool in the
+// configured zones with a single call to the aggregated list API. Instances in
+// other zones are left out, as the provider can neither fetch nor delete them.
+// Listing fails if one of the configured zones could not be reached, so that
+// the instances in it are not reported as gone.
+func (g *GcpCli) ListDescribedInstancesAggregated(ctx context.Context, poolID string) ([]*computepb.Instance, error) {
+	label := fmt.Sprintf("labels.%s=%s", poolIDLabel, poolID)
+	req := &computepb.AggregatedListInstancesRequest{
+		Project:    g.cfg.ProjectId,
+		Filter:     &label,
+		MaxResults: proto.Uint32(uint32(g.cfg.GetListPageSize())),
+		// Don't fail the whole listing if a zone we don't use is unreachable.
+		ReturnPartialSuccess: proto.Bool(true),
 	}
 
-	it := g.client.List(ctx, req)
+	zones := g.cfg.GetZones()
+	it := g.client.AggregatedList(ctx, req)
 	var instances []*computepb.Instance
 	for {
-		instance, _ := NextIt(it)
-		if instance == nil {
+		pair, err := NextAggregatedIt(it)
+		if errors.Is(err, iterator.Done) {
 			break
 		}
-		instances = append(instances, instance)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list instances: %w", err)
+		}
+		zone := strings.TrimPrefix(pair.Key, "zones/")
+		if !slices.Contains(zones, zone) {
+			continue
+		}
+		if warning := pair.Value.GetWarning(); warning.GetCode() == computepb.Warning_UNREACHABLE.String() {
+			return nil, fmt.Errorf("failed to list instances in zone %s: %s", zone, warning.GetMessage())
+		}
+		instances = append(instances, pair.Value.GetInstances()...)
+	}
+
+	return instances, nil
+}
+
+// Ping checks that the provider can reach the compute API with its credentials
+// and list the instances of the configured project and zone. It only requests
+// a single instance, so it is cheap to call.
+func (g *GcpCli) Ping(ctx context.Context) error {
+	req := &computepb.ListInstancesRequest{
+		Project:    g.cfg.ProjectId,
+		Zone:       g.cfg.Zone,
+		MaxResults: proto.Uint32(1),
+	}
+
+	it := g.client.List(ctx, req)
+	_, err := NextIt(it)
+	if err == nil || errors.Is(err, iterator.Done) {
+		return nil
+	}
+
+	err = wrapAPIError(err)
+	var retrieveErr *oauth2.RetrieveError
+	switch {
+	case errors.Is(err, ErrUnauthenticated), errors.As(err, &retrieveErr):
+		return fmt.Errorf("failed to authenticate to GCP, check the provider credentials: %w", err)
+	case errors.Is(err, ErrPermissionDenied):
+		return fmt.Errorf("the provider credentials are not allowed to list instances in project %s: %w", g.cfg.ProjectId, err)
+	case errors.Is(err, ErrNotFound):
+		return fmt.Errorf("project %s or zone %s not found: %w", g.cfg.ProjectId, g.cfg.Zone, err)
+	}
+	return fmt.Errorf("failed to reach the compute API: %w", err)
+}
+
+func (g *GcpCli) ListInstancesByController(ctx context.Context, controllerID string) ([]*computepb.Instance, error) {
+	return g.ListInstancesByLabel(ctx, controllerIDLabel, controllerID)
+}
+
+// ListInstancesByLabel lists the instances in the configured zones that have
+// the label key set to value. Instances may have been created in any of the
+// zones, so all of them are queried.
+func (g *GcpCli) ListInstancesByLabel(ctx context.Context, key, value string) ([]*computepb.Instance, error) {
+	label := fmt.Sprintf("labels.%s=%s", key, value)
+	var instances []*computepb.Instance
+	for _, zone := range g.cfg.GetZones() {
+		req := &computepb.ListInstancesRequest{
+			Project:    g.cfg.ProjectId,
+			Zone:       zone,
+			Filter:     &label,
+			MaxResults: proto.Uint32(uint32(g.cfg.GetListPageSize())),
+		}
+
+		it := g.client.List(ctx, req)
+		for {
+			instance, err := NextIt(it)
+			if errors.Is(err, iterator.Done) {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to list instances in zone %s: %w", zone, wrapAPIError(err))
+			}
+			instances = append(instances, instance)
+		}
 	}
 
 	return instances, nil
 }
 
 func (g *GcpCli) DeleteInstance(ctx context.Context, instance string) error {
+	name := util.GetInstanceName(instance)
+	inst, zone, err := g.findInstance(ctx, name)
+	if err != nil {
+		if isNotFoundError(err) {
+			// We got a 404 error. The instance is gone.
+			return nil
+		}
+		return fmt.Errorf("unable to get instance: %w", wrapAPIError(err))
+	}
+
 	req := &computepb.DeleteInstanceRequest{
-		Instance: util.GetInstanceName(instance),
+		Instance: name,
 		Project:  g.cfg.ProjectId,
-		Zone:     g.cfg.Zone,
+		Zone:     zone,
 	}
 
-	op, err := g.client.Delete(ctx, req)
+	if inst.GetDeletionProtection() {
+		// GCP refuses to delete a protected instance, so we need to clear the
+		// protection first.
+		if err := g.clearDeletionProtection(ctx, req.Instance, zone); err != nil {
+			return fmt.Errorf("unable to clear deletion protection: %w", wrapAPIError(err))
+		}
+	}
+
+	var op *compute.Operation
+	err = g.withRetry(ctx, func() error {
+		var err error
+		op, err = g.client.Delete(ctx, req)
+		return err
+	})
 
 	if err != nil {
-		asApiErr, ok := err.(*apierror.APIError)
-		if ok && asApiErr.HTTPCode() == 404 {
+		if isNotFoundError(err) {
 			// We got a 404 error. The instance is gone.
 			return nil
 		}
-		return fmt.Errorf("unable to delete instance: %w", err)
+		return fmt.Errorf("unable to delete instance: %w", wrapAPIError(err))
 	}
 
-	if err = WaitOp(op, ctx); err != nil {
+	if err = g.waitOp(ctx, op, "delete", req.Instance); err != nil {
 		return fmt.Errorf("unable to wait for the delete operation: %w", err)
 	}
 
 	return nil
 }
 
+func (g *GcpCli) clearDeletionProtection(ctx context.Context, instance, zone string) error {
+	req := &computepb.SetDeletionProtectionInstanceRequest{
+		Resource:           instance,
+		Project:            g.cfg.ProjectId,
+		Zone:               zone,
+		DeletionProtection: proto.Bool(false),
+	}
+
+	var op *compute.Operation
+	err := g.withRetry(ctx, func() error {
+		var err error
+		op, err = g.client.SetDeletionProtection(ctx, req)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = g.waitOp(ctx, op, "set deletion protection", instance); err != nil {
+		return fmt.Errorf("unable to wait for the set deletion protection operation: %w", err)
+	}
+
+	return nil
+}
+
 func (g *GcpCli) StopInstance(ctx context.Context, instance string) error {
+	name := util.GetInstanceName(instance)
+	zone, err := g.findInstanceZone(ctx, name)
+	if err != nil {
+		return fmt.Errorf("unable to stop instance: %w", err)
+	}
+
 	req := &computepb.StopInstanceRequest{
-		Instance: util.GetInstanceName(instance),
+		Instance: name,
 		Project:  g.cfg.ProjectId,
-		Zone:     g.cfg.Zone,
+		Zone:     zone,
 	}
 
-	op, err := g.client.Stop(ctx, req)
+	var op *compute.Operation
+	err = g.withRetry(ctx, func() error {
+		var err error
+		op, err = g.client.Stop(ctx, req)
+		return err
+	})
 	if err != nil {
 		return fmt.Errorf("unable to stop instance: %w", err)
 	}
 
-	if err = WaitOp(op, ctx); err != nil {
+	if err = g.waitOp(ctx, op, "stop", req.Instance); err != nil {
 		return fmt.Errorf("unable to wait for the operation: %w", err)
 	}
 
@@ -289,27 +973,336 @@ func (g *GcpCli) StopInstance(ctx context.Context, instance string) error {
 }
 
 func (g *GcpCli) StartInstance(ctx context.Context, instance string) error {
+	name := util.GetInstanceName(instance)
+	zone, err := g.findInstanceZone(ctx, name)
+	if err != nil {
+		return fmt.Errorf("unable to start instance: %w", err)
+	}
+
 	req := &computepb.StartInstanceRequest{
-		Instance: util.GetInstanceName(instance),
+		Instance: name,
 		Project:  g.cfg.ProjectId,
-		Zone:     g.cfg.Zone,
+		Zone:     zone,
 	}
 
-	op, err := g.client.Start(ctx, req)
+	var op *compute.Operation
+	err = g.withRetry(ctx, func() error {
+		var err error
+		op, err = g.client.Start(ctx, req)
+		return err
+	})
 	if err != nil {
 		return fmt.Errorf("unable to start instance: %w", err)
 	}
 
-	if err = WaitOp(op, ctx); err != nil {
+	if err = g.waitOp(ctx, op, "start", req.Instance); err != nil {
+		return fmt.Errorf("unable to wait for the operation: %w", err)
+	}
+
+	return nil
+}
+
+// SuspendInstance suspends an instance, preserving the contents of its memory.
+func (g *GcpCli) SuspendInstance(ctx context.Context, instance string) error {
+	name := util.GetInstanceName(instance)
+	zone, err := g.findInstanceZone(ctx, name)
+	if err != nil {
+		return fmt.Errorf("unable to suspend instance: %w", err)
+	}
+
+	req := &computepb.SuspendInstanceRequest{
+		Instance: name,
+		Project:  g.cfg.ProjectId,
+		Zone:     zone,
+	}
+
+	var op *compute.Operation
+	err = g.withRetry(ctx, func() error {
+		var err error
+		op, err = g.client.Suspend(ctx, req)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("unable to suspend instance: %w", err)
+	}
+
+	if err = g.waitOp(ctx, op, "suspend", req.Instance); err != nil {
+		return fmt.Errorf("unable to wait for the operation: %w", err)
+	}
+
+	return nil
+}
+
+// ResumeInstance resumes a suspended instance.
+func (g *GcpCli) ResumeInstance(ctx context.Context, instance string) error {
+	name := util.GetInstanceName(instance)
+	zone, err := g.findInstanceZone(ctx, name)
+	if err != nil {
+		return fmt.Errorf("unable to resume instance: %w", err)
+	}
+
+	req := &computepb.ResumeInstanceRequest{
+		Instance: name,
+		Project:  g.cfg.ProjectId,
+		Zone:     zone,
+	}
+
+	var op *compute.Operation
+	err = g.withRetry(ctx, func() error {
+		var err error
+		op, err = g.client.Resume(ctx, req)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("unable to resume instance: %w", err)
+	}
+
+	if err = g.waitOp(ctx, op, "resume", req.Instance); err != nil {
+		return fmt.Errorf("unable to wait for the operation: %w", err)
+	}
+
+	return nil
+}
+
+// ResetInstance performs a hard reset of an instance, similar to pressing the
+// reset button of a physical machine. A missing instance is not an error.
+func (g *GcpCli) ResetInstance(ctx context.Context, instance string) error {
+	name := util.GetInstanceName(instance)
+	zone, err := g.findInstanceZone(ctx, name)
+	if err != nil {
+		if isNotFoundError(err) {
+			// We got a 404 error. The instance is gone.
+			return nil
+		}
+		return fmt.Errorf("unable to reset instance: %w", err)
+	}
+
+	req := &computepb.ResetInstanceRequest{
+		Instance: name,
+		Project:  g.cfg.ProjectId,
+		Zone:     zone,
+	}
+
+	var op *compute.Operation
+	err = g.withRetry(ctx, func() error {
+		var err error
+		op, err = g.client.Reset(ctx, req)
+		return err
+	})
+	if err != nil {
+		if isNotFoundError(err) {
+			// We got a 404 error. The instance is gone.
+			return nil
+		}
+		return fmt.Errorf("unable to reset instance: %w", err)
+	}
+
+	if err = g.waitOp(ctx, op, "reset", req.Instance); err != nil {
+		return fmt.Errorf("unable to wait for the operation: %w", err)
+	}
+
+	return nil
+}
+
+// SetMachineType changes the machine type of an instance to the given flavor.
+// GCP only allows this for stopped instances.
+func (g *GcpCli) SetMachineType(ctx context.Context, instance, flavor string) error {
+	name := util.GetInstanceName(instance)
+	inst, zone, err := g.findInstance(ctx, name)
+	if err != nil {
+		return fmt.Errorf("unable to get instance: %w", err)
+	}
+
+	if inst.GetStatus() != "TERMINATED" {
+		return fmt.Errorf("instance %s must be stopped to change its machine type (current status: %s)", name, inst.GetStatus())
+	}
+
+	req := &computepb.SetMachineTypeInstanceRequest{
+		Instance: name,
+		Project:  g.cfg.ProjectId,
+		Zone:     zone,
+		InstancesSetMachineTypeRequestResource: &computepb.InstancesSetMachineTypeRequest{
+			MachineType: proto.String(util.GetMachineType(zone, flavor)),
+		},
+	}
+
+	var op *compute.Operation
+	err = g.withRetry(ctx, func() error {
+		var err error
+		op, err = g.client.SetMachineType(ctx, req)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("unable to set machine type: %w", err)
+	}
+
+	if err = g.waitOp(ctx, op, "set machine type", req.Instance); err != nil {
 		return fmt.Errorf("unable to wait for the operation: %w", err)
 	}
 
 	return nil
 }
 
-func selectStartupScript(osType params.OSType) string {
-	switch osType {
+// nicGuestOSFeatures maps the NIC types that need a driver in the guest to the
+// guest OS feature an image must declare to support them.
+var nicGuestOSFeatures = map[string]string{
+	"GVNIC": "GVNIC",
+	"IDPF":  "IDPF",
+}
+
+// missingNicGuestOSFeatures returns the guest OS features needed by the NIC
+// types of the instance that the boot image does not declare. Instances with
+// such NICs may boot without network access. The check is best effort, if the
+// image can not be fetched no features are reported as missing.
+func (g *GcpCli) missingNicGuestOSFeatures(ctx context.Context, spec *spec.RunnerSpec) []string {
+	if g.imagesClient == nil || spec.SourceSnapshot != "" || spec.SourceInstanceTemplate != "" || spec.SourceMachineImage != "" {
+		return nil
+	}
+
+	nicTypes := []string{spec.NicType}
+	if len(spec.NetworkInterfaces) > 0 {
+		nicTypes = nil
+		for _, nic := range spec.NetworkInterfaces {
+			nicTypes = append(nicTypes, nic.NicType)
+		}
+	}
+	var required []string
+	for _, nicType := range nicTypes {
+		if feature, ok := nicGuestOSFeatures[nicType]; ok && !slices.Contains(required, feature) {
+			required = append(required, feature)
+		}
+	}
+	if len(required) == 0 {
+		return nil
+	}
+
+	image, err := g.getImage(ctx, spec.BootstrapParams.Image)
+	if err != nil {
+		slog.DebugContext(ctx, "failed to get image", "image", spec.BootstrapParams.Image, "error", err)
+		return nil
+	}
+	var missing []string
+	for _, feature := range required {
+		if !slices.ContainsFunc(image.GetGuestOsFeatures(), func(f *computepb.GuestOsFeature) bool {
+			return f.GetType() == feature
+		}) {
+			missing = append(missing, feature)
+		}
+	}
+	return missing
+}
+
+// getImage fetches an image referenced as projects/PROJECT/global/images/NAME
+// or projects/PROJECT/global/images/family/FAMILY.
+func (g *GcpCli) getImage(ctx context.Context, image string) (*computepb.Image, error) {
+	_, imagePath, found := strings.Cut(image, "projects/")
+	if !found {
+		return nil, fmt.Errorf("unsupported image path %s", image)
+	}
+	parts := strings.Split(imagePath, "/")
+	switch {
+	case len(parts) == 4 && parts[1] == "global" && parts[2] == "images":
+		return g.imagesClient.Get(ctx, &computepb.GetImageRequest{
+			Project: parts[0],
+			Image:   parts[3],
+		})
+	case len(parts) == 5 && parts[1] == "global" && parts[2] == "images" && parts[3] == "family":
+		return g.imagesClient.GetFromFamily(ctx, &computepb.GetFromFamilyImageRequest{
+			Project: parts[0],
+			Family:  parts[4],
+		})
+	}
+	return nil, fmt.Errorf("unsupported image path %s", image)
+}
+
+// ResizeDisk grows the boot disk of an instance to newSizeGb. Disks can only
+// grow, so the new size must be larger than the current one. The file system
+// is usually grown by the guest on the next boot.
+func (g *GcpCli) ResizeDisk(ctx context.Context, instance string, newSizeGb int64) error {
+	name := util.GetInstanceName(instance)
+	inst, zone, err := g.findInstance(ctx, name)
+	if err != nil {
+		return fmt.Errorf("unable to get instance: %w", err)
+	}
+
+	var bootDisk *computepb.AttachedDisk
+	for _, disk := range inst.GetDisks() {
+		if disk.GetBoot() {
+			bootDisk = disk
+			break
+		}
+	}
+	if bootDisk == nil || bootDisk.GetSource() == "" {
+		return fmt.Errorf("instance %s has no boot disk", name)
+	}
+
+	if newSizeGb <= bootDisk.GetDiskSizeGb() {
+		return fmt.Errorf("new disk size %d GB must be larger than the current size %d GB", newSizeGb, bootDisk.GetDiskSizeGb())
+	}
+
+	req := &computepb.ResizeDiskRequest{
+		Disk:    path.Base(bootDisk.GetSource()),
+		Project: g.cfg.ProjectId,
+		Zone:    zone,
+		DisksResizeRequestResource: &computepb.DisksResizeRequest{
+			SizeGb: proto.Int64(newSizeGb),
+		},
+	}
+
+	var op *compute.Operation
+	err = g.withRetry(ctx, func() error {
+		var err error
+		op, err = g.disksClient.Resize(ctx, req)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("unable to resize disk: %w", err)
+	}
+
+	if err = g.waitOp(ctx, op, "resize disk", name); err != nil {
+		return fmt.Errorf("unable to wait for the operation: %w", err)
+	}
+
+	return nil
+}
+
+// GetSerialPortOutput returns the contents of the given serial port of an instance.
+// This is useful when debugging runners that fail to boot or register.
+func (g *GcpCli) GetSerialPortOutput(ctx context.Context, instance string, port int32) (string, error) {
+	name := util.GetInstanceName(instance)
+	zone, err := g.findInstanceZone(ctx, name)
+	if err != nil {
+		return "", fmt.Errorf("unable to get serial port output: %w", err)
+	}
+
+	req := &computepb.GetSerialPortOutputInstanceRequest{
+		Instance: name,
+		Project:  g.cfg.ProjectId,
+		Zone:     zone,
+		Port:     proto.Int32(port),
+	}
+
+	var output *computepb.SerialPortOutput
+	err = g.withRetry(ctx, func() error {
+		var err error
+		output, err = g.client.GetSerialPortOutput(ctx, req)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("unable to get serial port output: %w", err)
+	}
+
+	return output.GetContents(), nil
+}
+
+// selectStartupScript returns the metadata key used to pass the startup script
+// to the instance.
+func selectStartupScript(runnerSpec *spec.RunnerSpec) string {
+	switch runnerSpec.BootstrapParams.OSType {
 	case params.Windows:
+		if runnerSpec.WindowsStartupScriptKey != "" {
+			return runnerSpec.WindowsStartupScriptKey
+		}
 		return windowsStartupScript
 	case params.Linux:
 		return linuxUserData
@@ -318,26 +1311,257 @@ func selectStartupScript(osType params.OSType) string {
 	}
 }
 
-func generateBootDisk(diskSize int64, image, snapshot string, diskType string, customLabels map[string]string) []*computepb.AttachedDisk {
+func generateScheduling(spec *spec.RunnerSpec) *computepb.Scheduling {
+	if spec.ProvisioningModel == "" && !spec.EnableConfidentialCompute && spec.OnHostMaintenance == "" && spec.AutomaticRestart == nil {
+		return nil
+	}
+
+	scheduling := &computepb.Scheduling{}
+
+	if spec.OnHostMaintenance != "" {
+		scheduling.OnHostMaintenance = proto.String(spec.OnHostMaintenance)
+	}
+
+	if spec.AutomaticRestart != nil {
+		scheduling.AutomaticRestart = proto.Bool(*spec.AutomaticRestart)
+	}
+
+	if spec.ProvisioningModel != "" {
+		scheduling.ProvisioningModel = proto.String(spec.ProvisioningModel)
+	}
+
+	if spec.ProvisioningModel == computepb.Scheduling_SPOT.String() {
+		// Spot VMs can be reclaimed at any time and cannot be live migrated or
+		// restarted automatically.
+		scheduling.Preemptible = proto.Bool(true)
+		scheduling.AutomaticRestart = proto.Bool(false)
+		scheduling.OnHostMaintenance = proto.String(computepb.Scheduling_TERMINATE.String())
+	}
+
+	if spec.EnableConfidentialCompute {
+		// Confidential VMs do not support live migration.
+		scheduling.OnHostMaintenance = proto.String(computepb.Scheduling_TERMINATE.String())
+	}
+
+	return scheduling
+}
+
+// generateCustomMetadata returns the custom metadata items of the instance,
+// sorted by key. Items using a reserved key or a key that is already set in
+// the existing items are skipped.
+func generateCustomMetadata(existing []*computepb.Items, customMetadata map[string]string) []*computepb.Items {
+	existingKeys := make(map[string]bool, len(existing))
+	for _, item := range existing {
+		existingKeys[item.GetKey()] = true
+	}
+
+	keys := make([]string, 0, len(customMetadata))
+	for key := range customMetadata {
+		if reservedMetadataKeys[key] || existingKeys[key] {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	items := make([]*computepb.Items, 0, len(keys))
+	for _, key := range keys {
+		items = append(items, &computepb.Items{
+			Key:   proto.String(key),
+			Value: proto.String(customMetadata[key]),
+		})
+	}
+	return items
+}
+
+// appendMetadataItem appends a metadata item, skipping items with an empty key
+// or value so that no blank entries end up on the instance.
+func appendMetadataItem(items []*computepb.Items, key, value string) []*computepb.Items {
+	if key == "" || value == "" {
+		return items
+	}
+	return append(items, &computepb.Items{
+		Key:   proto.String(key),
+		Value: proto.String(value),
+	})
+}
+
+// generateNetworkInterfaces returns the network interfaces of the instance. If the
+// runner spec has no explicit list of interfaces, a single interface is created from
+// the network, subnetwork and NIC type of the spec. Only the first interface gets an
+// external IP address. Networks and subnetworks given as names are turned into
+// resource URLs in the network project and region.
+func generateNetworkInterfaces(runnerSpec *spec.RunnerSpec, networkProject, region string, externalIPAccess bool) []*computepb.NetworkInterface {
+	nics := runnerSpec.NetworkInterfaces
+	if len(nics) == 0 {
+		nics = []spec.NetworkInterface{
+			{
+				NetworkID:    runnerSpec.NetworkID,
+				SubnetworkID: runnerSpec.SubnetworkID,
+				NicType:      runnerSpec.NicType,
+			},
+		}
+	}
+
+	networkInterfaces := make([]*computepb.NetworkInterface, 0, len(nics))
+	for idx, nic := range nics {
+		networkInterface := &computepb.NetworkInterface{
+			NicType:    proto.String(nic.NicType),
+			Subnetwork: proto.String(util.BuildSubnetworkURL(networkProject, region, nic.SubnetworkID)),
+		}
+		if nic.NetworkID != "" {
+			networkInterface.Network = proto.String(util.BuildNetworkURL(networkProject, nic.NetworkID))
+		}
+		if idx == 0 && runnerSpec.NetworkIP != "" {
+			networkInterface.NetworkIP = proto.String(runnerSpec.NetworkIP)
+		}
+		for _, aliasRange := range nic.AliasIPRanges {
+			aliasIPRange := &computepb.AliasIpRange{
+				IpCidrRange: proto.String(aliasRange.IPCidrRange),
+			}
+			if aliasRange.SubnetworkRangeName != "" {
+				aliasIPRange.SubnetworkRangeName = proto.String(aliasRange.SubnetworkRangeName)
+			}
+			networkInterface.AliasIpRanges = append(networkInterface.AliasIpRanges, aliasIPRange)
+		}
+		if idx == 0 && externalIPAccess {
+			networkInterface.AccessConfigs = []*computepb.AccessConfig{
+				{
+					// The type of configuration. In accessConfigs (IPv4), the default and only option is ONE_TO_ONE_NAT.
+					Type: proto.String(accessConfigType),
+				},
+			}
+			if runnerSpec.ExternalIP != "" {
+				networkInterface.AccessConfigs[0].NatIP = proto.String(runnerSpec.ExternalIP)
+			}
+			if runnerSpec.ExternalIPNetworkTier != "" {
+				networkInterface.AccessConfigs[0].NetworkTier = proto.String(runnerSpec.ExternalIPNetworkTier)
+			}
+			if runnerSpec.AccessConfigName != "" {
+				networkInterface.AccessConfigs[0].Name = proto.String(runnerSpec.AccessConfigName)
+			}
+		}
+		if idx == 0 && runnerSpec.EnableIPv6 {
+			networkInterface.StackType = proto.String(dualStackType)
+			networkInterface.Ipv6AccessConfigs = []*computepb.AccessConfig{
+				{
+					// External IPv6 addresses are only available in the premium tier.
+					Type:        proto.String(ipv6AccessConfigType),
+					NetworkTier: proto.String("PREMIUM"),
+				},
+			}
+		}
+		networkInterfaces = append(networkInterfaces, networkInterface)
+	}
+
+	return networkInterfaces
+}
+
+// generateDisks returns the boot disk of the instance, followed by any
+// additional disks and local SSDs requested in the runner spec, in order.
+func generateDisks(spec *spec.RunnerSpec) []*computepb.AttachedDisk {
+	var disks []*computepb.AttachedDisk
+	// Instances created from a machine image get their boot disk from it.
+	if spec.SourceMachineImage == "" {
+		disks = generateBootDisk(spec)
+	}
+
+	for _, additionalDisk := range spec.AdditionalDisks {
+		disk := &computepb.AttachedDisk{
+			Boot: proto.Bool(false),
+			InitializeParams: &computepb.AttachedDiskInitializeParams{
+				DiskSizeGb: proto.Int64(additionalDisk.SizeGB),
+				Labels:     spec.DiskLabels,
+			},
+			AutoDelete: proto.Bool(true),
+		}
+		if additionalDisk.Type != "" {
+			disk.InitializeParams.DiskType = proto.String(additionalDisk.Type)
+		}
+		if additionalDisk.SourceImage != "" {
+			disk.InitializeParams.SourceImage = proto.String(additionalDisk.SourceImage)
+		}
+		if additionalDisk.SourceSnapshot != "" {
+			disk.InitializeParams.SourceSnapshot = proto.String(additionalDisk.SourceSnapshot)
+		}
+		if additionalDisk.AutoDelete != nil {
+			disk.AutoDelete = proto.Bool(*additionalDisk.AutoDelete)
+		}
+		disks = append(disks, disk)
+	}
+
+	for i := int64(0); i < spec.LocalSSDCount; i++ {
+		// Local SSDs have a fixed size, can only be attached over NVMe here
+		// and are always deleted together with the instance.
+		disks = append(disks, &computepb.AttachedDisk{
+			Type:      proto.String(computepb.AttachedDisk_SCRATCH.String()),
+			Interface: proto.String(computepb.AttachedDisk_NVME.String()),
+			InitializeParams: &computepb.AttachedDiskInitializeParams{
+				DiskType: proto.String(fmt.Sprintf("zones/%s/diskTypes/%s", spec.Zone, localSSDDiskType)),
+			},
+			AutoDelete: proto.Bool(true),
+		})
+	}
+
+	return disks
+}
+
+func generateBootDisk(spec *spec.RunnerSpec) []*computepb.AttachedDisk {
+	autoDelete := true
+	if spec.BootDiskAutoDelete != nil {
+		autoDelete = *spec.BootDiskAutoDelete
+	}
+
 	disk := []*computepb.AttachedDisk{
 		{
 			Boot: proto.Bool(true),
 			InitializeParams: &computepb.AttachedDiskInitializeParams{
-				DiskSizeGb:     proto.Int64(diskSize),
-				Labels:         customLabels,
-				SourceImage:    proto.String(image),
-				SourceSnapshot: proto.String(snapshot),
+				Labels: spec.DiskLabels,
 			},
-			AutoDelete: proto.Bool(true),
+			AutoDelete: proto.Bool(autoDelete),
 		},
 	}
 
-	if diskType != "" {
-		disk[0].InitializeParams.DiskType = proto.String(diskType)
+	// The runner spec validation makes sure only one source is set.
+	if spec.SourceSnapshot != "" {
+		disk[0].InitializeParams.SourceSnapshot = proto.String(spec.SourceSnapshot)
+	} else {
+		disk[0].InitializeParams.SourceImage = proto.String(spec.BootstrapParams.Image)
+	}
+
+	// Without a size, GCP uses the size of the source image or snapshot.
+	if spec.DiskSize > 0 {
+		disk[0].InitializeParams.DiskSizeGb = proto.Int64(spec.DiskSize)
+	}
+
+	if spec.DiskType != "" {
+		disk[0].InitializeParams.DiskType = proto.String(spec.DiskType)
+	}
+
+	if spec.BootDiskInterface != "" {
+		disk[0].Interface = proto.String(spec.BootDiskInterface)
+	}
+
+	if spec.BootDiskName != "" {
+		disk[0].InitializeParams.DiskName = proto.String(spec.BootDiskName)
+	}
+
+	if spec.BootDiskDeviceName != "" {
+		disk[0].DeviceName = proto.String(spec.BootDiskDeviceName)
+	}
+
+	if spec.DiskEncryptionKey != "" {
+		disk[0].DiskEncryptionKey = &computepb.CustomerEncryptionKey{
+			KmsKeyName: proto.String(spec.DiskEncryptionKey),
+		}
+	}
+
+	if spec.ProvisionedIops > 0 {
+		disk[0].InitializeParams.ProvisionedIops = proto.Int64(spec.ProvisionedIops)
 	}
 
-	if snapshot != "" {
-		disk[0].InitializeParams.SourceImage = nil
+	if spec.ProvisionedThroughput > 0 {
+		disk[0].InitializeParams.ProvisionedThroughput = proto.Int64(spec.ProvisionedThroughput)
 	}
 
 	return disk
@@ -16,8 +16,18 @@
 package client
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	compute "cloud.google.com/go/compute/apiv1"
 	"cloud.google.com/go/compute/apiv1/computepb"
@@ -29,7 +39,10 @@ import (
 	"github.com/googleapis/gax-go/v2/apierror"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
 	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -39,6 +52,9 @@ func TestCreateInstanceLinux(t *testing.T) {
 	WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
 		return nil
 	}
+	OperationProto = func(op *compute.Operation) *computepb.Operation {
+		return &computepb.Operation{}
+	}
 	gcpCli := &GcpCli{
 		cfg: &config.Config{
 			Zone:             "europe-west1-d",
@@ -113,6 +129,9 @@ func TestCreateInstanceWindows(t *testing.T) {
 	WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
 		return nil
 	}
+	OperationProto = func(op *compute.Operation) *computepb.Operation {
+		return &computepb.Operation{}
+	}
 	gcpCli := &GcpCli{
 		cfg: &config.Config{
 			Zone:             "europe-west1-d",
@@ -272,13 +291,14 @@ func TestListDescribedInstances(t *testing.T) {
 			it++
 			return expectedInstances[it-1], nil
 		}
-		return nil, nil
+		return nil, iterator.Done
 	}
 
 	mockClient.On("List", ctx, &computepb.ListInstancesRequest{
-		Project: gcpCli.cfg.ProjectId,
-		Zone:    gcpCli.cfg.Zone,
-		Filter:  proto.String("labels.garmpoolid=garm-pool"),
+		Project:    gcpCli.cfg.ProjectId,
+		Zone:       gcpCli.cfg.Zone,
+		Filter:     proto.String("labels.garmpoolid=garm-pool"),
+		MaxResults: proto.Uint32(500),
 	}, mock.Anything).Return(&compute.InstanceIterator{}, nil)
 
 	resultInstances, err := gcpCli.ListDescribedInstances(ctx, poolID)
@@ -287,6 +307,174 @@ func TestListDescribedInstances(t *testing.T) {
 
 }
 
+func TestListInstancesByController(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	gcpCli := newTestGcpCli(t, mockClient)
+	expectedInstances := []*computepb.Instance{
+		{
+			Name: proto.String("garm-instance-1"),
+			Labels: map[string]string{
+				"garmcontrollerid": "my-controller",
+			},
+		},
+		{
+			Name: proto.String("garm-instance-2"),
+			Labels: map[string]string{
+				"garmcontrollerid": "my-controller",
+			},
+		},
+	}
+	it := 0
+	NextIt = func(*compute.InstanceIterator) (*computepb.Instance, error) {
+		if it < len(expectedInstances) {
+			it++
+			return expectedInstances[it-1], nil
+		}
+		return nil, iterator.Done
+	}
+
+	mockClient.On("List", ctx, &computepb.ListInstancesRequest{
+		Project:    gcpCli.cfg.ProjectId,
+		Zone:       gcpCli.cfg.Zone,
+		Filter:     proto.String("labels.garmcontrollerid=my-controller"),
+		MaxResults: proto.Uint32(500),
+	}, mock.Anything).Return(&compute.InstanceIterator{}, nil)
+
+	resultInstances, err := gcpCli.ListInstancesByController(ctx, "my-controller")
+	assert.NoError(t, err)
+	assert.Equal(t, expectedInstances, resultInstances)
+	mockClient.AssertExpectations(t)
+}
+
+func TestListInstancesByControllerAllZones(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	gcpCli := newTestGcpCli(t, mockClient)
+	gcpCli.cfg.Zones = []string{"europe-west1-b"}
+	primaryInstance := &computepb.Instance{Name: proto.String("garm-instance-1")}
+	fallbackInstance := &computepb.Instance{Name: proto.String("garm-instance-2")}
+	primaryIt := &compute.InstanceIterator{}
+	fallbackIt := &compute.InstanceIterator{}
+	pending := map[*compute.InstanceIterator][]*computepb.Instance{
+		primaryIt:  {primaryInstance},
+		fallbackIt: {fallbackInstance},
+	}
+	NextIt = func(it *compute.InstanceIterator) (*computepb.Instance, error) {
+		if len(pending[it]) == 0 {
+			return nil, iterator.Done
+		}
+		instance := pending[it][0]
+		pending[it] = pending[it][1:]
+		return instance, nil
+	}
+
+	for zone, it := range map[string]*compute.InstanceIterator{"europe-west1-d": primaryIt, "europe-west1-b": fallbackIt} {
+		mockClient.On("List", ctx, &computepb.ListInstancesRequest{
+			Project:    gcpCli.cfg.ProjectId,
+			Zone:       zone,
+			Filter:     proto.String("labels.garmcontrollerid=my-controller"),
+			MaxResults: proto.Uint32(500),
+		}, mock.Anything).Return(it, nil)
+	}
+
+	resultInstances, err := gcpCli.ListInstancesByController(ctx, "my-controller")
+	assert.NoError(t, err)
+	assert.Equal(t, []*computepb.Instance{primaryInstance, fallbackInstance}, resultInstances)
+	mockClient.AssertExpectations(t)
+}
+
+func TestListInstancesByLabel(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	gcpCli := newTestGcpCli(t, mockClient)
+	gcpCli.cfg.ListPageSize = 50
+	expectedInstances := []*computepb.Instance{
+		{
+			Name: proto.String("garm-instance-1"),
+			Labels: map[string]string{
+				"garmcontrollerid": "my-controller",
+				"garmpoolid":       "my-pool",
+			},
+		},
+		{
+			Name: proto.String("garm-instance-2"),
+			Labels: map[string]string{
+				"garmcontrollerid": "my-controller",
+				"garmpoolid":       "my-other-pool",
+			},
+		},
+	}
+	it := 0
+	NextIt = func(*compute.InstanceIterator) (*computepb.Instance, error) {
+		if it < len(expectedInstances) {
+			it++
+			return expectedInstances[it-1], nil
+		}
+		return nil, iterator.Done
+	}
+
+	mockClient.On("List", ctx, &computepb.ListInstancesRequest{
+		Project:    gcpCli.cfg.ProjectId,
+		Zone:       gcpCli.cfg.Zone,
+		Filter:     proto.String("labels.garmcontrollerid=my-controller"),
+		MaxResults: proto.Uint32(50),
+	}, mock.Anything).Return(&compute.InstanceIterator{}, nil)
+
+	resultInstances, err := gcpCli.ListInstancesByLabel(ctx, "garmcontrollerid", "my-controller")
+	assert.NoError(t, err)
+	assert.Equal(t, expectedInstances, resultInstances)
+	mockClient.AssertExpectations(t)
+}
+
+func TestListInstancesByLabelEmpty(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	gcpCli := newTestGcpCli(t, mockClient)
+	NextIt = func(*compute.InstanceIterator) (*computepb.Instance, error) {
+		return nil, iterator.Done
+	}
+
+	mockClient.On("List", ctx, &computepb.ListInstancesRequest{
+		Project:    gcpCli.cfg.ProjectId,
+		Zone:       gcpCli.cfg.Zone,
+		Filter:     proto.String("labels.garmcontrollerid=other-controller"),
+		MaxResults: proto.Uint32(500),
+	}, mock.Anything).Return(&compute.InstanceIterator{}, nil)
+
+	resultInstances, err := gcpCli.ListInstancesByLabel(ctx, "garmcontrollerid", "other-controller")
+	assert.NoError(t, err)
+	assert.Empty(t, resultInstances)
+	mockClient.AssertExpectations(t)
+}
+
+func TestListInstancesByLabelError(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	gcpCli := newTestGcpCli(t, mockClient)
+	apiErr, _ := apierror.FromError(&googleapi.Error{
+		Code: 503,
+	})
+	calls := 0
+	NextIt = func(*compute.InstanceIterator) (*computepb.Instance, error) {
+		// The error comes after the first instance, so the partial result
+		// must not be returned either.
+		calls++
+		if calls == 1 {
+			return &computepb.Instance{Name: proto.String("garm-instance-1")}, nil
+		}
+		return nil, apiErr
+	}
+
+	mockClient.On("List", ctx, mock.Anything, mock.Anything).Return(&compute.InstanceIterator{}, nil)
+
+	resultInstances, err := gcpCli.ListInstancesByController(ctx, "my-controller")
+	assert.ErrorContains(t, err, "failed to list instances")
+	assert.ErrorIs(t, err, apiErr)
+	assert.Nil(t, resultInstances)
+	mockClient.AssertExpectations(t)
+}
+
 func TestDeleteInstance(t *testing.T) {
 	ctx := context.Background()
 	mockClient := new(MockGcpClient)
@@ -307,6 +495,11 @@ func TestDeleteInstance(t *testing.T) {
 
 	instanceName := "garm-instance"
 	mockOperation := &compute.Operation{}
+	mockClient.On("Get", ctx, &computepb.GetInstanceRequest{
+		Project:  gcpCli.cfg.ProjectId,
+		Zone:     gcpCli.cfg.Zone,
+		Instance: util.GetInstanceName(instanceName),
+	}, mock.Anything).Return(&computepb.Instance{}, nil)
 	mockClient.On("Delete", ctx, &computepb.DeleteInstanceRequest{
 		Project:  gcpCli.cfg.ProjectId,
 		Zone:     gcpCli.cfg.Zone,
@@ -342,6 +535,11 @@ func TestDeleteInstanceNotFound(t *testing.T) {
 	mockErr, _ := apierror.FromError(&googleapi.Error{
 		Code: 404,
 	})
+	mockClient.On("Get", ctx, &computepb.GetInstanceRequest{
+		Project:  gcpCli.cfg.ProjectId,
+		Zone:     gcpCli.cfg.Zone,
+		Instance: util.GetInstanceName(instanceName),
+	}, mock.Anything).Return(&computepb.Instance{}, nil)
 	mockClient.On("Delete", ctx, &computepb.DeleteInstanceRequest{
 		Project:  gcpCli.cfg.ProjectId,
 		Zone:     gcpCli.cfg.Zone,
@@ -377,6 +575,11 @@ func TestDeleteInstanceError(t *testing.T) {
 	mockErr, _ := apierror.FromError(&googleapi.Error{
 		Code: 403,
 	})
+	mockClient.On("Get", ctx, &computepb.GetInstanceRequest{
+		Project:  gcpCli.cfg.ProjectId,
+		Zone:     gcpCli.cfg.Zone,
+		Instance: util.GetInstanceName(instanceName),
+	}, mock.Anything).Return(&computepb.Instance{}, nil)
 	mockClient.On("Delete", ctx, &computepb.DeleteInstanceRequest{
 		Project:  gcpCli.cfg.ProjectId,
 		Zone:     gcpCli.cfg.Zone,
@@ -452,3 +655,2584 @@ func TestStartInstance(t *testing.T) {
 
 	mockClient.AssertExpectations(t)
 }
+
+func TestCreateInstanceSpot(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return nil
+	}
+	gcpCli := newTestGcpCli(t, mockClient)
+	mockClient.On("Insert", mock.Anything, mock.Anything, mock.Anything).Return(&compute.Operation{}, nil)
+
+	runnerSpec := newTestRunnerSpec(params.Linux)
+	runnerSpec.ProvisioningModel = "SPOT"
+
+	result, err := gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.NotNil(t, result.Scheduling)
+	assert.Equal(t, "SPOT", result.Scheduling.GetProvisioningModel())
+	assert.True(t, result.Scheduling.GetPreemptible())
+	assert.False(t, result.Scheduling.GetAutomaticRestart())
+	assert.Equal(t, "TERMINATE", result.Scheduling.GetOnHostMaintenance())
+	mockClient.AssertExpectations(t)
+}
+
+func TestCreateInstanceStandardProvisioning(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return nil
+	}
+	gcpCli := newTestGcpCli(t, mockClient)
+	mockClient.On("Insert", mock.Anything, mock.Anything, mock.Anything).Return(&compute.Operation{}, nil)
+
+	runnerSpec := newTestRunnerSpec(params.Linux)
+	result, err := gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.Nil(t, result.Scheduling)
+
+	runnerSpec.ProvisioningModel = "STANDARD"
+	result, err = gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.NotNil(t, result.Scheduling)
+	assert.Equal(t, "STANDARD", result.Scheduling.GetProvisioningModel())
+	assert.Nil(t, result.Scheduling.Preemptible)
+	mockClient.AssertExpectations(t)
+}
+
+func TestCreateInstanceMinCpuPlatform(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return nil
+	}
+	gcpCli := newTestGcpCli(t, mockClient)
+	mockClient.On("Insert", mock.Anything, mock.Anything, mock.Anything).Return(&compute.Operation{}, nil)
+
+	runnerSpec := newTestRunnerSpec(params.Linux)
+	result, err := gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.Nil(t, result.MinCpuPlatform)
+
+	runnerSpec.MinCpuPlatform = "Intel Cascade Lake"
+	result, err = gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.Equal(t, "Intel Cascade Lake", result.GetMinCpuPlatform())
+	mockClient.AssertExpectations(t)
+}
+
+func TestCreateInstanceRetry(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return nil
+	}
+	gcpCli := newTestGcpCli(t, mockClient)
+	gcpCli.cfg.RetryBaseDelay = time.Millisecond
+
+	mockOperation := &compute.Operation{}
+	mockErr, _ := apierror.FromError(&googleapi.Error{
+		Code: 503,
+	})
+	mockClient.On("Insert", mock.Anything, mock.Anything, mock.Anything).Return(mockOperation, mockErr).Once()
+	mockClient.On("Insert", mock.Anything, mock.Anything, mock.Anything).Return(mockOperation, nil).Once()
+
+	_, err := gcpCli.CreateInstance(ctx, newTestRunnerSpec(params.Linux))
+	assert.NoError(t, err)
+	mockClient.AssertExpectations(t)
+	mockClient.AssertNumberOfCalls(t, "Insert", 2)
+}
+
+func TestGetInstanceRetryExhausted(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	gcpCli := newTestGcpCli(t, mockClient)
+	gcpCli.cfg.RetryBaseDelay = time.Millisecond
+	gcpCli.cfg.RetryMaxAttempts = 3
+
+	mockErr, _ := apierror.FromError(&googleapi.Error{
+		Code:   403,
+		Errors: []googleapi.ErrorItem{{Reason: "rateLimitExceeded"}},
+	})
+	mockClient.On("Get", ctx, mock.Anything, mock.Anything).Return(&computepb.Instance{}, mockErr)
+
+	_, err := gcpCli.GetInstance(ctx, "garm-instance")
+	assert.ErrorIs(t, err, ErrRateLimited)
+	assert.NotErrorIs(t, err, ErrQuotaExceeded)
+	mockClient.AssertNumberOfCalls(t, "Get", 3)
+}
+
+func TestSetMachineType(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return nil
+	}
+	gcpCli := newTestGcpCli(t, mockClient)
+
+	mockClient.On("Get", ctx, mock.Anything, mock.Anything).Return(&computepb.Instance{
+		Name:   proto.String("garm-instance"),
+		Status: proto.String("TERMINATED"),
+	}, nil)
+	mockClient.On("SetMachineType", ctx, mock.MatchedBy(func(req *computepb.SetMachineTypeInstanceRequest) bool {
+		return req.GetInstance() == "garm-instance" &&
+			req.GetZone() == "europe-west1-d" &&
+			req.GetInstancesSetMachineTypeRequestResource().GetMachineType() == "zones/europe-west1-d/machineTypes/n2-standard-4"
+	}), mock.Anything).Return(&compute.Operation{}, nil)
+
+	err := gcpCli.SetMachineType(ctx, "garm-instance", "n2-standard-4")
+	assert.NoError(t, err)
+	mockClient.AssertExpectations(t)
+}
+
+func TestSetMachineTypeRunningInstance(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	gcpCli := newTestGcpCli(t, mockClient)
+
+	mockClient.On("Get", ctx, mock.Anything, mock.Anything).Return(&computepb.Instance{
+		Name:   proto.String("garm-instance"),
+		Status: proto.String("RUNNING"),
+	}, nil)
+
+	err := gcpCli.SetMachineType(ctx, "garm-instance", "n2-standard-4")
+	assert.EqualError(t, err, "instance garm-instance must be stopped to change its machine type (current status: RUNNING)")
+	mockClient.AssertNotCalled(t, "SetMachineType", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestResizeDisk(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	mockDisksClient := new(MockDisksClient)
+	WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return nil
+	}
+	gcpCli := newTestGcpCli(t, mockClient)
+	gcpCli.SetDisksClient(mockDisksClient)
+
+	mockClient.On("Get", ctx, mock.Anything, mock.Anything).Return(&computepb.Instance{
+		Name: proto.String("garm-instance"),
+		Disks: []*computepb.AttachedDisk{
+			{
+				Boot:       proto.Bool(true),
+				DiskSizeGb: proto.Int64(50),
+				Source:     proto.String("https://www.googleapis.com/compute/v1/projects/my-project/zones/europe-west1-d/disks/garm-instance"),
+			},
+		},
+	}, nil)
+	mockDisksClient.On("Resize", ctx, mock.MatchedBy(func(req *computepb.ResizeDiskRequest) bool {
+		return req.GetDisk() == "garm-instance" &&
+			req.GetZone() == "europe-west1-d" &&
+			req.GetProject() == "my-project" &&
+			req.GetDisksResizeRequestResource().GetSizeGb() == 100
+	}), mock.Anything).Return(&compute.Operation{}, nil)
+
+	err := gcpCli.ResizeDisk(ctx, "garm-instance", 100)
+	assert.NoError(t, err)
+	mockDisksClient.AssertExpectations(t)
+}
+
+func TestResizeDiskTooSmall(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	mockDisksClient := new(MockDisksClient)
+	gcpCli := newTestGcpCli(t, mockClient)
+	gcpCli.SetDisksClient(mockDisksClient)
+
+	mockClient.On("Get", ctx, mock.Anything, mock.Anything).Return(&computepb.Instance{
+		Name: proto.String("garm-instance"),
+		Disks: []*computepb.AttachedDisk{
+			{
+				Boot:       proto.Bool(true),
+				DiskSizeGb: proto.Int64(50),
+				Source:     proto.String("https://www.googleapis.com/compute/v1/projects/my-project/zones/europe-west1-d/disks/garm-instance"),
+			},
+		},
+	}, nil)
+
+	err := gcpCli.ResizeDisk(ctx, "garm-instance", 50)
+	assert.EqualError(t, err, "new disk size 50 GB must be larger than the current size 50 GB")
+	mockDisksClient.AssertNotCalled(t, "Resize", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestSuspendInstance(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return nil
+	}
+	gcpCli := newTestGcpCli(t, mockClient)
+
+	instanceName := "garm-instance"
+	mockClient.On("Suspend", ctx, &computepb.SuspendInstanceRequest{
+		Project:  gcpCli.cfg.ProjectId,
+		Zone:     gcpCli.cfg.Zone,
+		Instance: util.GetInstanceName(instanceName),
+	}, mock.Anything).Return(&compute.Operation{}, nil)
+
+	err := gcpCli.SuspendInstance(ctx, instanceName)
+	assert.NoError(t, err)
+
+	mockClient.AssertExpectations(t)
+}
+
+func TestResumeInstance(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return nil
+	}
+	gcpCli := newTestGcpCli(t, mockClient)
+
+	instanceName := "garm-instance"
+	mockClient.On("Resume", ctx, &computepb.ResumeInstanceRequest{
+		Project:  gcpCli.cfg.ProjectId,
+		Zone:     gcpCli.cfg.Zone,
+		Instance: util.GetInstanceName(instanceName),
+	}, mock.Anything).Return(&compute.Operation{}, nil)
+
+	err := gcpCli.ResumeInstance(ctx, instanceName)
+	assert.NoError(t, err)
+
+	mockClient.AssertExpectations(t)
+}
+
+func TestResetInstance(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return nil
+	}
+	gcpCli := newTestGcpCli(t, mockClient)
+
+	instanceName := "garm-instance"
+	mockClient.On("Reset", ctx, &computepb.ResetInstanceRequest{
+		Project:  gcpCli.cfg.ProjectId,
+		Zone:     gcpCli.cfg.Zone,
+		Instance: util.GetInstanceName(instanceName),
+	}, mock.Anything).Return(&compute.Operation{}, nil)
+
+	err := gcpCli.ResetInstance(ctx, instanceName)
+	assert.NoError(t, err)
+
+	mockClient.AssertExpectations(t)
+}
+
+func TestResetInstanceNotFound(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	gcpCli := newTestGcpCli(t, mockClient)
+
+	mockErr, _ := apierror.FromError(&googleapi.Error{
+		Code: 404,
+	})
+	mockClient.On("Reset", ctx, mock.Anything, mock.Anything).Return(&compute.Operation{}, mockErr)
+
+	err := gcpCli.ResetInstance(ctx, "garm-instance")
+	assert.NoError(t, err)
+	mockClient.AssertNumberOfCalls(t, "Reset", 1)
+}
+
+func TestResetInstanceNotFoundInZones(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	gcpCli := newTestGcpCli(t, mockClient)
+	gcpCli.cfg.Zones = []string{"europe-west1-b"}
+
+	mockErr, _ := apierror.FromError(&googleapi.Error{
+		Code: 404,
+	})
+	mockClient.On("Get", ctx, mock.Anything, mock.Anything).Return(&computepb.Instance{}, mockErr)
+
+	err := gcpCli.ResetInstance(ctx, "garm-instance")
+	assert.NoError(t, err)
+	mockClient.AssertNotCalled(t, "Reset", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestStopInstanceNonRetryable(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	gcpCli := newTestGcpCli(t, mockClient)
+	gcpCli.cfg.RetryBaseDelay = time.Millisecond
+
+	mockErr, _ := apierror.FromError(&googleapi.Error{
+		Code: 400,
+	})
+	mockClient.On("Stop", ctx, mock.Anything, mock.Anything).Return(&compute.Operation{}, mockErr)
+
+	err := gcpCli.StopInstance(ctx, "garm-instance")
+	assert.Error(t, err)
+	mockClient.AssertNumberOfCalls(t, "Stop", 1)
+}
+
+func TestWrapAPIError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected error
+	}{
+		{name: "NotFound", err: &googleapi.Error{Code: 404}, expected: ErrNotFound},
+		{name: "PermissionDenied", err: &googleapi.Error{Code: 403}, expected: ErrPermissionDenied},
+		{name: "QuotaExceeded", err: &googleapi.Error{Code: 403, Errors: []googleapi.ErrorItem{{Reason: "quotaExceeded"}}}, expected: ErrQuotaExceeded},
+		{name: "RateLimitExceeded", err: &googleapi.Error{Code: 403, Errors: []googleapi.ErrorItem{{Reason: "rateLimitExceeded"}}}, expected: ErrRateLimited},
+		{name: "TooManyRequests", err: &googleapi.Error{Code: 429, Errors: []googleapi.ErrorItem{{Reason: "rateLimitExceeded"}}}, expected: ErrRateLimited},
+		{name: "OperationQuotaExceeded", err: &googleapi.Error{Code: 400, Message: "Operation failed: QUOTA_EXCEEDED: Quota 'CPUS' exceeded."}, expected: ErrQuotaExceeded},
+		{name: "ResourcesExhausted", err: &googleapi.Error{Code: 400, Message: "ZONE_RESOURCE_POOL_EXHAUSTED_WITH_DETAILS"}, expected: ErrResourcesExhausted},
+		{name: "AlreadyExists", err: &googleapi.Error{Code: 409, Errors: []googleapi.ErrorItem{{Reason: "alreadyExists"}}}, expected: ErrAlreadyExists},
+		{name: "Unauthenticated", err: &googleapi.Error{Code: 401}, expected: ErrUnauthenticated},
+		{name: "BadRequest", err: &googleapi.Error{Code: 400}, expected: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			apiErr, _ := apierror.FromError(tt.err)
+			err := wrapAPIError(apiErr)
+			for _, sentinel := range []error{ErrNotFound, ErrPermissionDenied, ErrQuotaExceeded, ErrRateLimited, ErrResourcesExhausted, ErrAlreadyExists, ErrUnauthenticated} {
+				assert.Equal(t, sentinel == tt.expected, errors.Is(err, sentinel), "errors.Is(%v)", sentinel)
+			}
+			var asApiErr *apierror.APIError
+			assert.True(t, errors.As(err, &asApiErr), "the original error should be kept")
+		})
+	}
+	assert.NoError(t, wrapAPIError(nil))
+}
+
+func TestPing(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	gcpCli := newTestGcpCli(t, mockClient)
+	NextIt = func(*compute.InstanceIterator) (*computepb.Instance, error) {
+		return nil, iterator.Done
+	}
+
+	mockClient.On("List", ctx, &computepb.ListInstancesRequest{
+		Project:    "my-project",
+		Zone:       "europe-west1-d",
+		MaxResults: proto.Uint32(1),
+	}, mock.Anything).Return(&compute.InstanceIterator{}, nil)
+
+	err := gcpCli.Ping(ctx)
+	assert.NoError(t, err)
+	mockClient.AssertExpectations(t)
+}
+
+func TestPingErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		sentinel error
+		errMsg   string
+	}{
+		{
+			name:     "Unauthenticated",
+			err:      &googleapi.Error{Code: 401, Message: "Request had invalid authentication credentials."},
+			sentinel: ErrUnauthenticated,
+			errMsg:   "failed to authenticate to GCP, check the provider credentials",
+		},
+		{
+			name:     "PermissionDenied",
+			err:      &googleapi.Error{Code: 403, Message: "Required 'compute.instances.list' permission"},
+			sentinel: ErrPermissionDenied,
+			errMsg:   "the provider credentials are not allowed to list instances in project my-project",
+		},
+		{
+			name:     "NotFound",
+			err:      &googleapi.Error{Code: 404, Message: "The resource 'projects/my-project' was not found"},
+			sentinel: ErrNotFound,
+			errMsg:   "project my-project or zone europe-west1-d not found",
+		},
+		{
+			name:   "Other",
+			err:    &googleapi.Error{Code: 500, Message: "Internal error"},
+			errMsg: "failed to reach the compute API",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			mockClient := new(MockGcpClient)
+			gcpCli := newTestGcpCli(t, mockClient)
+			apiErr, _ := apierror.FromError(tt.err)
+			NextIt = func(*compute.InstanceIterator) (*computepb.Instance, error) {
+				return nil, apiErr
+			}
+			mockClient.On("List", ctx, mock.Anything, mock.Anything).Return(&compute.InstanceIterator{}, nil)
+
+			err := gcpCli.Ping(ctx)
+			assert.ErrorContains(t, err, tt.errMsg)
+			if tt.sentinel != nil {
+				assert.ErrorIs(t, err, tt.sentinel)
+			}
+			mockClient.AssertExpectations(t)
+		})
+	}
+}
+
+func TestPingTokenError(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	gcpCli := newTestGcpCli(t, mockClient)
+	NextIt = func(*compute.InstanceIterator) (*computepb.Instance, error) {
+		return nil, &oauth2.RetrieveError{ErrorCode: "invalid_grant"}
+	}
+	mockClient.On("List", ctx, mock.Anything, mock.Anything).Return(&compute.InstanceIterator{}, nil)
+
+	err := gcpCli.Ping(ctx)
+	assert.ErrorContains(t, err, "failed to authenticate to GCP, check the provider credentials")
+	mockClient.AssertExpectations(t)
+}
+
+func TestCreateInstanceQuotaExceeded(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	gcpCli := newTestGcpCli(t, mockClient)
+
+	mockErr, _ := apierror.FromError(&googleapi.Error{
+		Code:   403,
+		Errors: []googleapi.ErrorItem{{Reason: "quotaExceeded"}},
+	})
+	mockClient.On("Insert", ctx, mock.Anything, mock.Anything).Return(&compute.Operation{}, mockErr)
+
+	_, err := gcpCli.CreateInstance(ctx, newTestRunnerSpec(params.Linux))
+	assert.ErrorIs(t, err, ErrQuotaExceeded)
+	assert.NotErrorIs(t, err, ErrPermissionDenied)
+}
+
+func TestCreateInstanceAlreadyExists(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	gcpCli := newTestGcpCli(t, mockClient)
+
+	mockErr, _ := apierror.FromError(&googleapi.Error{
+		Code:   409,
+		Errors: []googleapi.ErrorItem{{Reason: "alreadyExists"}},
+	})
+	existing := &computepb.Instance{
+		Name:   proto.String("garm-instance"),
+		Status: proto.String("RUNNING"),
+	}
+	mockClient.On("Insert", ctx, mock.Anything, mock.Anything).Return(&compute.Operation{}, mockErr)
+	mockClient.On("Get", ctx, &computepb.GetInstanceRequest{
+		Project:  "my-project",
+		Zone:     "europe-west1-d",
+		Instance: "garm-instance",
+	}, mock.Anything).Return(existing, nil)
+
+	inst, err := gcpCli.CreateInstance(ctx, newTestRunnerSpec(params.Linux))
+	assert.NoError(t, err)
+	assert.Equal(t, existing, inst)
+	mockClient.AssertExpectations(t)
+}
+
+func TestCreateInstanceAlreadyExistsGetError(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	gcpCli := newTestGcpCli(t, mockClient)
+
+	insertErr, _ := apierror.FromError(&googleapi.Error{
+		Code: 409,
+	})
+	getErr, _ := apierror.FromError(&googleapi.Error{
+		Code: 403,
+	})
+	mockClient.On("Insert", ctx, mock.Anything, mock.Anything).Return(&compute.Operation{}, insertErr)
+	mockClient.On("Get", ctx, mock.Anything, mock.Anything).Return(&computepb.Instance{}, getErr)
+
+	_, err := gcpCli.CreateInstance(ctx, newTestRunnerSpec(params.Linux))
+	assert.ErrorIs(t, err, ErrAlreadyExists)
+	assert.ErrorContains(t, err, "already exists but could not be retrieved")
+}
+
+func TestCreateInstanceAlreadyExistsWaitForRunning(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	gcpCli := newTestGcpCli(t, mockClient)
+	gcpCli.cfg.WaitForRunning = true
+
+	insertErr, _ := apierror.FromError(&googleapi.Error{
+		Code: 409,
+	})
+	mockClient.On("Insert", ctx, mock.Anything, mock.Anything).Return(&compute.Operation{}, insertErr)
+	mockClient.On("Get", mock.Anything, mock.Anything, mock.Anything).Return(&computepb.Instance{
+		Name:   proto.String("garm-instance"),
+		Status: proto.String("TERMINATED"),
+	}, nil)
+
+	_, err := gcpCli.CreateInstance(ctx, newTestRunnerSpec(params.Linux))
+	assert.ErrorIs(t, err, ErrInstanceNotRunning)
+	mockClient.AssertNumberOfCalls(t, "Get", 2)
+}
+
+func TestDeleteInstancePermissionDenied(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	gcpCli := newTestGcpCli(t, mockClient)
+
+	mockErr, _ := apierror.FromError(&googleapi.Error{
+		Code: 403,
+	})
+	mockClient.On("Get", ctx, mock.Anything, mock.Anything).Return(&computepb.Instance{}, nil)
+	mockClient.On("Delete", ctx, mock.Anything, mock.Anything).Return(&compute.Operation{}, mockErr)
+
+	err := gcpCli.DeleteInstance(ctx, "garm-instance")
+	assert.ErrorIs(t, err, ErrPermissionDenied)
+}
+
+func TestGetInstanceNotFound(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	gcpCli := newTestGcpCli(t, mockClient)
+
+	mockErr, _ := apierror.FromError(&googleapi.Error{
+		Code: 404,
+	})
+	mockClient.On("Get", ctx, mock.Anything, mock.Anything).Return(&computepb.Instance{}, mockErr)
+
+	_, err := gcpCli.GetInstance(ctx, "garm-instance")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestIsRetryableError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      *googleapi.Error
+		expected bool
+	}{
+		{name: "TooManyRequests", err: &googleapi.Error{Code: 429}, expected: true},
+		{name: "InternalServerError", err: &googleapi.Error{Code: 500}, expected: true},
+		{name: "BadGateway", err: &googleapi.Error{Code: 502}, expected: true},
+		{name: "ServiceUnavailable", err: &googleapi.Error{Code: 503}, expected: true},
+		{name: "RateLimitExceeded", err: &googleapi.Error{Code: 403, Errors: []googleapi.ErrorItem{{Reason: "rateLimitExceeded"}}}, expected: true},
+		{name: "BadRequest", err: &googleapi.Error{Code: 400}, expected: false},
+		{name: "Forbidden", err: &googleapi.Error{Code: 403}, expected: false},
+		{name: "NotFound", err: &googleapi.Error{Code: 404}, expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			apiErr, _ := apierror.FromError(tt.err)
+			assert.Equal(t, tt.expected, isRetryableError(apiErr))
+		})
+	}
+	assert.False(t, isRetryableError(errors.New("not an API error")))
+}
+
+func TestCreateInstanceOperationTimeout(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	gcpCli := newTestGcpCli(t, mockClient)
+	gcpCli.cfg.OperationTimeout = 10 * time.Millisecond
+	mockClient.On("Insert", mock.Anything, mock.Anything, mock.Anything).Return(&compute.Operation{}, nil)
+
+	_, err := gcpCli.CreateInstance(ctx, newTestRunnerSpec(params.Linux))
+	assert.ErrorContains(t, err, "timed out after 10ms waiting for insert operation on instance garm-instance")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	mockClient.AssertExpectations(t)
+}
+
+func TestDeleteInstanceOperationTimeout(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	gcpCli := newTestGcpCli(t, mockClient)
+	gcpCli.cfg.OperationTimeout = 10 * time.Millisecond
+	mockClient.On("Get", ctx, mock.Anything, mock.Anything).Return(&computepb.Instance{}, nil)
+	mockClient.On("Delete", ctx, mock.Anything, mock.Anything).Return(&compute.Operation{}, nil)
+
+	err := gcpCli.DeleteInstance(ctx, "garm-instance")
+	assert.ErrorContains(t, err, "waiting for delete operation on instance garm-instance")
+	mockClient.AssertExpectations(t)
+}
+
+func TestDeleteInstanceParentContextDone(t *testing.T) {
+	tests := []struct {
+		name     string
+		ctx      func() (context.Context, context.CancelFunc)
+		expected error
+	}{
+		{
+			name: "Cancelled",
+			ctx: func() (context.Context, context.CancelFunc) {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				return ctx, cancel
+			},
+			expected: context.Canceled,
+		},
+		{
+			name: "DeadlineExceeded",
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 10*time.Millisecond)
+			},
+			expected: context.DeadlineExceeded,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := tt.ctx()
+			defer cancel()
+			mockClient := new(MockGcpClient)
+			WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+				<-ctx.Done()
+				return ctx.Err()
+			}
+			gcpCli := newTestGcpCli(t, mockClient)
+			gcpCli.cfg.OperationTimeout = time.Hour
+			mockClient.On("Get", mock.Anything, mock.Anything, mock.Anything).Return(&computepb.Instance{}, nil)
+			mockClient.On("Delete", mock.Anything, mock.Anything, mock.Anything).Return(&compute.Operation{}, nil)
+
+			err := gcpCli.DeleteInstance(ctx, "garm-instance")
+			assert.ErrorIs(t, err, tt.expected)
+			assert.NotContains(t, err.Error(), "timed out after")
+		})
+	}
+}
+
+func TestGetSerialPortOutput(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	gcpCli := newTestGcpCli(t, mockClient)
+
+	mockClient.On("GetSerialPortOutput", ctx, &computepb.GetSerialPortOutputInstanceRequest{
+		Project:  gcpCli.cfg.ProjectId,
+		Zone:     gcpCli.cfg.Zone,
+		Instance: "garm-instance",
+		Port:     proto.Int32(1),
+	}, mock.Anything).Return(&computepb.SerialPortOutput{
+		Contents: proto.String("MockSerialOutput"),
+	}, nil)
+
+	output, err := gcpCli.GetSerialPortOutput(ctx, "Garm-Instance", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "MockSerialOutput", output)
+	mockClient.AssertExpectations(t)
+}
+
+func TestCreateInstanceSingleNetworkInterface(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return nil
+	}
+	gcpCli := newTestGcpCli(t, mockClient)
+	mockClient.On("Insert", mock.Anything, mock.Anything, mock.Anything).Return(&compute.Operation{}, nil)
+
+	runnerSpec := newTestRunnerSpec(params.Linux)
+	runnerSpec.NetworkID = "my-other-network"
+	result, err := gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.Len(t, result.NetworkInterfaces, 1)
+	assert.Equal(t, "projects/my-project/global/networks/my-other-network", result.NetworkInterfaces[0].GetNetwork())
+	assert.Equal(t, "projects/my-project/regions/europe-west1/subnetworks/my-subnetwork", result.NetworkInterfaces[0].GetSubnetwork())
+	assert.Equal(t, "VIRTIO_NET", result.NetworkInterfaces[0].GetNicType())
+	assert.Len(t, result.NetworkInterfaces[0].AccessConfigs, 1)
+
+	gcpCli.cfg.ExternalIPAccess = false
+	result, err = gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.Nil(t, result.NetworkInterfaces[0].AccessConfigs)
+	mockClient.AssertExpectations(t)
+}
+
+func TestCreateInstanceMultipleNetworkInterfaces(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return nil
+	}
+	gcpCli := newTestGcpCli(t, mockClient)
+	mockClient.On("Insert", mock.Anything, mock.Anything, mock.Anything).Return(&compute.Operation{}, nil)
+
+	runnerSpec := newTestRunnerSpec(params.Linux)
+	runnerSpec.NetworkInterfaces = []spec.NetworkInterface{
+		{
+			NetworkID:    "primary-network",
+			SubnetworkID: "primary-subnetwork",
+			NicType:      "GVNIC",
+		},
+		{
+			SubnetworkID: "secondary-subnetwork",
+			NicType:      "VIRTIO_NET",
+			AliasIPRanges: []spec.AliasIPRange{
+				{
+					IPCidrRange:         "/24",
+					SubnetworkRangeName: "pods",
+				},
+			},
+		},
+	}
+
+	result, err := gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.Len(t, result.NetworkInterfaces, 2)
+
+	primary := result.NetworkInterfaces[0]
+	assert.Equal(t, "projects/my-project/global/networks/primary-network", primary.GetNetwork())
+	assert.Equal(t, "projects/my-project/regions/europe-west1/subnetworks/primary-subnetwork", primary.GetSubnetwork())
+	assert.Equal(t, "GVNIC", primary.GetNicType())
+	assert.Len(t, primary.AccessConfigs, 1)
+
+	secondary := result.NetworkInterfaces[1]
+	assert.Nil(t, secondary.Network)
+	assert.Equal(t, "projects/my-project/regions/europe-west1/subnetworks/secondary-subnetwork", secondary.GetSubnetwork())
+	assert.Equal(t, "VIRTIO_NET", secondary.GetNicType())
+	assert.Nil(t, secondary.AccessConfigs)
+	assert.Len(t, secondary.AliasIpRanges, 1)
+	assert.Equal(t, "/24", secondary.AliasIpRanges[0].GetIpCidrRange())
+	assert.Equal(t, "pods", secondary.AliasIpRanges[0].GetSubnetworkRangeName())
+	mockClient.AssertExpectations(t)
+}
+
+func TestCreateInstanceAdditionalDisks(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return nil
+	}
+	gcpCli := newTestGcpCli(t, mockClient)
+	mockClient.On("Insert", mock.Anything, mock.Anything, mock.Anything).Return(&compute.Operation{}, nil)
+
+	runnerSpec := newTestRunnerSpec(params.Linux)
+	runnerSpec.AdditionalDisks = []spec.AdditionalDisk{
+		{
+			SizeGB: 100,
+			Type:   "zones/europe-west1-d/diskTypes/pd-ssd",
+		},
+		{
+			SizeGB:         200,
+			SourceSnapshot: "projects/garm-testing/global/snapshots/garm-data",
+			AutoDelete:     proto.Bool(false),
+		},
+	}
+
+	result, err := gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.Len(t, result.Disks, 3)
+
+	assert.True(t, result.Disks[0].GetBoot())
+	assert.Equal(t, int64(50), result.Disks[0].InitializeParams.GetDiskSizeGb())
+
+	assert.False(t, result.Disks[1].GetBoot())
+	assert.Equal(t, int64(100), result.Disks[1].InitializeParams.GetDiskSizeGb())
+	assert.Equal(t, "zones/europe-west1-d/diskTypes/pd-ssd", result.Disks[1].InitializeParams.GetDiskType())
+	assert.Nil(t, result.Disks[1].InitializeParams.SourceSnapshot)
+	assert.True(t, result.Disks[1].GetAutoDelete())
+
+	assert.False(t, result.Disks[2].GetBoot())
+	assert.Equal(t, int64(200), result.Disks[2].InitializeParams.GetDiskSizeGb())
+	assert.Equal(t, "projects/garm-testing/global/snapshots/garm-data", result.Disks[2].InitializeParams.GetSourceSnapshot())
+	assert.False(t, result.Disks[2].GetAutoDelete())
+	mockClient.AssertExpectations(t)
+}
+
+func TestCreateInstanceLocalSSDs(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return nil
+	}
+	gcpCli := newTestGcpCli(t, mockClient)
+	mockClient.On("Insert", mock.Anything, mock.Anything, mock.Anything).Return(&compute.Operation{}, nil)
+
+	runnerSpec := newTestRunnerSpec(params.Linux)
+	runnerSpec.LocalSSDCount = 2
+
+	result, err := gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.Len(t, result.Disks, 3)
+	assert.True(t, result.Disks[0].GetBoot())
+	for _, disk := range result.Disks[1:] {
+		assert.Equal(t, "SCRATCH", disk.GetType())
+		assert.Equal(t, "NVME", disk.GetInterface())
+		assert.Equal(t, "zones/europe-west1-d/diskTypes/local-ssd", disk.InitializeParams.GetDiskType())
+		assert.Nil(t, disk.InitializeParams.DiskSizeGb)
+		assert.True(t, disk.GetAutoDelete())
+	}
+	mockClient.AssertExpectations(t)
+}
+
+func TestCreateInstanceBootDiskAutoDelete(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return nil
+	}
+	gcpCli := newTestGcpCli(t, mockClient)
+	mockClient.On("Insert", mock.Anything, mock.Anything, mock.Anything).Return(&compute.Operation{}, nil)
+
+	runnerSpec := newTestRunnerSpec(params.Linux)
+	result, err := gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.True(t, result.Disks[0].GetAutoDelete())
+
+	runnerSpec.BootDiskAutoDelete = proto.Bool(false)
+	result, err = gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.NotNil(t, result.Disks[0].AutoDelete)
+	assert.False(t, result.Disks[0].GetAutoDelete())
+	mockClient.AssertExpectations(t)
+}
+
+func TestCreateInstanceGVNIC(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	mockImagesClient := new(MockImagesClient)
+	WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return nil
+	}
+	gcpCli := newTestGcpCli(t, mockClient)
+	gcpCli.SetImagesClient(mockImagesClient)
+	mockClient.On("Insert", mock.Anything, mock.Anything, mock.Anything).Return(&compute.Operation{}, nil)
+	mockImagesClient.On("Get", ctx, &computepb.GetImageRequest{
+		Project: "garm-testing",
+		Image:   "garm-image",
+	}, mock.Anything).Return(&computepb.Image{
+		GuestOsFeatures: []*computepb.GuestOsFeature{{Type: proto.String("GVNIC")}},
+	}, nil)
+
+	runnerSpec := newTestRunnerSpec(params.Linux)
+	runnerSpec.NicType = "GVNIC"
+	result, err := gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.Equal(t, "GVNIC", result.NetworkInterfaces[0].GetNicType())
+	mockClient.AssertExpectations(t)
+	mockImagesClient.AssertExpectations(t)
+}
+
+func TestMissingNicGuestOSFeatures(t *testing.T) {
+	ctx := context.Background()
+	gvnicImage := &computepb.Image{
+		GuestOsFeatures: []*computepb.GuestOsFeature{
+			{Type: proto.String("UEFI_COMPATIBLE")},
+			{Type: proto.String("GVNIC")},
+		},
+	}
+	tests := []struct {
+		name     string
+		setup    func(runnerSpec *spec.RunnerSpec, images *MockImagesClient)
+		expected []string
+	}{
+		{
+			name: "VirtioNetSkipsCheck",
+			setup: func(runnerSpec *spec.RunnerSpec, images *MockImagesClient) {
+				runnerSpec.NicType = "VIRTIO_NET"
+			},
+			expected: nil,
+		},
+		{
+			name: "ImageSupportsGVNIC",
+			setup: func(runnerSpec *spec.RunnerSpec, images *MockImagesClient) {
+				runnerSpec.NicType = "GVNIC"
+				images.On("Get", ctx, mock.Anything, mock.Anything).Return(gvnicImage, nil)
+			},
+			expected: nil,
+		},
+		{
+			name: "ImageWithoutGVNIC",
+			setup: func(runnerSpec *spec.RunnerSpec, images *MockImagesClient) {
+				runnerSpec.NicType = "GVNIC"
+				images.On("Get", ctx, mock.Anything, mock.Anything).Return(&computepb.Image{}, nil)
+			},
+			expected: []string{"GVNIC"},
+		},
+		{
+			name: "ImageFamily",
+			setup: func(runnerSpec *spec.RunnerSpec, images *MockImagesClient) {
+				runnerSpec.BootstrapParams.Image = "projects/ubuntu-os-cloud/global/images/family/ubuntu-2204-lts"
+				runnerSpec.NetworkInterfaces = []spec.NetworkInterface{
+					{SubnetworkID: "primary", NicType: "GVNIC"},
+					{SubnetworkID: "secondary", NicType: "IDPF"},
+				}
+				images.On("GetFromFamily", ctx, &computepb.GetFromFamilyImageRequest{
+					Project: "ubuntu-os-cloud",
+					Family:  "ubuntu-2204-lts",
+				}, mock.Anything).Return(gvnicImage, nil)
+			},
+			expected: []string{"IDPF"},
+		},
+		{
+			name: "ImageNotFound",
+			setup: func(runnerSpec *spec.RunnerSpec, images *MockImagesClient) {
+				runnerSpec.NicType = "GVNIC"
+				images.On("Get", ctx, mock.Anything, mock.Anything).Return(&computepb.Image{}, errors.New("not found"))
+			},
+			expected: nil,
+		},
+		{
+			name: "SourceSnapshotSkipsCheck",
+			setup: func(runnerSpec *spec.RunnerSpec, images *MockImagesClient) {
+				runnerSpec.NicType = "GVNIC"
+				runnerSpec.SourceSnapshot = "projects/garm-testing/global/snapshots/garm-snapshot"
+			},
+			expected: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockImagesClient := new(MockImagesClient)
+			gcpCli := newTestGcpCli(t, new(MockGcpClient))
+			gcpCli.SetImagesClient(mockImagesClient)
+			runnerSpec := newTestRunnerSpec(params.Linux)
+			tt.setup(runnerSpec, mockImagesClient)
+
+			assert.Equal(t, tt.expected, gcpCli.missingNicGuestOSFeatures(ctx, runnerSpec))
+			mockImagesClient.AssertExpectations(t)
+		})
+	}
+}
+
+func TestCreateInstanceBootDiskSize(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return nil
+	}
+	gcpCli := newTestGcpCli(t, mockClient)
+	mockClient.On("Insert", mock.Anything, mock.Anything, mock.Anything).Return(&compute.Operation{}, nil)
+
+	runnerSpec := newTestRunnerSpec(params.Linux)
+	result, err := gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(50), result.Disks[0].InitializeParams.GetDiskSizeGb())
+
+	for _, size := range []int64{0, -1} {
+		runnerSpec.DiskSize = size
+		result, err = gcpCli.CreateInstance(ctx, runnerSpec)
+		assert.NoError(t, err)
+		assert.Nil(t, result.Disks[0].InitializeParams.DiskSizeGb)
+	}
+	mockClient.AssertExpectations(t)
+}
+
+func TestCreateInstanceBootDiskSource(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return nil
+	}
+	gcpCli := newTestGcpCli(t, mockClient)
+	mockClient.On("Insert", mock.Anything, mock.Anything, mock.Anything).Return(&compute.Operation{}, nil)
+
+	runnerSpec := newTestRunnerSpec(params.Linux)
+	runnerSpec.BootstrapParams.Image = "projects/garm-testing/global/images/garm-image"
+	runnerSpec.SourceSnapshot = ""
+	result, err := gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.Equal(t, "projects/garm-testing/global/images/garm-image", result.Disks[0].InitializeParams.GetSourceImage())
+	assert.Nil(t, result.Disks[0].InitializeParams.SourceSnapshot)
+
+	runnerSpec.BootstrapParams.Image = ""
+	runnerSpec.SourceSnapshot = "projects/garm-testing/global/snapshots/garm-snapshot"
+	result, err = gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.Equal(t, "projects/garm-testing/global/snapshots/garm-snapshot", result.Disks[0].InitializeParams.GetSourceSnapshot())
+	assert.Nil(t, result.Disks[0].InitializeParams.SourceImage)
+	mockClient.AssertExpectations(t)
+}
+
+func TestCreateInstanceSourceMachineImage(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return nil
+	}
+	gcpCli := newTestGcpCli(t, mockClient)
+	mockClient.On("Insert", mock.Anything, mock.Anything, mock.Anything).Return(&compute.Operation{}, nil)
+
+	runnerSpec := newTestRunnerSpec(params.Linux)
+	result, err := gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.Nil(t, result.SourceMachineImage)
+	assert.True(t, result.Disks[0].GetBoot())
+
+	runnerSpec.BootstrapParams.Image = ""
+	runnerSpec.SourceSnapshot = ""
+	runnerSpec.SourceMachineImage = "projects/garm-testing/global/machineImages/garm-runner"
+	runnerSpec.AdditionalDisks = []spec.AdditionalDisk{{SizeGB: 100}}
+	result, err = gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.Equal(t, "projects/garm-testing/global/machineImages/garm-runner", result.GetSourceMachineImage())
+	assert.Len(t, result.Disks, 1)
+	assert.False(t, result.Disks[0].GetBoot())
+	assert.Equal(t, util.GetMachineType("europe-west1-d", "n1-standard-1"), result.GetMachineType())
+	mockClient.AssertExpectations(t)
+}
+
+func TestCreateInstanceLogsOperation(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return nil
+	}
+	gcpCli := newTestGcpCli(t, mockClient)
+	OperationProto = func(op *compute.Operation) *computepb.Operation {
+		return &computepb.Operation{
+			Name:     proto.String("operation-1719837000000-abcdef"),
+			SelfLink: proto.String("https://www.googleapis.com/compute/v1/projects/my-project/zones/europe-west1-d/operations/operation-1719837000000-abcdef"),
+		}
+	}
+	defaultLogger := slog.Default()
+	defer slog.SetDefault(defaultLogger)
+	var logs bytes.Buffer
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	mockClient.On("Insert", mock.Anything, mock.Anything, mock.Anything).Return(&compute.Operation{}, nil)
+
+	_, err := gcpCli.CreateInstance(ctx, newTestRunnerSpec(params.Linux))
+	assert.NoError(t, err)
+	assert.Contains(t, logs.String(), "operation=operation-1719837000000-abcdef")
+	assert.Contains(t, logs.String(), "self_link=https://www.googleapis.com/compute/v1/projects/my-project/zones/europe-west1-d/operations/operation-1719837000000-abcdef")
+	assert.Contains(t, logs.String(), "instance=garm-instance")
+	mockClient.AssertExpectations(t)
+}
+
+func TestCreateInstanceWaitForRunning(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return nil
+	}
+	gcpCli := newTestGcpCli(t, mockClient)
+	gcpCli.cfg.WaitForRunning = true
+	gcpCli.cfg.PollInterval = time.Millisecond
+	getReq := &computepb.GetInstanceRequest{
+		Project:  "my-project",
+		Zone:     "europe-west1-d",
+		Instance: "garm-instance",
+	}
+	mockClient.On("Insert", mock.Anything, mock.Anything, mock.Anything).Return(&compute.Operation{}, nil)
+	mockClient.On("Get", mock.Anything, getReq, mock.Anything).Return(&computepb.Instance{
+		Name:   proto.String("garm-instance"),
+		Status: proto.String("PROVISIONING"),
+	}, nil).Once()
+	mockClient.On("Get", mock.Anything, getReq, mock.Anything).Return(&computepb.Instance{
+		Name:   proto.String("garm-instance"),
+		Status: proto.String("RUNNING"),
+	}, nil).Once()
+
+	result, err := gcpCli.CreateInstance(ctx, newTestRunnerSpec(params.Linux))
+	assert.NoError(t, err)
+	assert.Equal(t, "RUNNING", result.GetStatus())
+	mockClient.AssertExpectations(t)
+	mockClient.AssertNumberOfCalls(t, "Get", 2)
+}
+
+func TestCreateInstanceWaitForRunningStopped(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return nil
+	}
+	gcpCli := newTestGcpCli(t, mockClient)
+	gcpCli.cfg.WaitForRunning = true
+	mockClient.On("Insert", mock.Anything, mock.Anything, mock.Anything).Return(&compute.Operation{}, nil)
+	mockClient.On("Get", mock.Anything, mock.Anything, mock.Anything).Return(&computepb.Instance{
+		Name:   proto.String("garm-instance"),
+		Status: proto.String("TERMINATED"),
+	}, nil)
+
+	_, err := gcpCli.CreateInstance(ctx, newTestRunnerSpec(params.Linux))
+	assert.ErrorIs(t, err, ErrInstanceNotRunning)
+	assert.ErrorContains(t, err, "instance garm-instance is TERMINATED instead of RUNNING")
+	mockClient.AssertExpectations(t)
+}
+
+func TestCreateInstanceWaitForRunningTimeout(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return nil
+	}
+	gcpCli := newTestGcpCli(t, mockClient)
+	gcpCli.cfg.WaitForRunning = true
+	gcpCli.cfg.PollInterval = time.Millisecond
+	gcpCli.cfg.StatusTimeout = 20 * time.Millisecond
+	mockClient.On("Insert", mock.Anything, mock.Anything, mock.Anything).Return(&compute.Operation{}, nil)
+	mockClient.On("Get", mock.Anything, mock.Anything, mock.Anything).Return(&computepb.Instance{
+		Name:   proto.String("garm-instance"),
+		Status: proto.String("STAGING"),
+	}, nil)
+
+	_, err := gcpCli.CreateInstance(ctx, newTestRunnerSpec(params.Linux))
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.ErrorIs(t, err, ErrInstanceNotRunning)
+	assert.ErrorContains(t, err, "waiting for instance garm-instance to be running (current status: STAGING)")
+	mockClient.AssertExpectations(t)
+}
+
+func TestCreateInstanceBootDiskNames(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return nil
+	}
+	gcpCli := newTestGcpCli(t, mockClient)
+	mockClient.On("Insert", mock.Anything, mock.Anything, mock.Anything).Return(&compute.Operation{}, nil)
+
+	runnerSpec := newTestRunnerSpec(params.Linux)
+	result, err := gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.Nil(t, result.Disks[0].DeviceName)
+	assert.Nil(t, result.Disks[0].InitializeParams.DiskName)
+
+	runnerSpec.BootDiskName = "garm-boot-disk"
+	runnerSpec.BootDiskDeviceName = "runner-root"
+	result, err = gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.Equal(t, "garm-boot-disk", result.Disks[0].InitializeParams.GetDiskName())
+	assert.Equal(t, "runner-root", result.Disks[0].GetDeviceName())
+	mockClient.AssertExpectations(t)
+}
+
+func TestCreateInstanceDiskEncryptionKey(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return nil
+	}
+	gcpCli := newTestGcpCli(t, mockClient)
+	mockClient.On("Insert", mock.Anything, mock.Anything, mock.Anything).Return(&compute.Operation{}, nil)
+
+	runnerSpec := newTestRunnerSpec(params.Linux)
+	result, err := gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.Nil(t, result.Disks[0].DiskEncryptionKey)
+
+	runnerSpec.DiskEncryptionKey = "projects/my-project/locations/europe-west1/keyRings/garm/cryptoKeys/runners"
+	result, err = gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.Equal(t, runnerSpec.DiskEncryptionKey, result.Disks[0].GetDiskEncryptionKey().GetKmsKeyName())
+	mockClient.AssertExpectations(t)
+}
+
+func TestCreateInstanceBootDiskInterface(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return nil
+	}
+	gcpCli := newTestGcpCli(t, mockClient)
+	mockClient.On("Insert", mock.Anything, mock.Anything, mock.Anything).Return(&compute.Operation{}, nil)
+
+	runnerSpec := newTestRunnerSpec(params.Linux)
+	result, err := gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.Nil(t, result.Disks[0].Interface)
+
+	runnerSpec.BootDiskInterface = "NVME"
+	result, err = gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.True(t, result.Disks[0].GetBoot())
+	assert.Equal(t, "NVME", result.Disks[0].GetInterface())
+	mockClient.AssertExpectations(t)
+}
+
+func TestCreateInstanceProvisionedPerformance(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return nil
+	}
+	gcpCli := newTestGcpCli(t, mockClient)
+	mockClient.On("Insert", mock.Anything, mock.Anything, mock.Anything).Return(&compute.Operation{}, nil)
+
+	runnerSpec := newTestRunnerSpec(params.Linux)
+	result, err := gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.Nil(t, result.Disks[0].InitializeParams.ProvisionedIops)
+	assert.Nil(t, result.Disks[0].InitializeParams.ProvisionedThroughput)
+
+	runnerSpec.DiskType = "zones/europe-west1-d/diskTypes/hyperdisk-balanced"
+	runnerSpec.ProvisionedIops = 5000
+	runnerSpec.ProvisionedThroughput = 250
+	result, err = gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5000), result.Disks[0].InitializeParams.GetProvisionedIops())
+	assert.Equal(t, int64(250), result.Disks[0].InitializeParams.GetProvisionedThroughput())
+	mockClient.AssertExpectations(t)
+}
+
+func TestCreateInstanceConfidentialCompute(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return nil
+	}
+	gcpCli := newTestGcpCli(t, mockClient)
+	mockClient.On("Insert", mock.Anything, mock.Anything, mock.Anything).Return(&compute.Operation{}, nil)
+
+	runnerSpec := newTestRunnerSpec(params.Linux)
+	result, err := gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.Nil(t, result.ConfidentialInstanceConfig)
+	assert.Nil(t, result.Scheduling)
+
+	runnerSpec.BootstrapParams.Flavor = "n2d-standard-2"
+	runnerSpec.EnableConfidentialCompute = true
+	result, err = gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.True(t, result.ConfidentialInstanceConfig.GetEnableConfidentialCompute())
+	assert.Equal(t, "TERMINATE", result.Scheduling.GetOnHostMaintenance())
+	assert.Nil(t, result.Scheduling.ProvisioningModel)
+	mockClient.AssertExpectations(t)
+}
+
+func TestCreateInstanceMaintenanceScheduling(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return nil
+	}
+	gcpCli := newTestGcpCli(t, mockClient)
+	mockClient.On("Insert", mock.Anything, mock.Anything, mock.Anything).Return(&compute.Operation{}, nil)
+
+	runnerSpec := newTestRunnerSpec(params.Linux)
+	runnerSpec.OnHostMaintenance = "TERMINATE"
+	runnerSpec.AutomaticRestart = proto.Bool(false)
+	result, err := gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.Equal(t, "TERMINATE", result.Scheduling.GetOnHostMaintenance())
+	assert.NotNil(t, result.Scheduling.AutomaticRestart)
+	assert.False(t, result.Scheduling.GetAutomaticRestart())
+	assert.Nil(t, result.Scheduling.ProvisioningModel)
+	assert.Nil(t, result.Scheduling.Preemptible)
+
+	runnerSpec.OnHostMaintenance = "MIGRATE"
+	runnerSpec.AutomaticRestart = nil
+	result, err = gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.Equal(t, "MIGRATE", result.Scheduling.GetOnHostMaintenance())
+	assert.Nil(t, result.Scheduling.AutomaticRestart)
+	mockClient.AssertExpectations(t)
+}
+
+func TestDeleteInstanceGetNotFound(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	gcpCli := newTestGcpCli(t, mockClient)
+
+	mockErr, _ := apierror.FromError(&googleapi.Error{
+		Code: 404,
+	})
+	mockClient.On("Get", ctx, mock.Anything, mock.Anything).Return((*computepb.Instance)(nil), mockErr)
+
+	err := gcpCli.DeleteInstance(ctx, "garm-instance")
+	assert.NoError(t, err)
+	mockClient.AssertExpectations(t)
+	mockClient.AssertNotCalled(t, "Delete", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestCreateInstanceDeletionProtection(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return nil
+	}
+	gcpCli := newTestGcpCli(t, mockClient)
+	mockClient.On("Insert", mock.Anything, mock.Anything, mock.Anything).Return(&compute.Operation{}, nil)
+
+	runnerSpec := newTestRunnerSpec(params.Linux)
+	result, err := gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.Nil(t, result.DeletionProtection)
+
+	runnerSpec.DeletionProtection = true
+	result, err = gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.True(t, result.GetDeletionProtection())
+	mockClient.AssertExpectations(t)
+}
+
+func TestDeleteInstanceDeletionProtection(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return nil
+	}
+	gcpCli := newTestGcpCli(t, mockClient)
+
+	var calls []string
+	mockClient.On("Get", ctx, mock.Anything, mock.Anything).Return(&computepb.Instance{
+		Name:               proto.String("garm-instance"),
+		DeletionProtection: proto.Bool(true),
+	}, nil)
+	mockClient.On("SetDeletionProtection", ctx, &computepb.SetDeletionProtectionInstanceRequest{
+		Project:            gcpCli.cfg.ProjectId,
+		Zone:               gcpCli.cfg.Zone,
+		Resource:           "garm-instance",
+		DeletionProtection: proto.Bool(false),
+	}, mock.Anything).Run(func(args mock.Arguments) {
+		calls = append(calls, "SetDeletionProtection")
+	}).Return(&compute.Operation{}, nil)
+	mockClient.On("Delete", ctx, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		calls = append(calls, "Delete")
+	}).Return(&compute.Operation{}, nil)
+
+	err := gcpCli.DeleteInstance(ctx, "garm-instance")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"SetDeletionProtection", "Delete"}, calls)
+	mockClient.AssertExpectations(t)
+}
+
+func TestCreateInstanceInvalidZone(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	gcpCli := newTestGcpCli(t, mockClient)
+
+	runnerSpec := newTestRunnerSpec(params.Linux)
+	runnerSpec.Zone = "europe-west1"
+	_, err := gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.EqualError(t, err, `failed to get region: invalid zone "europe-west1"`)
+	mockClient.AssertNotCalled(t, "Insert", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestCreateInstanceHostProject(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return nil
+	}
+	gcpCli := newTestGcpCli(t, mockClient)
+	mockClient.On("Insert", mock.Anything, mock.Anything, mock.Anything).Return(&compute.Operation{}, nil)
+
+	runnerSpec := newTestRunnerSpec(params.Linux)
+	runnerSpec.HostProjectID = "my-host-project"
+	result, err := gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.Equal(t, "projects/my-host-project/global/networks/my-network", result.NetworkInterfaces[0].GetNetwork())
+	assert.Equal(t, "projects/my-host-project/regions/europe-west1/subnetworks/my-subnetwork", result.NetworkInterfaces[0].GetSubnetwork())
+
+	runnerSpec.NetworkInterfaces = []spec.NetworkInterface{
+		{
+			NetworkID:    "projects/other-host-project/global/networks/shared",
+			SubnetworkID: "projects/other-host-project/regions/europe-west1/subnetworks/shared",
+			NicType:      "VIRTIO_NET",
+		},
+		{SubnetworkID: "secondary", NicType: "VIRTIO_NET"},
+	}
+	result, err = gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	// Full paths are used as they are.
+	assert.Equal(t, "projects/other-host-project/global/networks/shared", result.NetworkInterfaces[0].GetNetwork())
+	assert.Equal(t, "projects/other-host-project/regions/europe-west1/subnetworks/shared", result.NetworkInterfaces[0].GetSubnetwork())
+	assert.Nil(t, result.NetworkInterfaces[1].Network)
+	assert.Equal(t, "projects/my-host-project/regions/europe-west1/subnetworks/secondary", result.NetworkInterfaces[1].GetSubnetwork())
+	mockClient.AssertExpectations(t)
+}
+
+func TestCreateInstanceNetworkURLs(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return nil
+	}
+	gcpCli := newTestGcpCli(t, mockClient)
+	mockClient.On("Insert", mock.Anything, mock.Anything, mock.Anything).Return(&compute.Operation{}, nil)
+
+	result, err := gcpCli.CreateInstance(ctx, newTestRunnerSpec(params.Linux))
+	assert.NoError(t, err)
+	assert.Equal(t, "projects/my-project/global/networks/my-network", result.NetworkInterfaces[0].GetNetwork())
+	assert.Equal(t, "projects/my-project/regions/europe-west1/subnetworks/my-subnetwork", result.NetworkInterfaces[0].GetSubnetwork())
+	mockClient.AssertExpectations(t)
+}
+
+func TestCreateInstanceNetworkIP(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return nil
+	}
+	gcpCli := newTestGcpCli(t, mockClient)
+	mockClient.On("Insert", mock.Anything, mock.Anything, mock.Anything).Return(&compute.Operation{}, nil)
+
+	runnerSpec := newTestRunnerSpec(params.Linux)
+	result, err := gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.Nil(t, result.NetworkInterfaces[0].NetworkIP)
+
+	runnerSpec.NetworkIP = "10.10.0.5"
+	result, err = gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.Equal(t, "10.10.0.5", result.NetworkInterfaces[0].GetNetworkIP())
+	mockClient.AssertExpectations(t)
+}
+
+func TestCreateInstanceExternalIP(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return nil
+	}
+	gcpCli := newTestGcpCli(t, mockClient)
+	mockClient.On("Insert", mock.Anything, mock.Anything, mock.Anything).Return(&compute.Operation{}, nil)
+
+	runnerSpec := newTestRunnerSpec(params.Linux)
+	result, err := gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.Nil(t, result.NetworkInterfaces[0].AccessConfigs[0].NatIP)
+
+	runnerSpec.ExternalIP = "203.0.113.10"
+	result, err = gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.Equal(t, "203.0.113.10", result.NetworkInterfaces[0].AccessConfigs[0].GetNatIP())
+
+	gcpCli.cfg.ExternalIPAccess = false
+	result, err = gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.Empty(t, result.NetworkInterfaces[0].AccessConfigs)
+	mockClient.AssertExpectations(t)
+}
+
+func TestCreateInstanceExternalIPNetworkTier(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return nil
+	}
+	gcpCli := newTestGcpCli(t, mockClient)
+	mockClient.On("Insert", mock.Anything, mock.Anything, mock.Anything).Return(&compute.Operation{}, nil)
+
+	runnerSpec := newTestRunnerSpec(params.Linux)
+	result, err := gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.Nil(t, result.NetworkInterfaces[0].AccessConfigs[0].NetworkTier)
+
+	runnerSpec.ExternalIPNetworkTier = "STANDARD"
+	result, err = gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.Equal(t, "STANDARD", result.NetworkInterfaces[0].AccessConfigs[0].GetNetworkTier())
+	mockClient.AssertExpectations(t)
+}
+
+func TestCreateInstanceAccessConfigName(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return nil
+	}
+	gcpCli := newTestGcpCli(t, mockClient)
+	mockClient.On("Insert", mock.Anything, mock.Anything, mock.Anything).Return(&compute.Operation{}, nil)
+
+	runnerSpec := newTestRunnerSpec(params.Linux)
+	result, err := gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.Nil(t, result.NetworkInterfaces[0].AccessConfigs[0].Name)
+
+	runnerSpec.AccessConfigName = "External NAT"
+	result, err = gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.Equal(t, "External NAT", result.NetworkInterfaces[0].AccessConfigs[0].GetName())
+	mockClient.AssertExpectations(t)
+}
+
+func TestCreateInstanceEnableIPv6(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return nil
+	}
+	gcpCli := newTestGcpCli(t, mockClient)
+	mockClient.On("Insert", mock.Anything, mock.Anything, mock.Anything).Return(&compute.Operation{}, nil)
+
+	runnerSpec := newTestRunnerSpec(params.Linux)
+	result, err := gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.Nil(t, result.NetworkInterfaces[0].StackType)
+	assert.Empty(t, result.NetworkInterfaces[0].Ipv6AccessConfigs)
+
+	runnerSpec.EnableIPv6 = true
+	runnerSpec.NetworkInterfaces = []spec.NetworkInterface{
+		{SubnetworkID: "primary", NicType: "VIRTIO_NET"},
+		{SubnetworkID: "secondary", NicType: "VIRTIO_NET"},
+	}
+	result, err = gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.Equal(t, "IPV4_IPV6", result.NetworkInterfaces[0].GetStackType())
+	assert.Len(t, result.NetworkInterfaces[0].Ipv6AccessConfigs, 1)
+	assert.Equal(t, "DIRECT_IPV6", result.NetworkInterfaces[0].Ipv6AccessConfigs[0].GetType())
+	assert.Equal(t, "PREMIUM", result.NetworkInterfaces[0].Ipv6AccessConfigs[0].GetNetworkTier())
+	// The IPv4 access config is kept.
+	assert.Equal(t, "ONE_TO_ONE_NAT", result.NetworkInterfaces[0].AccessConfigs[0].GetType())
+	assert.Nil(t, result.NetworkInterfaces[1].StackType)
+	assert.Empty(t, result.NetworkInterfaces[1].Ipv6AccessConfigs)
+	mockClient.AssertExpectations(t)
+}
+
+func TestCreateInstanceCanIPForward(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return nil
+	}
+	gcpCli := newTestGcpCli(t, mockClient)
+	mockClient.On("Insert", mock.Anything, mock.Anything, mock.Anything).Return(&compute.Operation{}, nil)
+
+	runnerSpec := newTestRunnerSpec(params.Linux)
+	result, err := gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.Nil(t, result.CanIpForward)
+
+	runnerSpec.CanIPForward = true
+	result, err = gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.True(t, result.GetCanIpForward())
+	mockClient.AssertExpectations(t)
+}
+
+func TestCreateInstanceAdvancedMachineFeatures(t *testing.T) {
+	tests := []struct {
+		name             string
+		threadsPerCore   int64
+		visibleCoreCount int64
+		expected         *computepb.AdvancedMachineFeatures
+	}{
+		{
+			name:     "NotSet",
+			expected: nil,
+		},
+		{
+			name:           "ThreadsPerCore",
+			threadsPerCore: 1,
+			expected: &computepb.AdvancedMachineFeatures{
+				ThreadsPerCore: proto.Int32(1),
+			},
+		},
+		{
+			name:             "VisibleCoreCount",
+			visibleCoreCount: 2,
+			expected: &computepb.AdvancedMachineFeatures{
+				VisibleCoreCount: proto.Int32(2),
+			},
+		},
+		{
+			name:             "Both",
+			threadsPerCore:   2,
+			visibleCoreCount: 4,
+			expected: &computepb.AdvancedMachineFeatures{
+				ThreadsPerCore:   proto.Int32(2),
+				VisibleCoreCount: proto.Int32(4),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			mockClient := new(MockGcpClient)
+			WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+				return nil
+			}
+			gcpCli := newTestGcpCli(t, mockClient)
+			mockClient.On("Insert", mock.Anything, mock.Anything, mock.Anything).Return(&compute.Operation{}, nil)
+
+			runnerSpec := newTestRunnerSpec(params.Linux)
+			runnerSpec.ThreadsPerCore = tt.threadsPerCore
+			runnerSpec.VisibleCoreCount = tt.visibleCoreCount
+			result, err := gcpCli.CreateInstance(ctx, runnerSpec)
+			assert.NoError(t, err)
+			if tt.expected == nil {
+				assert.Nil(t, result.AdvancedMachineFeatures)
+				return
+			}
+			assert.Equal(t, tt.expected.ThreadsPerCore, result.GetAdvancedMachineFeatures().ThreadsPerCore)
+			assert.Equal(t, tt.expected.VisibleCoreCount, result.GetAdvancedMachineFeatures().VisibleCoreCount)
+		})
+	}
+}
+
+func TestCreateInstanceHostname(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return nil
+	}
+	gcpCli := newTestGcpCli(t, mockClient)
+	mockClient.On("Insert", mock.Anything, mock.Anything, mock.Anything).Return(&compute.Operation{}, nil)
+
+	runnerSpec := newTestRunnerSpec(params.Linux)
+	result, err := gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.Nil(t, result.Hostname)
+
+	runnerSpec.Hostname = "runner-1.ci.example.com"
+	result, err = gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.Equal(t, "runner-1.ci.example.com", result.GetHostname())
+	mockClient.AssertExpectations(t)
+}
+
+func TestCreateInstanceResourcePolicies(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return nil
+	}
+	gcpCli := newTestGcpCli(t, mockClient)
+	mockClient.On("Insert", mock.Anything, mock.Anything, mock.Anything).Return(&compute.Operation{}, nil)
+
+	runnerSpec := newTestRunnerSpec(params.Linux)
+	result, err := gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.Empty(t, result.GetResourcePolicies())
+
+	runnerSpec.ResourcePolicies = []string{
+		"compact",
+		"projects/my-project/regions/europe-west1/resourcePolicies/daily-snapshots",
+	}
+	result, err = gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.Equal(t, runnerSpec.ResourcePolicies, result.GetResourcePolicies())
+	mockClient.AssertExpectations(t)
+}
+
+func TestCreateInstanceSourceInstanceTemplate(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return nil
+	}
+	gcpCli := newTestGcpCli(t, mockClient)
+
+	template := "projects/my-project/global/instanceTemplates/garm-runner"
+	var insertReq *computepb.InsertInstanceRequest
+	mockClient.On("Insert", mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		insertReq = args.Get(1).(*computepb.InsertInstanceRequest)
+	}).Return(&compute.Operation{}, nil)
+
+	runnerSpec := newTestRunnerSpec(params.Linux)
+	runnerSpec.SourceInstanceTemplate = template
+	result, err := gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.Equal(t, template, insertReq.GetSourceInstanceTemplate())
+	assert.Equal(t, "garm-instance", result.GetName())
+	assert.Equal(t, runnerSpec.CustomLabels, result.GetLabels())
+	// The machine configuration comes from the template.
+	assert.Empty(t, result.GetMachineType())
+	assert.Empty(t, result.GetDisks())
+	assert.Empty(t, result.GetNetworkInterfaces())
+
+	items := map[string]string{}
+	for _, item := range result.GetMetadata().GetItems() {
+		items[item.GetKey()] = item.GetValue()
+	}
+	assert.NotEmpty(t, items["user-data"])
+	assert.Equal(t, "garm-instance", items["runner_name"])
+	mockClient.AssertExpectations(t)
+}
+
+func TestCreateInstanceResourceManagerTags(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return nil
+	}
+	gcpCli := newTestGcpCli(t, mockClient)
+	mockClient.On("Insert", mock.Anything, mock.Anything, mock.Anything).Return(&compute.Operation{}, nil)
+
+	runnerSpec := newTestRunnerSpec(params.Linux)
+	result, err := gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.Nil(t, result.Params)
+
+	runnerSpec.ResourceManagerTags = map[string]string{
+		"tagKeys/123456": "tagValues/654321",
+	}
+	result, err = gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"tagKeys/123456": "tagValues/654321"}, result.GetParams().GetResourceManagerTags())
+	assert.Equal(t, []string{"tag1", "tag2"}, result.GetTags().GetItems())
+	mockClient.AssertExpectations(t)
+}
+
+func TestCreateInstanceDiskLabels(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return nil
+	}
+	gcpCli := newTestGcpCli(t, mockClient)
+	mockClient.On("Insert", mock.Anything, mock.Anything, mock.Anything).Return(&compute.Operation{}, nil)
+
+	runnerSpec := newTestRunnerSpec(params.Linux)
+	runnerSpec.CustomLabels = map[string]string{
+		"garmpoolid": "my-pool",
+		"team":       "ci",
+	}
+	runnerSpec.DiskLabels = map[string]string{"team": "ci"}
+	runnerSpec.AdditionalDisks = []spec.AdditionalDisk{{SizeGB: 100}}
+	result, err := gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+
+	assert.Equal(t, runnerSpec.CustomLabels, result.GetLabels())
+	assert.Len(t, result.GetDisks(), 2)
+	for _, disk := range result.GetDisks() {
+		assert.Equal(t, map[string]string{"team": "ci"}, disk.GetInitializeParams().GetLabels())
+	}
+	mockClient.AssertExpectations(t)
+}
+
+func TestCreateInstanceCustomMetadata(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return nil
+	}
+	gcpCli := newTestGcpCli(t, mockClient)
+	mockClient.On("Insert", mock.Anything, mock.Anything, mock.Anything).Return(&compute.Operation{}, nil)
+
+	runnerSpec := newTestRunnerSpec(params.Linux)
+	runnerSpec.CustomMetadata = map[string]string{
+		"user-data":      "OverriddenUserData",
+		"runner_name":    "overridden-name",
+		"team":           "ci",
+		"app-config":     "debug=true",
+		"ssh-keys":       "user:key",
+		"enable-oslogin": "TRUE",
+	}
+	result, err := gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+
+	metadata := map[string]string{}
+	for _, item := range result.Metadata.Items {
+		_, ok := metadata[item.GetKey()]
+		assert.False(t, ok, "duplicate metadata key %s", item.GetKey())
+		metadata[item.GetKey()] = item.GetValue()
+	}
+	assert.Equal(t, "MockUserData", metadata["user-data"])
+	assert.Equal(t, "garm-instance", metadata["runner_name"])
+	assert.Equal(t, runnerSpec.SSHKeys, metadata["ssh-keys"])
+	assert.Equal(t, "ci", metadata["team"])
+	assert.Equal(t, "debug=true", metadata["app-config"])
+	assert.Equal(t, "TRUE", metadata["enable-oslogin"])
+	mockClient.AssertExpectations(t)
+}
+
+func TestCreateInstanceSSHKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		sshKeys  string
+		expected *string
+	}{
+		{
+			name:     "NoKeys",
+			sshKeys:  "",
+			expected: nil,
+		},
+		{
+			name:     "MultipleKeys",
+			sshKeys:  "user1:ssh-rsa AAAA\nuser2:ssh-ed25519 BBBB",
+			expected: proto.String("user1:ssh-rsa AAAA\nuser2:ssh-ed25519 BBBB"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			mockClient := new(MockGcpClient)
+			WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+				return nil
+			}
+			gcpCli := newTestGcpCli(t, mockClient)
+			mockClient.On("Insert", mock.Anything, mock.Anything, mock.Anything).Return(&compute.Operation{}, nil)
+
+			runnerSpec := newTestRunnerSpec(params.Windows)
+			runnerSpec.SSHKeys = tt.sshKeys
+			result, err := gcpCli.CreateInstance(ctx, runnerSpec)
+			assert.NoError(t, err)
+
+			var sshKeys *string
+			for _, item := range result.Metadata.Items {
+				if item.GetKey() == "ssh-keys" {
+					sshKeys = item.Value
+				}
+			}
+			assert.Equal(t, tt.expected, sshKeys)
+		})
+	}
+}
+
+func TestSelectStartupScript(t *testing.T) {
+	tests := []struct {
+		name      string
+		osType    params.OSType
+		scriptKey string
+		expected  string
+	}{
+		{name: "Linux", osType: params.Linux, expected: "user-data"},
+		{name: "WindowsDefault", osType: params.Windows, expected: "sysprep-specialize-script-ps1"},
+		{name: "WindowsSysprep", osType: params.Windows, scriptKey: "sysprep-specialize-script-ps1", expected: "sysprep-specialize-script-ps1"},
+		{name: "WindowsStartup", osType: params.Windows, scriptKey: "windows-startup-script-ps1", expected: "windows-startup-script-ps1"},
+		{name: "LinuxIgnoresWindowsKey", osType: params.Linux, scriptKey: "windows-startup-script-ps1", expected: "user-data"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runnerSpec := newTestRunnerSpec(tt.osType)
+			runnerSpec.WindowsStartupScriptKey = tt.scriptKey
+			assert.Equal(t, tt.expected, selectStartupScript(runnerSpec))
+		})
+	}
+}
+
+func TestCreateInstanceMetadataItems(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return nil
+	}
+	gcpCli := newTestGcpCli(t, mockClient)
+	mockClient.On("Insert", mock.Anything, mock.Anything, mock.Anything).Return(&compute.Operation{}, nil)
+
+	runnerSpec := newTestRunnerSpec(params.Linux)
+	runnerSpec.SSHKeys = ""
+	result, err := gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+
+	var keys []string
+	for _, item := range result.Metadata.Items {
+		assert.NotEmpty(t, item.GetValue(), "metadata item %s is empty", item.GetKey())
+		keys = append(keys, item.GetKey())
+	}
+	assert.Equal(t, []string{"user-data", "runner_name"}, keys)
+	mockClient.AssertExpectations(t)
+}
+
+func TestCreateInstanceOSLogin(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return nil
+	}
+	gcpCli := newTestGcpCli(t, mockClient)
+	mockClient.On("Insert", mock.Anything, mock.Anything, mock.Anything).Return(&compute.Operation{}, nil)
+
+	runnerSpec := newTestRunnerSpec(params.Linux)
+	runnerSpec.SSHKeys = "user:ssh-rsa AAAA"
+	runnerSpec.EnableOSLogin = true
+	runnerSpec.CustomMetadata = map[string]string{
+		"enable-oslogin": "FALSE",
+	}
+	result, err := gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+
+	metadata := map[string]string{}
+	for _, item := range result.Metadata.Items {
+		metadata[item.GetKey()] = item.GetValue()
+	}
+	assert.Len(t, result.Metadata.Items, len(metadata))
+	assert.NotContains(t, metadata, "ssh-keys")
+	assert.Equal(t, "TRUE", metadata["enable-oslogin"])
+	mockClient.AssertExpectations(t)
+}
+
+func TestCreateInstanceBlockProjectSSHKeys(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return nil
+	}
+	gcpCli := newTestGcpCli(t, mockClient)
+	mockClient.On("Insert", mock.Anything, mock.Anything, mock.Anything).Return(&compute.Operation{}, nil)
+
+	for _, block := range []bool{false, true} {
+		runnerSpec := newTestRunnerSpec(params.Linux)
+		runnerSpec.SSHKeys = "user:ssh-rsa AAAA"
+		runnerSpec.BlockProjectSSHKeys = block
+		result, err := gcpCli.CreateInstance(ctx, runnerSpec)
+		assert.NoError(t, err)
+
+		metadata := map[string]string{}
+		for _, item := range result.Metadata.Items {
+			metadata[item.GetKey()] = item.GetValue()
+		}
+		assert.Equal(t, "user:ssh-rsa AAAA", metadata["ssh-keys"])
+		if block {
+			assert.Equal(t, "TRUE", metadata["block-project-ssh-keys"])
+		} else {
+			assert.NotContains(t, metadata, "block-project-ssh-keys")
+		}
+	}
+	mockClient.AssertExpectations(t)
+}
+
+func TestCreateInstanceDisplayDevice(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return nil
+	}
+	gcpCli := newTestGcpCli(t, mockClient)
+	mockClient.On("Insert", mock.Anything, mock.Anything, mock.Anything).Return(&compute.Operation{}, nil)
+
+	runnerSpec := newTestRunnerSpec(params.Linux)
+	runnerSpec.DisplayDevice = false
+	result, err := gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.Nil(t, result.DisplayDevice)
+
+	runnerSpec.DisplayDevice = true
+	result, err = gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.True(t, result.GetDisplayDevice().GetEnableDisplay())
+	mockClient.AssertExpectations(t)
+}
+
+func TestCreateInstanceShieldedInstanceConfig(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return nil
+	}
+	gcpCli := newTestGcpCli(t, mockClient)
+	mockClient.On("Insert", mock.Anything, mock.Anything, mock.Anything).Return(&compute.Operation{}, nil)
+
+	runnerSpec := newTestRunnerSpec(params.Linux)
+	result, err := gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.Nil(t, result.ShieldedInstanceConfig)
+
+	runnerSpec.EnableSecureBoot = true
+	result, err = gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.True(t, result.GetShieldedInstanceConfig().GetEnableSecureBoot())
+	assert.Nil(t, result.ShieldedInstanceConfig.EnableVtpm)
+	assert.Nil(t, result.ShieldedInstanceConfig.EnableIntegrityMonitoring)
+
+	runnerSpec.EnableVtpm = true
+	runnerSpec.EnableIntegrityMonitoring = true
+	result, err = gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.True(t, result.GetShieldedInstanceConfig().GetEnableSecureBoot())
+	assert.True(t, result.GetShieldedInstanceConfig().GetEnableVtpm())
+	assert.True(t, result.GetShieldedInstanceConfig().GetEnableIntegrityMonitoring())
+	mockClient.AssertExpectations(t)
+}
+
+func TestCreateInstanceWindowsExtraSysprepCmds(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return nil
+	}
+	gcpCli := newTestGcpCli(t, mockClient)
+	mockClient.On("Insert", mock.Anything, mock.Anything, mock.Anything).Return(&compute.Operation{}, nil)
+
+	getSysprepCmds := func(instance *computepb.Instance) []string {
+		var cmds []string
+		for _, item := range instance.GetMetadata().GetItems() {
+			if item.GetKey() == "sysprep-specialize-script-cmd" {
+				cmds = append(cmds, strings.Split(item.GetValue(), "\r\n")...)
+			}
+		}
+		return cmds
+	}
+
+	runnerSpec := newTestRunnerSpec(params.Windows)
+	result, err := gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.Empty(t, getSysprepCmds(result))
+
+	runnerSpec.WindowsExtraSysprepCmds = []string{"googet -noconfirm=true install git", "net user runner /add"}
+	result, err = gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.Equal(t, runnerSpec.WindowsExtraSysprepCmds, getSysprepCmds(result))
+
+	runnerSpec.SSHKeys = "user:ssh-rsa AAAA"
+	result, err = gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"googet -noconfirm=true install google-compute-engine-ssh",
+		"googet -noconfirm=true install git",
+		"net user runner /add",
+	}, getSysprepCmds(result))
+	mockClient.AssertExpectations(t)
+}
+
+func TestCreateInstanceWaitForGuestAttributes(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return nil
+	}
+	gcpCli := newTestGcpCli(t, mockClient)
+	mockClient.On("Insert", mock.Anything, mock.Anything, mock.Anything).Return(&compute.Operation{}, nil)
+
+	for _, osType := range []params.OSType{params.Linux, params.Windows} {
+		runnerSpec := newTestRunnerSpec(osType)
+		runnerSpec.WaitForGuestAttributes = true
+		result, err := gcpCli.CreateInstance(ctx, runnerSpec)
+		assert.NoError(t, err)
+
+		metadata := map[string]string{}
+		for _, item := range result.Metadata.Items {
+			metadata[item.GetKey()] = item.GetValue()
+		}
+		if osType == params.Windows {
+			assert.Equal(t, "TRUE", metadata["enable-guest-attributes"])
+		} else {
+			assert.NotContains(t, metadata, "enable-guest-attributes")
+		}
+	}
+	mockClient.AssertExpectations(t)
+}
+
+func TestCreateInstanceDescription(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return nil
+	}
+	gcpCli := newTestGcpCli(t, mockClient)
+	mockClient.On("Insert", mock.Anything, mock.Anything, mock.Anything).Return(&compute.Operation{}, nil)
+
+	runnerSpec := newTestRunnerSpec(params.Linux)
+	result, err := gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.Nil(t, result.Description)
+
+	runnerSpec.Description = "garm runner my-pool"
+	result, err = gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.Equal(t, "garm runner my-pool", result.GetDescription())
+
+	runnerSpec.SourceInstanceTemplate = "global/instanceTemplates/garm-runner"
+	result, err = gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.Equal(t, "garm runner my-pool", result.GetDescription())
+	mockClient.AssertExpectations(t)
+}
+
+func TestCreateInstanceEnableExternalIP(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return nil
+	}
+	gcpCli := newTestGcpCli(t, mockClient)
+	mockClient.On("Insert", mock.Anything, mock.Anything, mock.Anything).Return(&compute.Operation{}, nil)
+
+	tests := []struct {
+		name             string
+		externalIPAccess bool
+		enableExternalIP *bool
+		expected         bool
+	}{
+		{
+			name:             "Config enabled",
+			externalIPAccess: true,
+			expected:         true,
+		},
+		{
+			name:             "Config disabled",
+			externalIPAccess: false,
+			expected:         false,
+		},
+		{
+			name:             "Pool disables",
+			externalIPAccess: true,
+			enableExternalIP: proto.Bool(false),
+			expected:         false,
+		},
+		{
+			name:             "Pool enables",
+			externalIPAccess: false,
+			enableExternalIP: proto.Bool(true),
+			expected:         true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gcpCli.cfg.ExternalIPAccess = tt.externalIPAccess
+			runnerSpec := newTestRunnerSpec(params.Linux)
+			runnerSpec.EnableExternalIP = tt.enableExternalIP
+			result, err := gcpCli.CreateInstance(ctx, runnerSpec)
+			assert.NoError(t, err)
+			if tt.expected {
+				assert.Len(t, result.NetworkInterfaces[0].AccessConfigs, 1)
+			} else {
+				assert.Empty(t, result.NetworkInterfaces[0].AccessConfigs)
+			}
+		})
+	}
+	mockClient.AssertExpectations(t)
+}
+
+func TestNewGcpCliDefaultCredentials(t *testing.T) {
+	ctx := context.Background()
+	called := false
+	FindDefaultCredentials = func(ctx context.Context, scopes ...string) (*google.Credentials, error) {
+		called = true
+		return &google.Credentials{
+			TokenSource: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"}),
+		}, nil
+	}
+	defer func() { FindDefaultCredentials = google.FindDefaultCredentials }()
+
+	cfg := &config.Config{
+		Zone:         "europe-west1-d",
+		ProjectId:    "my-project",
+		NetworkID:    "my-network",
+		SubnetworkID: "my-subnetwork",
+	}
+	gcpCli, err := NewGcpCli(ctx, cfg)
+	assert.NoError(t, err)
+	assert.NotNil(t, gcpCli.Client())
+	assert.True(t, called)
+}
+
+func TestNewGcpCliDefaultCredentialsError(t *testing.T) {
+	ctx := context.Background()
+	FindDefaultCredentials = func(ctx context.Context, scopes ...string) (*google.Credentials, error) {
+		return nil, errors.New("no credentials")
+	}
+	defer func() { FindDefaultCredentials = google.FindDefaultCredentials }()
+
+	_, err := NewGcpCli(ctx, &config.Config{})
+	assert.ErrorContains(t, err, "failed to find default credentials and no credentials file supplied: no credentials")
+}
+
+func TestNewGcpCliInlineCredentials(t *testing.T) {
+	ctx := context.Background()
+	FindDefaultCredentials = func(ctx context.Context, scopes ...string) (*google.Credentials, error) {
+		t.Fatal("default credentials should not be used when inline credentials are set")
+		return nil, nil
+	}
+	defer func() { FindDefaultCredentials = google.FindDefaultCredentials }()
+
+	credentialsJSON := `{
+		"type": "service_account",
+		"client_email": "garm@my-project.iam.gserviceaccount.com",
+		"private_key": "MockPrivateKey",
+		"token_uri": "https://oauth2.googleapis.com/token"
+	}`
+	tests := []struct {
+		name string
+		cfg  *config.Config
+	}{
+		{
+			name: "CredentialsJSON",
+			cfg:  &config.Config{CredentialsJSON: credentialsJSON},
+		},
+		{
+			name: "CredentialsBase64",
+			cfg:  &config.Config{CredentialsBase64: base64.StdEncoding.EncodeToString([]byte(credentialsJSON))},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := getClientOptions(ctx, tt.cfg)
+			assert.NoError(t, err)
+			assert.Len(t, opts, 1)
+
+			gcpCli, err := NewGcpCli(ctx, tt.cfg)
+			assert.NoError(t, err)
+			assert.NotNil(t, gcpCli.Client())
+		})
+	}
+}
+
+func TestNewGcpCliApiEndpoint(t *testing.T) {
+	ctx := context.Background()
+	FindDefaultCredentials = func(ctx context.Context, scopes ...string) (*google.Credentials, error) {
+		return &google.Credentials{
+			TokenSource: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"}),
+		}, nil
+	}
+	defer func() { FindDefaultCredentials = google.FindDefaultCredentials }()
+
+	var requestPath string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestPath = r.URL.Path
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"name": "garm-instance"}`))
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		Zone:        "europe-west1-d",
+		ProjectId:   "my-project",
+		ApiEndpoint: server.URL,
+	}
+	gcpCli, err := NewGcpCli(ctx, cfg)
+	assert.NoError(t, err)
+
+	instance, err := gcpCli.GetInstance(ctx, "garm-instance")
+	assert.NoError(t, err)
+	assert.Equal(t, "garm-instance", instance.GetName())
+	assert.Equal(t, "/compute/v1/projects/my-project/zones/europe-west1-d/instances/garm-instance", requestPath)
+}
+
+func TestNewGcpCliCredentialsFile(t *testing.T) {
+	ctx := context.Background()
+	FindDefaultCredentials = func(ctx context.Context, scopes ...string) (*google.Credentials, error) {
+		t.Fatal("default credentials should not be used when a credentials file is set")
+		return nil, nil
+	}
+	defer func() { FindDefaultCredentials = google.FindDefaultCredentials }()
+
+	credentialsFile := filepath.Join(t.TempDir(), "credentials.json")
+	err := os.WriteFile(credentialsFile, []byte(`{
+		"type": "service_account",
+		"client_email": "garm@my-project.iam.gserviceaccount.com",
+		"private_key": "MockPrivateKey",
+		"token_uri": "https://oauth2.googleapis.com/token"
+	}`), 0o600)
+	assert.NoError(t, err)
+
+	gcpCli, err := NewGcpCli(ctx, &config.Config{CredentialsFile: credentialsFile})
+	assert.NoError(t, err)
+	assert.NotNil(t, gcpCli.Client())
+
+	_, err = NewGcpCli(ctx, &config.Config{CredentialsFile: filepath.Join(t.TempDir(), "missing.json")})
+	assert.ErrorContains(t, err, "failed to read JSON key file")
+}
+
+func TestCreateInstanceZoneFailover(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	gcpCli := newTestGcpCli(t, mockClient)
+	gcpCli.cfg.Zones = []string{"europe-west1-b", "europe-west1-c"}
+
+	stockoutErr, _ := apierror.FromError(&googleapi.Error{
+		Code:    503,
+		Message: "ZONE_RESOURCE_POOL_EXHAUSTED: The zone does not have enough resources available",
+	})
+	stockoutOp := &compute.Operation{}
+	WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		if op == stockoutOp {
+			return stockoutErr
+		}
+		return nil
+	}
+	inZone := func(zone string) interface{} {
+		return mock.MatchedBy(func(req *computepb.InsertInstanceRequest) bool {
+			return req.Zone == zone
+		})
+	}
+	mockClient.On("Insert", ctx, inZone("europe-west1-d"), mock.Anything).Return(stockoutOp, nil).Once()
+	mockClient.On("Insert", ctx, inZone("europe-west1-b"), mock.Anything).Return(&compute.Operation{}, nil).Once()
+
+	runnerSpec := newTestRunnerSpec(params.Linux)
+	runnerSpec.BootstrapParams.Flavor = "zones/europe-west1-d/machineTypes/n1-standard-1"
+	runnerSpec.LocalSSDCount = 1
+	result, err := gcpCli.CreateInstance(ctx, runnerSpec)
+	assert.NoError(t, err)
+	assert.Equal(t, "europe-west1-b", result.GetZone())
+	assert.Equal(t, "zones/europe-west1-b/machineTypes/n1-standard-1", result.GetMachineType())
+	assert.Equal(t, "zones/europe-west1-b/diskTypes/local-ssd", result.Disks[1].InitializeParams.GetDiskType())
+	mockClient.AssertExpectations(t)
+	mockClient.AssertNumberOfCalls(t, "Insert", 2)
+}
+
+func TestCreateInstanceZoneFailoverExhausted(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	gcpCli := newTestGcpCli(t, mockClient)
+	gcpCli.cfg.Zones = []string{"europe-west1-b"}
+
+	stockoutErr, _ := apierror.FromError(&googleapi.Error{
+		Code:    503,
+		Message: "ZONE_RESOURCE_POOL_EXHAUSTED_WITH_DETAILS: The zone does not have enough resources available",
+	})
+	WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return stockoutErr
+	}
+	mockClient.On("Insert", ctx, mock.Anything, mock.Anything).Return(&compute.Operation{}, nil)
+
+	_, err := gcpCli.CreateInstance(ctx, newTestRunnerSpec(params.Linux))
+	assert.ErrorContains(t, err, "ZONE_RESOURCE_POOL_EXHAUSTED_WITH_DETAILS")
+	mockClient.AssertNumberOfCalls(t, "Insert", 2)
+}
+
+func TestCreateInstanceNoFailoverOnOtherErrors(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return nil
+	}
+	gcpCli := newTestGcpCli(t, mockClient)
+	gcpCli.cfg.Zones = []string{"europe-west1-b"}
+
+	forbiddenErr, _ := apierror.FromError(&googleapi.Error{
+		Code: 403,
+	})
+	mockClient.On("Insert", ctx, mock.Anything, mock.Anything).Return(&compute.Operation{}, forbiddenErr)
+
+	_, err := gcpCli.CreateInstance(ctx, newTestRunnerSpec(params.Linux))
+	assert.Error(t, err)
+	mockClient.AssertNumberOfCalls(t, "Insert", 1)
+}
+
+func TestInstanceInNonDefaultZone(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return nil
+	}
+	gcpCli := newTestGcpCli(t, mockClient)
+	gcpCli.cfg.Zones = []string{"europe-west1-b", "europe-west1-c"}
+
+	notFoundErr, _ := apierror.FromError(&googleapi.Error{
+		Code: 404,
+	})
+	getInZone := func(zone string) *computepb.GetInstanceRequest {
+		return &computepb.GetInstanceRequest{
+			Project:  gcpCli.cfg.ProjectId,
+			Zone:     zone,
+			Instance: "garm-instance",
+		}
+	}
+	mockClient.On("Get", ctx, getInZone("europe-west1-d"), mock.Anything).Return((*computepb.Instance)(nil), notFoundErr)
+	mockClient.On("Get", ctx, getInZone("europe-west1-b"), mock.Anything).Return((*computepb.Instance)(nil), notFoundErr)
+	mockClient.On("Get", ctx, getInZone("europe-west1-c"), mock.Anything).Return(&computepb.Instance{
+		Name: proto.String("garm-instance"),
+		Zone: proto.String("europe-west1-c"),
+	}, nil)
+	mockClient.On("Stop", ctx, &computepb.StopInstanceRequest{
+		Project:  gcpCli.cfg.ProjectId,
+		Zone:     "europe-west1-c",
+		Instance: "garm-instance",
+	}, mock.Anything).Return(&compute.Operation{}, nil)
+	mockClient.On("Start", ctx, &computepb.StartInstanceRequest{
+		Project:  gcpCli.cfg.ProjectId,
+		Zone:     "europe-west1-c",
+		Instance: "garm-instance",
+	}, mock.Anything).Return(&compute.Operation{}, nil)
+	mockClient.On("Delete", ctx, &computepb.DeleteInstanceRequest{
+		Project:  gcpCli.cfg.ProjectId,
+		Zone:     "europe-west1-c",
+		Instance: "garm-instance",
+	}, mock.Anything).Return(&compute.Operation{}, nil)
+
+	instance, err := gcpCli.GetInstance(ctx, "garm-instance")
+	assert.NoError(t, err)
+	assert.Equal(t, "europe-west1-c", instance.GetZone())
+
+	zone, err := gcpCli.findInstanceZone(ctx, "garm-instance")
+	assert.NoError(t, err)
+	assert.Equal(t, "europe-west1-c", zone)
+
+	assert.NoError(t, gcpCli.StopInstance(ctx, "garm-instance"))
+	assert.NoError(t, gcpCli.StartInstance(ctx, "garm-instance"))
+	assert.NoError(t, gcpCli.DeleteInstance(ctx, "garm-instance"))
+	mockClient.AssertExpectations(t)
+}
+
+func TestFindInstanceZoneNotFound(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	gcpCli := newTestGcpCli(t, mockClient)
+	gcpCli.cfg.Zones = []string{"europe-west1-b"}
+
+	notFoundErr, _ := apierror.FromError(&googleapi.Error{
+		Code: 404,
+	})
+	mockClient.On("Get", ctx, mock.Anything, mock.Anything).Return((*computepb.Instance)(nil), notFoundErr)
+
+	_, err := gcpCli.findInstanceZone(ctx, "garm-instance")
+	assert.ErrorContains(t, err, "failed to find the zone of instance garm-instance")
+	mockClient.AssertNumberOfCalls(t, "Get", 2)
+
+	// Deleting an instance that does not exist in any zone is not an error.
+	assert.NoError(t, gcpCli.DeleteInstance(ctx, "garm-instance"))
+	mockClient.AssertNotCalled(t, "Delete", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestFindInstanceZoneSingleZone(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	gcpCli := newTestGcpCli(t, mockClient)
+
+	zone, err := gcpCli.findInstanceZone(ctx, "garm-instance")
+	assert.NoError(t, err)
+	assert.Equal(t, "europe-west1-d", zone)
+	mockClient.AssertNotCalled(t, "Get", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestListDescribedInstancesAggregated(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	gcpCli := newTestGcpCli(t, mockClient)
+	gcpCli.cfg.Zones = []string{"europe-west1-b", "europe-west1-c"}
+
+	pairs := []compute.InstancesScopedListPair{
+		{
+			Key: "zones/europe-west1-b",
+			Value: &computepb.InstancesScopedList{
+				Instances: []*computepb.Instance{
+					{Name: proto.String("garm-instance-1")},
+					{Name: proto.String("garm-instance-2")},
+				},
+			},
+		},
+		{
+			// Zones without instances only carry a warning.
+			Key:   "zones/europe-west1-c",
+			Value: &computepb.InstancesScopedList{},
+		},
+		{
+			Key: "zones/europe-west1-d",
+			Value: &computepb.InstancesScopedList{
+				Instances: []*computepb.Instance{
+					{Name: proto.String("garm-instance-3")},
+				},
+			},
+		},
+		{
+			// Instances outside the configured zones are left out.
+			Key: "zones/us-central1-a",
+			Value: &computepb.InstancesScopedList{
+				Instances: []*computepb.Instance{
+					{Name: proto.String("garm-instance-4")},
+				},
+			},
+		},
+		{
+			// So are unreachable zones that are not configured.
+			Key: "zones/us-central1-b",
+			Value: &computepb.InstancesScopedList{
+				Warning: &computepb.Warning{
+					Code: proto.String(computepb.Warning_UNREACHABLE.String()),
+				},
+			},
+		},
+	}
+	it := 0
+	NextAggregatedIt = func(*compute.InstancesScopedListPairIterator) (compute.InstancesScopedListPair, error) {
+		if it < len(pairs) {
+			it++
+			return pairs[it-1], nil
+		}
+		return compute.InstancesScopedListPair{}, iterator.Done
+	}
+	mockClient.On("AggregatedList", ctx, &computepb.AggregatedListInstancesRequest{
+		Project:              gcpCli.cfg.ProjectId,
+		Filter:               proto.String("labels.garmpoolid=garm-pool"),
+		MaxResults:           proto.Uint32(500),
+		ReturnPartialSuccess: proto.Bool(true),
+	}, mock.Anything).Return(&compute.InstancesScopedListPairIterator{})
+
+	instances, err := gcpCli.ListDescribedInstancesAggregated(ctx, "garm-pool")
+	assert.NoError(t, err)
+	var names []string
+	for _, instance := range instances {
+		names = append(names, instance.GetName())
+	}
+	assert.Equal(t, []string{"garm-instance-1", "garm-instance-2", "garm-instance-3"}, names)
+	mockClient.AssertExpectations(t)
+}
+
+func TestListDescribedInstancesAggregatedUnreachableZone(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	gcpCli := newTestGcpCli(t, mockClient)
+
+	pairs := []compute.InstancesScopedListPair{
+		{
+			Key: "zones/europe-west1-d",
+			Value: &computepb.InstancesScopedList{
+				Warning: &computepb.Warning{
+					Code:    proto.String(computepb.Warning_UNREACHABLE.String()),
+					Message: proto.String("Scope zones/europe-west1-d is unreachable."),
+				},
+			},
+		},
+	}
+	it := 0
+	NextAggregatedIt = func(*compute.InstancesScopedListPairIterator) (compute.InstancesScopedListPair, error) {
+		if it < len(pairs) {
+			it++
+			return pairs[it-1], nil
+		}
+		return compute.InstancesScopedListPair{}, iterator.Done
+	}
+	mockClient.On("AggregatedList", ctx, mock.Anything, mock.Anything).Return(&compute.InstancesScopedListPairIterator{})
+
+	instances, err := gcpCli.ListDescribedInstancesAggregated(ctx, "garm-pool")
+	assert.EqualError(t, err, "failed to list instances in zone europe-west1-d: Scope zones/europe-west1-d is unreachable.")
+	assert.Nil(t, instances)
+}
+
+func TestListDescribedInstancesAggregatedError(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(MockGcpClient)
+	gcpCli := newTestGcpCli(t, mockClient)
+
+	NextAggregatedIt = func(*compute.InstancesScopedListPairIterator) (compute.InstancesScopedListPair, error) {
+		return compute.InstancesScopedListPair{}, errors.New("permission denied")
+	}
+	mockClient.On("AggregatedList", ctx, mock.Anything, mock.Anything).Return(&compute.InstancesScopedListPairIterator{})
+
+	_, err := gcpCli.ListDescribedInstancesAggregated(ctx, "garm-pool")
+	assert.ErrorContains(t, err, "failed to list instances: permission denied")
+}
+
+// newTestGcpCli returns a client backed by client. The package level hooks
+// that tests stub are put back once t finishes.
+func newTestGcpCli(t *testing.T, client ClientInterface) *GcpCli {
+	t.Cleanup(func() {
+		WaitOp = (*compute.Operation).Wait
+		NextIt = (*compute.InstanceIterator).Next
+		NextAggregatedIt = (*compute.InstancesScopedListPairIterator).Next
+		OperationProto = (*compute.Operation).Proto
+	})
+	OperationProto = func(op *compute.Operation) *computepb.Operation {
+		return &computepb.Operation{}
+	}
+	return &GcpCli{
+		cfg: &config.Config{
+			Zone:             "europe-west1-d",
+			ProjectId:        "my-project",
+			NetworkID:        "my-network",
+			SubnetworkID:     "my-subnetwork",
+			CredentialsFile:  "path/to/credentials.json",
+			ExternalIPAccess: true,
+		},
+		client: client,
+	}
+}
+
+func newTestRunnerSpec(osType params.OSType) *spec.RunnerSpec {
+	spec.DefaultCloudConfigFunc = func(bootstrapParams params.BootstrapInstance, tools params.RunnerApplicationDownload, runnerName string) (string, error) {
+		return "MockUserData", nil
+	}
+	spec.DefaultRunnerInstallScriptFunc = func(bootstrapParams params.BootstrapInstance, tools params.RunnerApplicationDownload, runnerName string) ([]byte, error) {
+		return []byte("MockUserData"), nil
+	}
+
+	return &spec.RunnerSpec{
+		Zone: "europe-west1-d",
+		Tools: params.RunnerApplicationDownload{
+			OS:           proto.String(string(osType)),
+			Architecture: proto.String("amd64"),
+			DownloadURL:  proto.String("MockURL"),
+			Filename:     proto.String("garm-runner"),
+		},
+		NetworkID:    "my-network",
+		SubnetworkID: "my-subnetwork",
+		ControllerID: "my-controller",
+		NicType:      "VIRTIO_NET",
+		DiskSize:     50,
+		CustomLabels: map[string]string{"key1": "value1"},
+		NetworkTags:  []string{"tag1", "tag2"},
+		BootstrapParams: params.BootstrapInstance{
+			Name:   "garm-instance",
+			Flavor: "n1-standard-1",
+			Image:  "projects/garm-testing/global/images/garm-image",
+			OSType: osType,
+			OSArch: "amd64",
+		},
+	}
+}
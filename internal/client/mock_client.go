@@ -44,6 +44,21 @@ func (m *MockGcpClient) Stop(ctx context.Context, req *computepb.StopInstanceReq
 	return args.Get(0).(*compute.Operation), args.Error(1)
 }
 
+func (m *MockGcpClient) Reset(ctx context.Context, req *computepb.ResetInstanceRequest, opts ...gax.CallOption) (*compute.Operation, error) {
+	args := m.Called(ctx, req, opts)
+	return args.Get(0).(*compute.Operation), args.Error(1)
+}
+
+func (m *MockGcpClient) Suspend(ctx context.Context, req *computepb.SuspendInstanceRequest, opts ...gax.CallOption) (*compute.Operation, error) {
+	args := m.Called(ctx, req, opts)
+	return args.Get(0).(*compute.Operation), args.Error(1)
+}
+
+func (m *MockGcpClient) Resume(ctx context.Context, req *computepb.ResumeInstanceRequest, opts ...gax.CallOption) (*compute.Operation, error) {
+	args := m.Called(ctx, req, opts)
+	return args.Get(0).(*compute.Operation), args.Error(1)
+}
+
 func (m *MockGcpClient) Delete(ctx context.Context, req *computepb.DeleteInstanceRequest, opts ...gax.CallOption) (*compute.Operation, error) {
 	args := m.Called(ctx, req, opts)
 	return args.Get(0).(*compute.Operation), args.Error(1)
@@ -54,7 +69,52 @@ func (m *MockGcpClient) List(ctx context.Context, req *computepb.ListInstancesRe
 	return args.Get(0).(*compute.InstanceIterator)
 }
 
+func (m *MockGcpClient) AggregatedList(ctx context.Context, req *computepb.AggregatedListInstancesRequest, opts ...gax.CallOption) *compute.InstancesScopedListPairIterator {
+	args := m.Called(ctx, req, opts)
+	return args.Get(0).(*compute.InstancesScopedListPairIterator)
+}
+
 func (m *MockGcpClient) Get(ctx context.Context, req *computepb.GetInstanceRequest, opts ...gax.CallOption) (*computepb.Instance, error) {
 	args := m.Called(ctx, req, opts)
 	return args.Get(0).(*computepb.Instance), args.Error(1)
 }
+
+func (m *MockGcpClient) GetSerialPortOutput(ctx context.Context, req *computepb.GetSerialPortOutputInstanceRequest, opts ...gax.CallOption) (*computepb.SerialPortOutput, error) {
+	args := m.Called(ctx, req, opts)
+	return args.Get(0).(*computepb.SerialPortOutput), args.Error(1)
+}
+
+func (m *MockGcpClient) SetDeletionProtection(ctx context.Context, req *computepb.SetDeletionProtectionInstanceRequest, opts ...gax.CallOption) (*compute.Operation, error) {
+	args := m.Called(ctx, req, opts)
+	return args.Get(0).(*compute.Operation), args.Error(1)
+}
+
+func (m *MockGcpClient) SetMachineType(ctx context.Context, req *computepb.SetMachineTypeInstanceRequest, opts ...gax.CallOption) (*compute.Operation, error) {
+	args := m.Called(ctx, req, opts)
+	return args.Get(0).(*compute.Operation), args.Error(1)
+}
+
+// MockDisksClient is a mock of the DisksClientInterface
+type MockDisksClient struct {
+	mock.Mock
+}
+
+func (m *MockDisksClient) Resize(ctx context.Context, req *computepb.ResizeDiskRequest, opts ...gax.CallOption) (*compute.Operation, error) {
+	args := m.Called(ctx, req, opts)
+	return args.Get(0).(*compute.Operation), args.Error(1)
+}
+
+// MockImagesClient is a mock of the ImagesClientInterface
+type MockImagesClient struct {
+	mock.Mock
+}
+
+func (m *MockImagesClient) Get(ctx context.Context, req *computepb.GetImageRequest, opts ...gax.CallOption) (*computepb.Image, error) {
+	args := m.Called(ctx, req, opts)
+	return args.Get(0).(*computepb.Image), args.Error(1)
+}
+
+func (m *MockImagesClient) GetFromFamily(ctx context.Context, req *computepb.GetFromFamilyImageRequest, opts ...gax.CallOption) (*computepb.Image, error) {
+	args := m.Called(ctx, req, opts)
+	return args.Get(0).(*computepb.Image), args.Error(1)
+}
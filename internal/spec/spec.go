@@ -19,26 +19,120 @@ import (
 	"encoding/json"
 	"fmt"
 	"maps"
+	"net"
+	"net/url"
 	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"cloud.google.com/go/compute/apiv1/computepb"
 	"github.com/cloudbase/garm-provider-common/cloudconfig"
 	"github.com/cloudbase/garm-provider-common/params"
 	"github.com/cloudbase/garm-provider-common/util"
 	"github.com/cloudbase/garm-provider-gcp/config"
+	gcputil "github.com/cloudbase/garm-provider-gcp/internal/util"
 	"github.com/invopop/jsonschema"
 	"github.com/xeipuuv/gojsonschema"
+	"google.golang.org/protobuf/proto"
+	"gopkg.in/yaml.v3"
 )
 
 const (
-	defaultDiskSizeGB     int64  = 127
-	defaultNicType        string = "VIRTIO_NET"
-	garmPoolID            string = "garmpoolid"
-	garmControllerID      string = "garmcontrollerid"
-	osType                string = "ostype"
+	defaultDiskSizeGB int64  = 127
+	defaultNicType    string = "VIRTIO_NET"
+	garmPoolID        string = "garmpoolid"
+	garmControllerID  string = "garmcontrollerid"
+	osType            string = "ostype"
+	garmCreatedAt     string = "garmcreatedat"
+	// maxLabels is the maximum number of labels GCP allows on a resource.
+	maxLabels             int    = 64
 	customLabelKeyRegex   string = "^\\p{Ll}[\\p{Ll}0-9_-]{0,62}$"
 	customLabelValueRegex string = "^[\\p{Ll}0-9_-]{0,63}$"
 	networkTagRegex       string = "^[a-z][a-z0-9-]{0,61}[a-z0-9]$"
+	// Resource Manager tags are either referenced by ID (tagKeys/123 and
+	// tagValues/456) or by their namespaced name (my-project/env and prod).
+	resourceManagerTagKeyRegex   string = "^(tagKeys/[0-9]+|[a-zA-Z0-9._-]+/[a-zA-Z0-9]([a-zA-Z0-9_.-]{0,61}[a-zA-Z0-9])?)$"
+	resourceManagerTagValueRegex string = "^(tagValues/[0-9]+|[a-zA-Z0-9]([a-zA-Z0-9_.-]{0,61}[a-zA-Z0-9])?)$"
+	maxResourceManagerTags       int    = 50
+	metadataKeyRegex             string = "^[a-zA-Z0-9_-]{1,128}$"
+	sshKeyRegex                  string = "^[a-zA-Z0-9._-]+:(ssh-[a-z0-9]+|ecdsa-sha2-nistp(256|384|521)|sk-[a-zA-Z0-9@.-]+) [A-Za-z0-9+/]+={0,3}( .*)?$"
+	hostnameLabelRegex           string = "^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$"
+	maxHostnameLength            int    = 253
+	projectIDRegex               string = "^[a-z][a-z0-9-]{4,28}[a-z0-9]$"
+	resourceNameRegex            string = "^[a-z]([-a-z0-9]{0,61}[a-z0-9])?$"
+	kmsKeyRegex                  string = "^projects/[^/]+/locations/[^/]+/keyRings/[^/]+/cryptoKeys/[^/]+(/cryptoKeyVersions/[0-9]+)?$"
+	machineImageRegex            string = "^(https://www\\.googleapis\\.com/compute/v1/)?(projects/[a-z0-9-]+/)?global/machineImages/[a-z]([-a-z0-9]{0,61}[a-z0-9])?$"
+	instanceTemplateRegex        string = "^(https://www\\.googleapis\\.com/compute/v1/)?(projects/[a-z0-9-]+/)?(global|regions/[a-z0-9-]+)/instanceTemplates/[a-z]([-a-z0-9]{0,61}[a-z0-9])?$"
+	maxMetadataValueSize         int    = 256 * 1024
+	maxNetworkInterfaces         int    = 8
+	minDiskSizeGB                int64  = 10
+	maxDiskSizeGB                int64  = 65536
+	maxDescriptionLength         int    = 2048
+	maxLocalSSDCount             int64  = 24
+	diskInterfaceSCSI            string = "SCSI"
+	diskInterfaceNVME            string = "NVME"
+	hyperdiskTypePrefix          string = "hyperdisk-"
+	customMemoryStepMB           int64  = 256
+	provisioningModelSpot        string = "SPOT"
+	onHostMaintenanceMigrate     string = "MIGRATE"
+	onHostMaintenanceTerminate   string = "TERMINATE"
+	networkTierPremium           string = "PREMIUM"
+	networkTierStandard          string = "STANDARD"
+	defaultAccessConfigName      string = "External NAT"
+	windowsSysprepScriptKey      string = "sysprep-specialize-script-ps1"
+	windowsStartupScriptKey      string = "windows-startup-script-ps1"
+	// defaultWindowsNetworkRetries and defaultWindowsNetworkRetryInterval bound
+	// the time Windows instances wait for the network before installing the
+	// runner (5 minutes).
+	defaultWindowsNetworkRetries       int = 30
+	defaultWindowsNetworkRetryInterval int = 10
+	// guestAttributesReadyURL is the guest attribute written by Windows
+	// instances when wait_for_guest_attributes is set.
+	guestAttributesReadyURL string = "http://metadata.google.internal/computeMetadata/v1/instance/guest-attributes/garm/ready"
+)
+
+// confidentialComputeFamilies are the machine families that support
+// Confidential VMs with AMD SEV.
+var confidentialComputeFamilies = []string{"n2d", "c2d", "c3d"}
+
+// reservedLabelKeys are the instance labels the provider uses to track the
+// runners. They cannot be set through the custom labels.
+var reservedLabelKeys = []string{garmPoolID, garmControllerID, osType, gcputil.OSArchLabel, garmCreatedAt}
+
+// supportedNicTypes are the network interface card types supported by GCP.
+var supportedNicTypes = []string{"VIRTIO_NET", "GVNIC", "IDPF"}
+
+// DefaultServiceAccountScopes are the scopes given to the service_account_email
+// service account when no scopes are set. They allow the instance to write logs
+// and metrics and to read from Cloud Storage.
+var DefaultServiceAccountScopes = []string{
+	"https://www.googleapis.com/auth/logging.write",
+	"https://www.googleapis.com/auth/monitoring.write",
+	"https://www.googleapis.com/auth/devstorage.read_only",
+}
+
+var (
+	// opsAgentInstallCmds install the Google Cloud Ops Agent on Linux.
+	opsAgentInstallCmds = []string{
+		"curl -sSfL -o /tmp/add-google-cloud-ops-agent-repo.sh https://dl.google.com/cloudagents/add-google-cloud-ops-agent-repo.sh",
+		"bash /tmp/add-google-cloud-ops-agent-repo.sh --also-install",
+		"rm -f /tmp/add-google-cloud-ops-agent-repo.sh",
+	}
+	// opsAgentLoggingScopes are the scopes that allow the Ops Agent to write logs.
+	opsAgentLoggingScopes = []string{
+		"https://www.googleapis.com/auth/logging.write",
+		"https://www.googleapis.com/auth/logging.admin",
+		"https://www.googleapis.com/auth/cloud-platform",
+	}
+	// opsAgentMonitoringScopes are the scopes that allow the Ops Agent to write metrics.
+	opsAgentMonitoringScopes = []string{
+		"https://www.googleapis.com/auth/monitoring.write",
+		"https://www.googleapis.com/auth/monitoring",
+		"https://www.googleapis.com/auth/cloud-platform",
+	}
 )
 
 type ToolFetchFunc func(osType params.OSType, osArch params.OSArch, tools []params.RunnerApplicationDownload) (params.RunnerApplicationDownload, error)
@@ -47,6 +141,9 @@ var DefaultToolFetch ToolFetchFunc = util.GetTools
 var DefaultCloudConfigFunc = cloudconfig.GetCloudConfig
 var DefaultRunnerInstallScriptFunc = cloudconfig.GetRunnerInstallScript
 
+// timeNow returns the current time. Tests replace it to get a fixed time.
+var timeNow = time.Now
+
 func generateJSONSchema() *jsonschema.Schema {
 	reflector := jsonschema.Reflector{
 		AllowAdditionalProperties: false,
@@ -92,8 +189,9 @@ func newExtraSpecsFromBootstrapData(data params.BootstrapInstance) (*extraSpecs,
 }
 
 func (e *extraSpecs) Validate() error {
-	if len(e.CustomLabels) > 61 {
-		return fmt.Errorf("custom labels cannot exceed 61 items")
+	// The labels used internally by the provider count toward the GCP limit.
+	if maxCustomLabels := maxLabels - len(reservedLabelKeys); len(e.CustomLabels) > maxCustomLabels {
+		return fmt.Errorf("custom labels cannot exceed %d items", maxCustomLabels)
 	}
 	keyRegex, err := regexp.Compile(customLabelKeyRegex)
 	if err != nil {
@@ -108,10 +206,24 @@ func (e *extraSpecs) Validate() error {
 		if !keyRegex.MatchString(key) {
 			return fmt.Errorf("custom label key '%s' does not match requirements", key)
 		}
+		if slices.Contains(reservedLabelKeys, key) {
+			return fmt.Errorf("custom label key '%s' is reserved by the provider", key)
+		}
 		if !valueRegex.MatchString(value) {
 			return fmt.Errorf("custom label value '%s' does not match requirements", value)
 		}
 	}
+	if len(e.DiskLabels) > 64 {
+		return fmt.Errorf("disk labels cannot exceed 64 items")
+	}
+	for key, value := range e.DiskLabels {
+		if !keyRegex.MatchString(key) {
+			return fmt.Errorf("disk label key '%s' does not match requirements", key)
+		}
+		if !valueRegex.MatchString(value) {
+			return fmt.Errorf("disk label value '%s' does not match requirements", value)
+		}
+	}
 	if len(e.NetworkTags) > 64 {
 		return fmt.Errorf("network tags cannot exceed 64 items")
 	}
@@ -124,22 +236,350 @@ func (e *extraSpecs) Validate() error {
 			return fmt.Errorf("network tag '%s' does not match requirements", tag)
 		}
 	}
+	if len(e.ResourceManagerTags) > maxResourceManagerTags {
+		return fmt.Errorf("resource manager tags cannot exceed %d items", maxResourceManagerTags)
+	}
+	rmTagKeyRegex, err := regexp.Compile(resourceManagerTagKeyRegex)
+	if err != nil {
+		return fmt.Errorf("invalid resource manager tag key regex pattern: %w", err)
+	}
+	rmTagValueRegex, err := regexp.Compile(resourceManagerTagValueRegex)
+	if err != nil {
+		return fmt.Errorf("invalid resource manager tag value regex pattern: %w", err)
+	}
+	for key, value := range e.ResourceManagerTags {
+		if !rmTagKeyRegex.MatchString(key) {
+			return fmt.Errorf("resource manager tag key '%s' does not match requirements", key)
+		}
+		if !rmTagValueRegex.MatchString(value) {
+			return fmt.Errorf("resource manager tag value '%s' does not match requirements", value)
+		}
+	}
+	metadataRegex, err := regexp.Compile(metadataKeyRegex)
+	if err != nil {
+		return fmt.Errorf("invalid metadata regex pattern: %w", err)
+	}
+	for key, value := range e.CustomMetadata {
+		if !metadataRegex.MatchString(key) {
+			return fmt.Errorf("custom metadata key '%s' does not match requirements", key)
+		}
+		if len(value) > maxMetadataValueSize {
+			return fmt.Errorf("custom metadata value for key '%s' exceeds %d bytes", key, maxMetadataValueSize)
+		}
+	}
+	sshRegex, err := regexp.Compile(sshKeyRegex)
+	if err != nil {
+		return fmt.Errorf("invalid ssh key regex pattern: %w", err)
+	}
+	for idx, key := range e.SSHKeys {
+		if !sshRegex.MatchString(key) {
+			return fmt.Errorf("ssh key %d does not match the USERNAME:KEY_TYPE KEY [COMMENT] format", idx)
+		}
+	}
+	if len(e.ServiceAccounts) > 1 {
+		return fmt.Errorf("only one service account can be attached to an instance, got %d", len(e.ServiceAccounts))
+	}
+	if len(e.ServiceAccountScopes) > 0 && e.ServiceAccountEmail == "" {
+		return fmt.Errorf("service_account_scopes requires service_account_email to be set")
+	}
+	if e.NetworkInterfaces != nil && len(e.NetworkInterfaces) == 0 {
+		return fmt.Errorf("network interfaces must contain at least one interface")
+	}
+	if len(e.NetworkInterfaces) > maxNetworkInterfaces {
+		return fmt.Errorf("network interfaces cannot exceed %d items", maxNetworkInterfaces)
+	}
+	if e.HostProjectID != "" {
+		projectRegex, err := regexp.Compile(projectIDRegex)
+		if err != nil {
+			return fmt.Errorf("invalid project id regex pattern: %w", err)
+		}
+		if !projectRegex.MatchString(e.HostProjectID) {
+			return fmt.Errorf("host project id '%s' is not a valid project ID", e.HostProjectID)
+		}
+	}
+	if e.NicType != "" && !slices.Contains(supportedNicTypes, e.NicType) {
+		return fmt.Errorf("nic type must be one of %s", strings.Join(supportedNicTypes, ", "))
+	}
+	for idx, nic := range e.NetworkInterfaces {
+		if nic.SubnetworkID == "" {
+			return fmt.Errorf("network interface %d is missing the subnetwork id", idx)
+		}
+		if nic.NicType != "" && !slices.Contains(supportedNicTypes, nic.NicType) {
+			return fmt.Errorf("network interface %d nic type must be one of %s", idx, strings.Join(supportedNicTypes, ", "))
+		}
+	}
+	// A zero disk size means the default size is used.
+	if e.DiskSize != 0 && (e.DiskSize < minDiskSizeGB || e.DiskSize > maxDiskSizeGB) {
+		return fmt.Errorf("disk size must be between %d and %d GB", minDiskSizeGB, maxDiskSizeGB)
+	}
+	for idx, disk := range e.AdditionalDisks {
+		if disk.SizeGB <= 0 || disk.SizeGB > maxDiskSizeGB {
+			return fmt.Errorf("additional disk %d size must be between 1 and %d GB", idx, maxDiskSizeGB)
+		}
+		if disk.SourceImage != "" && disk.SourceSnapshot != "" {
+			return fmt.Errorf("additional disk %d cannot have both a source image and a source snapshot", idx)
+		}
+	}
+	if e.LocalSSDCount < 0 || e.LocalSSDCount > maxLocalSSDCount {
+		return fmt.Errorf("local SSD count must be between 0 and %d", maxLocalSSDCount)
+	}
+	switch e.BootDiskInterface {
+	case "", diskInterfaceSCSI, diskInterfaceNVME:
+	default:
+		return fmt.Errorf("boot disk interface must be one of %s or %s", diskInterfaceSCSI, diskInterfaceNVME)
+	}
+	nameRegex, err := regexp.Compile(resourceNameRegex)
+	if err != nil {
+		return fmt.Errorf("invalid resource name regex pattern: %w", err)
+	}
+	if e.BootDiskName != "" && !nameRegex.MatchString(e.BootDiskName) {
+		return fmt.Errorf("boot disk name '%s' does not match requirements", e.BootDiskName)
+	}
+	if e.BootDiskDeviceName != "" && !nameRegex.MatchString(e.BootDiskDeviceName) {
+		return fmt.Errorf("boot disk device name '%s' does not match requirements", e.BootDiskDeviceName)
+	}
+	if e.Image != "" && e.ImageFamily != "" {
+		return fmt.Errorf("image and image_family cannot be set at the same time")
+	}
+	if e.SourceSnapshot != "" && (e.Image != "" || e.ImageFamily != "" || e.ImageProject != "") {
+		return fmt.Errorf("source_snapshot cannot be set together with image, image_family or image_project")
+	}
+	if e.ImageFamily != "" && !nameRegex.MatchString(e.ImageFamily) {
+		return fmt.Errorf("image family '%s' does not match requirements", e.ImageFamily)
+	}
+	if e.DiskEncryptionKey != "" {
+		keyRegex, err := regexp.Compile(kmsKeyRegex)
+		if err != nil {
+			return fmt.Errorf("invalid kms key regex pattern: %w", err)
+		}
+		if !keyRegex.MatchString(e.DiskEncryptionKey) {
+			return fmt.Errorf("disk encryption key '%s' is not a valid Cloud KMS key resource path", e.DiskEncryptionKey)
+		}
+	}
+	if e.ProvisionedIops < 0 || e.ProvisionedThroughput < 0 {
+		return fmt.Errorf("provisioned iops and throughput cannot be negative")
+	}
+	if e.WindowsNetworkRetries < 0 || e.WindowsNetworkRetryInterval < 0 {
+		return fmt.Errorf("windows network retries and retry interval cannot be negative")
+	}
+	switch e.WindowsStartupScriptKey {
+	case "", windowsSysprepScriptKey, windowsStartupScriptKey:
+	default:
+		return fmt.Errorf("windows startup script key must be one of %s or %s", windowsSysprepScriptKey, windowsStartupScriptKey)
+	}
+	for idx, cmd := range e.WindowsExtraSysprepCmds {
+		if strings.TrimSpace(cmd) == "" {
+			return fmt.Errorf("windows extra sysprep command %d cannot be empty", idx)
+		}
+	}
+	switch e.OnHostMaintenance {
+	case "", onHostMaintenanceMigrate, onHostMaintenanceTerminate:
+	default:
+		return fmt.Errorf("on host maintenance must be one of %s or %s", onHostMaintenanceMigrate, onHostMaintenanceTerminate)
+	}
+	if e.OnHostMaintenance == onHostMaintenanceMigrate && (e.ProvisioningModel == provisioningModelSpot || e.EnableConfidentialCompute) {
+		return fmt.Errorf("spot and confidential instances cannot be live migrated")
+	}
+	if e.AutomaticRestart != nil && *e.AutomaticRestart && e.ProvisioningModel == provisioningModelSpot {
+		return fmt.Errorf("spot instances cannot be restarted automatically")
+	}
+	for idx, policy := range e.ResourcePolicies {
+		if strings.TrimSpace(policy) == "" {
+			return fmt.Errorf("resource policy %d cannot be empty", idx)
+		}
+	}
+	for idx, cmd := range e.PreCreateCommands {
+		if strings.TrimSpace(cmd) == "" {
+			return fmt.Errorf("pre create command %d cannot be empty", idx)
+		}
+	}
+	if len(e.RawStartupScript) > 0 && !utf8.Valid(e.RawStartupScript) {
+		return fmt.Errorf("raw startup script must be valid UTF-8")
+	}
+	if e.ImageProject != "" {
+		projectRegex, err := regexp.Compile(projectIDRegex)
+		if err != nil {
+			return fmt.Errorf("invalid project id regex pattern: %w", err)
+		}
+		if !projectRegex.MatchString(e.ImageProject) {
+			return fmt.Errorf("image project '%s' is not a valid project ID", e.ImageProject)
+		}
+	}
+	if e.SourceInstanceTemplate != "" {
+		templateRegex, err := regexp.Compile(instanceTemplateRegex)
+		if err != nil {
+			return fmt.Errorf("invalid instance template regex pattern: %w", err)
+		}
+		if !templateRegex.MatchString(e.SourceInstanceTemplate) {
+			return fmt.Errorf("source instance template '%s' is not a valid instance template URL", e.SourceInstanceTemplate)
+		}
+	}
+	if e.SourceMachineImage != "" {
+		machineImageRe, err := regexp.Compile(machineImageRegex)
+		if err != nil {
+			return fmt.Errorf("invalid machine image regex pattern: %w", err)
+		}
+		if !machineImageRe.MatchString(e.SourceMachineImage) {
+			return fmt.Errorf("source machine image '%s' is not a valid machine image URL", e.SourceMachineImage)
+		}
+		if e.SourceSnapshot != "" || e.SourceInstanceTemplate != "" || e.Image != "" || e.ImageFamily != "" || e.ImageProject != "" {
+			return fmt.Errorf("source_machine_image cannot be set together with source_snapshot, source_instance_template, image, image_family or image_project")
+		}
+	}
+	if len(e.Description) > maxDescriptionLength {
+		return fmt.Errorf("description cannot be longer than %d characters", maxDescriptionLength)
+	}
+	if e.Hostname != "" {
+		if err := validateHostname(e.Hostname); err != nil {
+			return err
+		}
+	}
+	if e.NetworkIP != "" && !isIPv4(e.NetworkIP) {
+		return fmt.Errorf("network ip '%s' is not a valid IPv4 address", e.NetworkIP)
+	}
+	switch e.ExternalIPNetworkTier {
+	case "", networkTierPremium, networkTierStandard:
+	default:
+		return fmt.Errorf("external ip network tier must be one of %s or %s", networkTierPremium, networkTierStandard)
+	}
+	if e.ExternalIP != "" && !isIPv4(e.ExternalIP) {
+		return fmt.Errorf("external ip '%s' is not a valid IPv4 address", e.ExternalIP)
+	}
+	if e.ThreadsPerCore < 0 || e.ThreadsPerCore > 2 {
+		return fmt.Errorf("threads_per_core must be 1 or 2")
+	}
+	if e.VisibleCoreCount < 0 {
+		return fmt.Errorf("visible_core_count cannot be negative")
+	}
+	if e.CustomVCPUs < 0 || e.CustomMemoryMB < 0 {
+		return fmt.Errorf("custom_vcpus and custom_memory_mb cannot be negative")
+	}
+	if (e.CustomVCPUs > 0) != (e.CustomMemoryMB > 0) {
+		return fmt.Errorf("custom_vcpus and custom_memory_mb must be set together")
+	}
+	if e.CustomMemoryMB%customMemoryStepMB != 0 {
+		return fmt.Errorf("custom_memory_mb must be a multiple of %d", customMemoryStepMB)
+	}
+	return nil
+}
+
+// validateHostname checks that the hostname is a lowercase FQDN with at least
+// two labels, as required by GCP for custom hostnames.
+func validateHostname(hostname string) error {
+	if len(hostname) > maxHostnameLength {
+		return fmt.Errorf("hostname cannot exceed %d characters", maxHostnameLength)
+	}
+	labelRegex, err := regexp.Compile(hostnameLabelRegex)
+	if err != nil {
+		return fmt.Errorf("invalid hostname regex pattern: %w", err)
+	}
+	labels := strings.Split(hostname, ".")
+	if len(labels) < 2 {
+		return fmt.Errorf("hostname '%s' is not a fully qualified domain name", hostname)
+	}
+	for _, label := range labels {
+		if !labelRegex.MatchString(label) {
+			return fmt.Errorf("hostname '%s' is not a valid fully qualified domain name", hostname)
+		}
+	}
 	return nil
 }
 
+func isIPv4(address string) bool {
+	ip := net.ParseIP(address)
+	return ip != nil && ip.To4() != nil
+}
+
+// NetworkInterface describes a network interface attached to an instance.
+type NetworkInterface struct {
+	NetworkID     string         `json:"network_id,omitempty" jsonschema:"description=The name of the network the interface is attached to."`
+	SubnetworkID  string         `json:"subnetwork_id" jsonschema:"description=The name of the subnetwork the interface is attached to."`
+	NicType       string         `json:"nic_type,omitempty" jsonschema:"enum=VIRTIO_NET,enum=GVNIC,enum=IDPF,description=The type of the network interface card. Default is VIRTIO_NET."`
+	AliasIPRanges []AliasIPRange `json:"alias_ip_ranges,omitempty" jsonschema:"description=A list of alias IP ranges for the interface."`
+}
+
+// AliasIPRange is a secondary IP range assigned to a network interface.
+type AliasIPRange struct {
+	IPCidrRange         string `json:"ip_cidr_range" jsonschema:"description=The IP alias range (for example 10.2.3.0/24 or /24)."`
+	SubnetworkRangeName string `json:"subnetwork_range_name,omitempty" jsonschema:"description=The name of the secondary subnetwork range the alias range is allocated from."`
+}
+
+// AdditionalDisk describes a non-boot persistent disk attached to an instance.
+type AdditionalDisk struct {
+	SizeGB         int64  `json:"size_gb" jsonschema:"description=The size of the disk in GB."`
+	Type           string `json:"type,omitempty" jsonschema:"description=The type of the disk. Default is pd-standard."`
+	SourceImage    string `json:"source_image,omitempty" jsonschema:"description=The source image to create this disk."`
+	SourceSnapshot string `json:"source_snapshot,omitempty" jsonschema:"description=The source snapshot to create this disk."`
+	AutoDelete     *bool  `json:"auto_delete,omitempty" jsonschema:"description=Delete the disk when the instance is deleted. Default is true."`
+}
+
 type extraSpecs struct {
-	DiskSize        int64                       `json:"disksize,omitempty" jsonschema:"description=The size of the root disk in GB. Default is 127 GB."`
-	DiskType        string                      `json:"disktype,omitempty" jsonschema:"description=The type of the disk. Default is pd-standard."`
-	DisplayDevice   bool                        `json:"display_device,omitempty" jsonschema:"description=Enable the display device on the VM."`
-	NetworkID       string                      `json:"network_id,omitempty" jsonschema:"description=The name of the network attached to the instance."`
-	SubnetworkID    string                      `json:"subnetwork_id,omitempty" jsonschema:"description=The name of the subnetwork attached to the instance."`
-	NicType         string                      `json:"nic_type,omitempty" jsonschema:"description=The type of the network interface card. Default is VIRTIO_NET."`
-	CustomLabels    map[string]string           `json:"custom_labels,omitempty" jsonschema:"description=Custom labels to apply to the instance. Each label is a key-value pair where both key and value are strings."`
-	NetworkTags     []string                    `json:"network_tags,omitempty" jsonschema:"description=A list of network tags to be attached to the instance"`
-	ServiceAccounts []*computepb.ServiceAccount `json:"service_accounts,omitempty" jsonschema:"description=A list of service accounts to be attached to the instance"`
-	SourceSnapshot  string                      `json:"source_snapshot,omitempty" jsonschema:"description=The source snapshot to create this disk."`
-	SSHKeys         []string                    `json:"ssh_keys,omitempty" jsonschema:"description=A list of SSH keys to be added to the instance. The format is USERNAME:SSH_KEY"`
-	EnableBootDebug *bool                       `json:"enable_boot_debug,omitempty" jsonschema:"description=Enable boot debug on the VM."`
+	DiskSize                    int64                       `json:"disksize,omitempty" jsonschema:"description=The size of the root disk in GB. Must be at least 10 GB and not smaller than the source image or snapshot. Default is 127 GB."`
+	DiskType                    string                      `json:"disktype,omitempty" jsonschema:"description=The type of the disk. Default is the disk_type from the provider config or pd-standard."`
+	DisplayDevice               bool                        `json:"display_device,omitempty" jsonschema:"description=Enable the display device on the VM."`
+	NetworkID                   string                      `json:"network_id,omitempty" jsonschema:"description=The name of the network attached to the instance."`
+	SubnetworkID                string                      `json:"subnetwork_id,omitempty" jsonschema:"description=The name of the subnetwork attached to the instance."`
+	HostProjectID               string                      `json:"host_project_id,omitempty" jsonschema:"description=The ID of the Shared VPC host project that owns the network and subnetwork. When set network and subnetwork names without a path are looked up in the host project."`
+	NicType                     string                      `json:"nic_type,omitempty" jsonschema:"enum=VIRTIO_NET,enum=GVNIC,enum=IDPF,description=The type of the network interface card. GVNIC and IDPF require an image that supports them. Default is VIRTIO_NET."`
+	CustomLabels                map[string]string           `json:"custom_labels,omitempty" jsonschema:"description=Custom labels to apply to the instance. Each label is a key-value pair where both key and value are strings."`
+	DiskLabels                  map[string]string           `json:"disk_labels,omitempty" jsonschema:"description=Labels to apply to the disks of the instance. Default is the custom_labels. The labels used internally by the provider are never applied to disks."`
+	NetworkTags                 []string                    `json:"network_tags,omitempty" jsonschema:"description=A list of network tags to be attached to the instance"`
+	ResourceManagerTags         map[string]string           `json:"resource_manager_tags,omitempty" jsonschema:"description=Resource Manager tags to bind to the instance. Keys are tagKeys/ID or PARENT/KEY_NAME and values are tagValues/ID or VALUE_NAME. Not to be confused with the network_tags."`
+	ServiceAccounts             []*computepb.ServiceAccount `json:"service_accounts,omitempty" jsonschema:"description=A list with the service account to be attached to the instance. GCP only supports one service account per instance."`
+	ServiceAccountEmail         string                      `json:"service_account_email,omitempty" jsonschema:"description=The email of a service account to be attached to the instance. Ignored if service_accounts is set."`
+	ServiceAccountScopes        []string                    `json:"service_account_scopes,omitempty" jsonschema:"description=The scopes of the service_account_email service account. Default is logging.write/monitoring.write/devstorage.read_only."`
+	SourceSnapshot              string                      `json:"source_snapshot,omitempty" jsonschema:"description=The source snapshot to create this disk."`
+	Image                       string                      `json:"image,omitempty" jsonschema:"description=An image used instead of the pool image (for example projects/debian-cloud/global/images/family/debian-12). It can also be an image name when image_project is set."`
+	ImageProject                string                      `json:"image_project,omitempty" jsonschema:"description=The project of the pool image. When set the pool image can be given as an image name or as family/<family> instead of a full image path."`
+	ImageFamily                 string                      `json:"image_family,omitempty" jsonschema:"description=An image family used instead of the pool image. GCP creates the boot disk from the latest image of the family. The family is looked up in the image_project or in the project of the provider."`
+	SourceMachineImage          string                      `json:"source_machine_image,omitempty" jsonschema:"description=A machine image used as the source of the instance (for example projects/my-project/global/machineImages/my-machine-image). When set the boot disk comes from the machine image and the pool image is ignored."`
+	SourceInstanceTemplate      string                      `json:"source_instance_template,omitempty" jsonschema:"description=An instance template used as the source of the instance (for example projects/my-project/global/instanceTemplates/my-template). When set the machine configuration comes from the template and only the name/labels and metadata are set by the provider."`
+	SSHKeys                     []string                    `json:"ssh_keys,omitempty" jsonschema:"description=A list of SSH keys to be added to the instance. The format is USERNAME:KEY_TYPE KEY [COMMENT] (for example user:ssh-ed25519 AAAA... user@host)."`
+	EnableBootDebug             *bool                       `json:"enable_boot_debug,omitempty" jsonschema:"description=Enable boot debug on the VM."`
+	ProvisioningModel           string                      `json:"provisioning_model,omitempty" jsonschema:"enum=STANDARD,enum=SPOT,description=The provisioning model of the instance. Use SPOT to create Spot VMs. Default is STANDARD."`
+	MinCpuPlatform              string                      `json:"min_cpu_platform,omitempty" jsonschema:"description=The minimum CPU platform of the instance (for example Intel Cascade Lake)."`
+	CustomVCPUs                 int64                       `json:"custom_vcpus,omitempty" jsonschema:"description=The number of vCPUs of a custom machine type. Must be set together with custom_memory_mb and overrides the pool flavor."`
+	CustomMemoryMB              int64                       `json:"custom_memory_mb,omitempty" jsonschema:"description=The amount of memory in MB of a custom machine type. Must be a multiple of 256 and set together with custom_vcpus."`
+	NetworkInterfaces           []NetworkInterface          `json:"network_interfaces,omitempty" jsonschema:"description=A list of network interfaces to be attached to the instance. When set it replaces the network_id/subnetwork_id/nic_type settings. The first interface is the primary one."`
+	AdditionalDisks             []AdditionalDisk            `json:"additional_disks,omitempty" jsonschema:"description=A list of additional (non-boot) persistent disks to be attached to the instance."`
+	LocalSSDCount               int64                       `json:"local_ssd_count,omitempty" jsonschema:"description=The number of local NVMe SSDs (375 GB each) to be attached to the instance."`
+	BootDiskInterface           string                      `json:"boot_disk_interface,omitempty" jsonschema:"description=The interface used to attach the boot disk. Can be SCSI or NVME. Default is chosen by GCP."`
+	BootDiskAutoDelete          *bool                       `json:"boot_disk_auto_delete,omitempty" jsonschema:"description=Delete the boot disk when the instance is deleted. Set it to false to keep the disk of failed runners for debugging. Default is true."`
+	BootDiskName                string                      `json:"boot_disk_name,omitempty" jsonschema:"description=The name of the boot disk. Disk names must be unique in a zone so this is meant for pools with max-runners set to 1. Default is the name of the instance."`
+	BootDiskDeviceName          string                      `json:"boot_disk_device_name,omitempty" jsonschema:"description=The device name of the boot disk as seen by the guest OS (under /dev/disk/by-id/google-*). Default is chosen by GCP."`
+	DiskEncryptionKey           string                      `json:"disk_encryption_key,omitempty" jsonschema:"description=The Cloud KMS key used to encrypt the boot disk (for example projects/my-project/locations/europe-west1/keyRings/my-ring/cryptoKeys/my-key). Default is a Google-managed key."`
+	ProvisionedIops             int64                       `json:"provisioned_iops,omitempty" jsonschema:"description=The number of IOPS provisioned for the boot disk. Only supported by hyperdisk disk types."`
+	ProvisionedThroughput       int64                       `json:"provisioned_throughput,omitempty" jsonschema:"description=The throughput in MB/s provisioned for the boot disk. Only supported by hyperdisk disk types."`
+	EnableConfidentialCompute   bool                        `json:"enable_confidential_compute,omitempty" jsonschema:"description=Create a Confidential VM (AMD SEV). Only supported by the N2D/C2D/C3D machine families."`
+	EnableSecureBoot            bool                        `json:"enable_secure_boot,omitempty" jsonschema:"description=Enable Secure Boot on the Shielded VM. The image must support Shielded VM."`
+	EnableVtpm                  bool                        `json:"enable_vtpm,omitempty" jsonschema:"description=Enable the virtual Trusted Platform Module (vTPM) on the Shielded VM. The image must support Shielded VM."`
+	EnableIntegrityMonitoring   bool                        `json:"enable_integrity_monitoring,omitempty" jsonschema:"description=Enable integrity monitoring on the Shielded VM. Requires the vTPM. The image must support Shielded VM."`
+	OnHostMaintenance           string                      `json:"on_host_maintenance,omitempty" jsonschema:"enum=MIGRATE,enum=TERMINATE,description=The maintenance behavior of the instance. Default is chosen by GCP (MIGRATE for standard VMs)."`
+	AutomaticRestart            *bool                       `json:"automatic_restart,omitempty" jsonschema:"description=Restart the instance if it is terminated by GCP. Default is chosen by GCP."`
+	DeletionProtection          bool                        `json:"deletion_protection,omitempty" jsonschema:"description=Protect the instance against accidental deletion. The provider clears the protection when deleting the runner."`
+	ResourcePolicies            []string                    `json:"resource_policies,omitempty" jsonschema:"description=A list of resource policies (for example placement or snapshot schedule policies) to attach to the instance. Each entry is the name or URL of a policy in the region of the instance."`
+	NetworkIP                   string                      `json:"network_ip,omitempty" jsonschema:"description=A static internal IPv4 address for the primary network interface. Default is an ephemeral address."`
+	Description                 string                      `json:"description,omitempty" jsonschema:"description=A description of the instance. Default is garm runner followed by the pool ID."`
+	Hostname                    string                      `json:"hostname,omitempty" jsonschema:"description=A custom fully qualified domain name for the instance (for example runner-1.ci.example.com). Default is the internal DNS name chosen by GCP."`
+	EnableExternalIP            *bool                       `json:"enable_external_ip,omitempty" jsonschema:"description=Attach an external IP to the instance. Overrides the external_ip_access setting from the provider config."`
+	ExternalIP                  string                      `json:"external_ip,omitempty" jsonschema:"description=A reserved static external IPv4 address for the primary network interface. Only used when the instance gets an external IP (see enable_external_ip)."`
+	ExternalIPNetworkTier       string                      `json:"external_ip_network_tier,omitempty" jsonschema:"enum=PREMIUM,enum=STANDARD,description=The network tier of the external IP. STANDARD is cheaper but routes traffic over the public internet. Default is the network_tier from the provider config."`
+	AccessConfigName            string                      `json:"access_config_name,omitempty" jsonschema:"description=The name of the access config of the external IP. Default is External NAT."`
+	EnableIPv6                  bool                        `json:"enable_ipv6,omitempty" jsonschema:"description=Give the primary network interface an external IPv6 address. The subnetwork must be a dual-stack subnetwork with external IPv6 access."`
+	CanIPForward                bool                        `json:"can_ip_forward,omitempty" jsonschema:"description=Allow the instance to send and receive packets with non-matching source or destination IPs."`
+	ThreadsPerCore              int64                       `json:"threads_per_core,omitempty" jsonschema:"description=The number of threads per physical core. Set it to 1 to disable simultaneous multithreading (SMT). Default is chosen by GCP."`
+	VisibleCoreCount            int64                       `json:"visible_core_count,omitempty" jsonschema:"description=The number of physical cores exposed to the instance. Default is all the cores of the machine type."`
+	CustomMetadata              map[string]string           `json:"custom_metadata,omitempty" jsonschema:"description=Custom metadata items to add to the instance. Keys used by the provider (user-data/sysprep-specialize-script-ps1/runner_name/ssh-keys) are ignored."`
+	EnableOSLogin               bool                        `json:"enable_oslogin,omitempty" jsonschema:"description=Enable OS Login on the instance. When enabled the ssh_keys are not added to the instance metadata."`
+	BlockProjectSSHKeys         bool                        `json:"block_project_ssh_keys,omitempty" jsonschema:"description=Do not allow the project-wide SSH keys to access the instance. Only the ssh_keys of the instance are used."`
+	InstallOpsAgent             bool                        `json:"install_ops_agent,omitempty" jsonschema:"description=Install the Google Cloud Ops Agent on Linux instances before the runner is installed. Requires a service account with the logging.write and monitoring.write scopes."`
+	PreCreateCommands           []string                    `json:"pre_create_commands,omitempty" jsonschema:"description=A list of shell commands that Linux instances run before anything else in the cloud-init runcmd section (for example to mount a local SSD). They run before the Ops Agent and runner install."`
+	RawStartupScript            []byte                      `json:"raw_startup_script,omitempty" jsonschema:"description=A base64 encoded startup script that replaces the cloud-init config or PowerShell script generated by the provider. The script is responsible for installing the runner."`
+	WindowsStartupScriptKey     string                      `json:"windows_startup_script_key,omitempty" jsonschema:"enum=sysprep-specialize-script-ps1,enum=windows-startup-script-ps1,description=The metadata key used to pass the startup script to Windows instances. Use windows-startup-script-ps1 for custom images that already ran sysprep. Default is sysprep-specialize-script-ps1."`
+	WindowsExtraSysprepCmds     []string                    `json:"windows_extra_sysprep_cmds,omitempty" jsonschema:"description=A list of commands that Windows instances run with cmd.exe during sysprep specialization (for example googet installs). They are passed in the sysprep-specialize-script-cmd metadata key."`
+	WindowsNetworkRetries       int                         `json:"windows_network_retries,omitempty" jsonschema:"description=The number of times Windows instances check that the network and the garm callback URL are reachable before installing the runner. Default is 30."`
+	WindowsNetworkRetryInterval int                         `json:"windows_network_retry_interval,omitempty" jsonschema:"description=The number of seconds to wait between the network checks of Windows instances. Default is 10."`
+	WaitForGuestAttributes      bool                        `json:"wait_for_guest_attributes,omitempty" jsonschema:"description=Make Windows instances wait until they can write a guest attribute to the metadata server before installing the runner. The garm/ready guest attribute is set once the metadata server is reachable."`
 	// The Cloudconfig struct from common package
 	cloudconfig.CloudConfigSpec
 }
@@ -159,7 +599,20 @@ func GetRunnerSpecFromBootstrapParams(cfg *config.Config, data params.BootstrapI
 		garmPoolID:       data.PoolID,
 		garmControllerID: controllerID,
 		osType:           string(data.OSType),
+		// The architecture is stored as a label because GCP does not
+		// always report it on the boot disk.
+		gcputil.OSArchLabel: string(data.OSArch),
+		// Label values cannot contain colons, so the creation time is stored
+		// as seconds since the epoch.
+		garmCreatedAt: strconv.FormatInt(timeNow().Unix(), 10),
 	}
+	// The default labels from the provider config never replace the labels
+	// used internally by the provider.
+	defaultLabels := maps.Clone(cfg.DefaultLabels)
+	maps.DeleteFunc(defaultLabels, func(key, _ string) bool {
+		return slices.Contains(reservedLabelKeys, key)
+	})
+	maps.Copy(labels, defaultLabels)
 
 	spec := &RunnerSpec{
 		Zone:            cfg.Zone,
@@ -170,31 +623,95 @@ func GetRunnerSpecFromBootstrapParams(cfg *config.Config, data params.BootstrapI
 		ControllerID:    controllerID,
 		NicType:         defaultNicType,
 		DiskSize:        defaultDiskSizeGB,
+		DiskType:        cfg.DiskType,
 		CustomLabels:    labels,
+		DiskLabels:      defaultLabels,
+		Description:     fmt.Sprintf("garm runner %s", data.PoolID),
+
+		ExternalIPNetworkTier:       cfg.NetworkTier,
+		AccessConfigName:            defaultAccessConfigName,
+		WindowsNetworkRetries:       defaultWindowsNetworkRetries,
+		WindowsNetworkRetryInterval: defaultWindowsNetworkRetryInterval,
 	}
 
 	spec.MergeExtraSpecs(extraSpecs)
 
+	if err := spec.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate runner spec: %w", err)
+	}
+
 	return spec, nil
 }
 
 type RunnerSpec struct {
-	Zone            string
-	Tools           params.RunnerApplicationDownload
-	BootstrapParams params.BootstrapInstance
-	NetworkID       string
-	SubnetworkID    string
-	ControllerID    string
-	NicType         string
-	DisplayDevice   bool
-	DiskSize        int64
-	DiskType        string
-	CustomLabels    map[string]string
-	NetworkTags     []string
-	ServiceAccounts []*computepb.ServiceAccount
-	SourceSnapshot  string
-	SSHKeys         string
-	EnableBootDebug bool
+	Zone                   string
+	Tools                  params.RunnerApplicationDownload
+	BootstrapParams        params.BootstrapInstance
+	NetworkID              string
+	SubnetworkID           string
+	HostProjectID          string
+	ControllerID           string
+	NicType                string
+	DisplayDevice          bool
+	DiskSize               int64
+	DiskType               string
+	CustomLabels           map[string]string
+	DiskLabels             map[string]string
+	NetworkTags            []string
+	ResourceManagerTags    map[string]string
+	ServiceAccounts        []*computepb.ServiceAccount
+	SourceSnapshot         string
+	SourceInstanceTemplate string
+	SourceMachineImage     string
+	SSHKeys                string
+	EnableBootDebug        bool
+	ProvisioningModel      string
+	MinCpuPlatform         string
+	NetworkInterfaces      []NetworkInterface
+	AdditionalDisks        []AdditionalDisk
+	LocalSSDCount          int64
+	BootDiskInterface      string
+	// BootDiskAutoDelete defaults to true when not set.
+	BootDiskAutoDelete        *bool
+	BootDiskName              string
+	BootDiskDeviceName        string
+	DiskEncryptionKey         string
+	ProvisionedIops           int64
+	ProvisionedThroughput     int64
+	EnableConfidentialCompute bool
+	EnableSecureBoot          bool
+	EnableVtpm                bool
+	EnableIntegrityMonitoring bool
+	OnHostMaintenance         string
+	AutomaticRestart          *bool
+	DeletionProtection        bool
+	ResourcePolicies          []string
+	NetworkIP                 string
+	Description               string
+	Hostname                  string
+	EnableExternalIP          *bool
+	ExternalIP                string
+	ExternalIPNetworkTier     string
+	AccessConfigName          string
+	EnableIPv6                bool
+	CanIPForward              bool
+	ThreadsPerCore            int64
+	VisibleCoreCount          int64
+	CustomMetadata            map[string]string
+	EnableOSLogin             bool
+	BlockProjectSSHKeys       bool
+	InstallOpsAgent           bool
+	PreCreateCommands         []string
+	RawStartupScript          []byte
+	WindowsStartupScriptKey   string
+	WindowsExtraSysprepCmds   []string
+	// WindowsNetworkRetries is the number of network checks done by Windows
+	// instances before installing the runner. Zero disables the checks.
+	WindowsNetworkRetries       int
+	WindowsNetworkRetryInterval int
+	// WaitForGuestAttributes makes Windows instances wait until they can
+	// write the garm/ready guest attribute before installing the runner.
+	WaitForGuestAttributes bool
 }
 
 func (r *RunnerSpec) MergeExtraSpecs(extraSpecs *extraSpecs) {
@@ -204,6 +721,9 @@ func (r *RunnerSpec) MergeExtraSpecs(extraSpecs *extraSpecs) {
 	if extraSpecs.SubnetworkID != "" {
 		r.SubnetworkID = extraSpecs.SubnetworkID
 	}
+	if extraSpecs.HostProjectID != "" {
+		r.HostProjectID = extraSpecs.HostProjectID
+	}
 	if extraSpecs.DisplayDevice {
 		r.DisplayDevice = extraSpecs.DisplayDevice
 	}
@@ -219,23 +739,204 @@ func (r *RunnerSpec) MergeExtraSpecs(extraSpecs *extraSpecs) {
 	if len(extraSpecs.CustomLabels) > 0 {
 		maps.Copy(r.CustomLabels, extraSpecs.CustomLabels)
 	}
+	// Disks only get the user labels, not the internal ones used to track
+	// the instance.
+	if len(extraSpecs.DiskLabels) > 0 {
+		r.DiskLabels = maps.Clone(extraSpecs.DiskLabels)
+	} else if len(extraSpecs.CustomLabels) > 0 {
+		if r.DiskLabels == nil {
+			r.DiskLabels = make(map[string]string, len(extraSpecs.CustomLabels))
+		}
+		maps.Copy(r.DiskLabels, extraSpecs.CustomLabels)
+	}
+	if len(extraSpecs.ResourceManagerTags) > 0 {
+		r.ResourceManagerTags = maps.Clone(extraSpecs.ResourceManagerTags)
+	}
 	if len(extraSpecs.NetworkTags) > 0 {
 		r.NetworkTags = extraSpecs.NetworkTags
 	}
 	if len(extraSpecs.ServiceAccounts) > 0 {
 		r.ServiceAccounts = extraSpecs.ServiceAccounts
+	} else if extraSpecs.ServiceAccountEmail != "" {
+		scopes := extraSpecs.ServiceAccountScopes
+		if len(scopes) == 0 {
+			scopes = DefaultServiceAccountScopes
+		}
+		r.ServiceAccounts = []*computepb.ServiceAccount{
+			{
+				Email:  proto.String(extraSpecs.ServiceAccountEmail),
+				Scopes: scopes,
+			},
+		}
 	}
 	if extraSpecs.SourceSnapshot != "" {
 		r.SourceSnapshot = extraSpecs.SourceSnapshot
 	}
+	if extraSpecs.SourceInstanceTemplate != "" {
+		r.SourceInstanceTemplate = extraSpecs.SourceInstanceTemplate
+	}
+	if extraSpecs.SourceMachineImage != "" {
+		r.SourceMachineImage = extraSpecs.SourceMachineImage
+	}
 	if len(extraSpecs.SSHKeys) > 0 {
-		for key := range extraSpecs.SSHKeys {
-			r.SSHKeys = r.SSHKeys + "\n" + extraSpecs.SSHKeys[key]
-		}
+		r.SSHKeys = strings.Join(extraSpecs.SSHKeys, "\n")
 	}
 	if extraSpecs.EnableBootDebug != nil {
 		r.EnableBootDebug = *extraSpecs.EnableBootDebug
 	}
+	if extraSpecs.ProvisioningModel != "" {
+		r.ProvisioningModel = extraSpecs.ProvisioningModel
+	}
+	if extraSpecs.MinCpuPlatform != "" {
+		r.MinCpuPlatform = extraSpecs.MinCpuPlatform
+	}
+	if len(extraSpecs.NetworkInterfaces) > 0 {
+		r.NetworkInterfaces = make([]NetworkInterface, len(extraSpecs.NetworkInterfaces))
+		for idx, nic := range extraSpecs.NetworkInterfaces {
+			if nic.NicType == "" {
+				nic.NicType = r.NicType
+			}
+			r.NetworkInterfaces[idx] = nic
+		}
+	}
+	if len(extraSpecs.AdditionalDisks) > 0 {
+		r.AdditionalDisks = extraSpecs.AdditionalDisks
+	}
+	if extraSpecs.LocalSSDCount > 0 {
+		r.LocalSSDCount = extraSpecs.LocalSSDCount
+	}
+	if extraSpecs.BootDiskInterface != "" {
+		r.BootDiskInterface = extraSpecs.BootDiskInterface
+	}
+	if extraSpecs.BootDiskAutoDelete != nil {
+		r.BootDiskAutoDelete = extraSpecs.BootDiskAutoDelete
+	}
+	if extraSpecs.BootDiskName != "" {
+		r.BootDiskName = extraSpecs.BootDiskName
+	}
+	if extraSpecs.BootDiskDeviceName != "" {
+		r.BootDiskDeviceName = extraSpecs.BootDiskDeviceName
+	}
+	if extraSpecs.DiskEncryptionKey != "" {
+		r.DiskEncryptionKey = extraSpecs.DiskEncryptionKey
+	}
+	if extraSpecs.ProvisionedIops > 0 {
+		r.ProvisionedIops = extraSpecs.ProvisionedIops
+	}
+	if extraSpecs.ProvisionedThroughput > 0 {
+		r.ProvisionedThroughput = extraSpecs.ProvisionedThroughput
+	}
+	if extraSpecs.EnableConfidentialCompute {
+		r.EnableConfidentialCompute = extraSpecs.EnableConfidentialCompute
+	}
+	if extraSpecs.EnableSecureBoot {
+		r.EnableSecureBoot = extraSpecs.EnableSecureBoot
+	}
+	if extraSpecs.EnableVtpm {
+		r.EnableVtpm = extraSpecs.EnableVtpm
+	}
+	if extraSpecs.EnableIntegrityMonitoring {
+		r.EnableIntegrityMonitoring = extraSpecs.EnableIntegrityMonitoring
+	}
+	if extraSpecs.OnHostMaintenance != "" {
+		r.OnHostMaintenance = extraSpecs.OnHostMaintenance
+	}
+	if extraSpecs.AutomaticRestart != nil {
+		r.AutomaticRestart = extraSpecs.AutomaticRestart
+	}
+	if extraSpecs.DeletionProtection {
+		r.DeletionProtection = extraSpecs.DeletionProtection
+	}
+	if len(extraSpecs.ResourcePolicies) > 0 {
+		r.ResourcePolicies = slices.Clone(extraSpecs.ResourcePolicies)
+	}
+	if extraSpecs.Description != "" {
+		r.Description = extraSpecs.Description
+	}
+	if extraSpecs.Hostname != "" {
+		r.Hostname = extraSpecs.Hostname
+	}
+	if extraSpecs.NetworkIP != "" {
+		r.NetworkIP = extraSpecs.NetworkIP
+	}
+	if extraSpecs.EnableExternalIP != nil {
+		r.EnableExternalIP = extraSpecs.EnableExternalIP
+	}
+	if extraSpecs.ExternalIP != "" {
+		r.ExternalIP = extraSpecs.ExternalIP
+	}
+	if extraSpecs.ExternalIPNetworkTier != "" {
+		r.ExternalIPNetworkTier = extraSpecs.ExternalIPNetworkTier
+	}
+	if extraSpecs.AccessConfigName != "" {
+		r.AccessConfigName = extraSpecs.AccessConfigName
+	}
+	if extraSpecs.EnableIPv6 {
+		r.EnableIPv6 = extraSpecs.EnableIPv6
+	}
+	if extraSpecs.CanIPForward {
+		r.CanIPForward = extraSpecs.CanIPForward
+	}
+	if extraSpecs.ThreadsPerCore > 0 {
+		r.ThreadsPerCore = extraSpecs.ThreadsPerCore
+	}
+	if extraSpecs.VisibleCoreCount > 0 {
+		r.VisibleCoreCount = extraSpecs.VisibleCoreCount
+	}
+	if len(extraSpecs.CustomMetadata) > 0 {
+		r.CustomMetadata = extraSpecs.CustomMetadata
+	}
+	if extraSpecs.EnableOSLogin {
+		r.EnableOSLogin = extraSpecs.EnableOSLogin
+	}
+	if extraSpecs.BlockProjectSSHKeys {
+		r.BlockProjectSSHKeys = extraSpecs.BlockProjectSSHKeys
+	}
+	if extraSpecs.InstallOpsAgent {
+		r.InstallOpsAgent = extraSpecs.InstallOpsAgent
+	}
+	if len(extraSpecs.PreCreateCommands) > 0 {
+		r.PreCreateCommands = extraSpecs.PreCreateCommands
+	}
+	if len(extraSpecs.RawStartupScript) > 0 {
+		r.RawStartupScript = extraSpecs.RawStartupScript
+	}
+	if extraSpecs.WindowsStartupScriptKey != "" {
+		r.WindowsStartupScriptKey = extraSpecs.WindowsStartupScriptKey
+	}
+	if len(extraSpecs.WindowsExtraSysprepCmds) > 0 {
+		r.WindowsExtraSysprepCmds = extraSpecs.WindowsExtraSysprepCmds
+	}
+	if extraSpecs.WindowsNetworkRetries > 0 {
+		r.WindowsNetworkRetries = extraSpecs.WindowsNetworkRetries
+	}
+	if extraSpecs.WindowsNetworkRetryInterval > 0 {
+		r.WindowsNetworkRetryInterval = extraSpecs.WindowsNetworkRetryInterval
+	}
+	if extraSpecs.WaitForGuestAttributes {
+		r.WaitForGuestAttributes = extraSpecs.WaitForGuestAttributes
+	}
+	if extraSpecs.CustomVCPUs > 0 && extraSpecs.CustomMemoryMB > 0 {
+		r.BootstrapParams.Flavor = gcputil.GetCustomMachineType(extraSpecs.CustomVCPUs, extraSpecs.CustomMemoryMB)
+	}
+	if extraSpecs.Image != "" {
+		r.BootstrapParams.Image = extraSpecs.Image
+	}
+	if extraSpecs.ImageFamily != "" {
+		// Families without a project are resolved by GCP in the project of
+		// the instance.
+		r.BootstrapParams.Image = fmt.Sprintf("global/images/family/%s", extraSpecs.ImageFamily)
+		if extraSpecs.ImageProject != "" {
+			r.BootstrapParams.Image = gcputil.BuildImageURL(extraSpecs.ImageProject, "family/"+extraSpecs.ImageFamily)
+		}
+	} else if extraSpecs.ImageProject != "" {
+		r.BootstrapParams.Image = gcputil.BuildImageURL(extraSpecs.ImageProject, r.BootstrapParams.Image)
+	}
+	if r.SourceSnapshot != "" || r.SourceMachineImage != "" {
+		// The boot disk is created from the snapshot or the machine image,
+		// so the pool image is not used.
+		r.BootstrapParams.Image = ""
+	}
 }
 
 func (r *RunnerSpec) Validate() error {
@@ -254,10 +955,42 @@ func (r *RunnerSpec) Validate() error {
 	if r.NicType == "" {
 		return fmt.Errorf("missing nic type")
 	}
+	// Instances created from a template or a machine image get their boot
+	// disk from it.
+	if r.SourceInstanceTemplate == "" && r.SourceMachineImage == "" {
+		if r.BootstrapParams.Image != "" && r.SourceSnapshot != "" {
+			return fmt.Errorf("only one of image or source snapshot can be set for the boot disk")
+		}
+		if r.BootstrapParams.Image == "" && r.SourceSnapshot == "" {
+			return fmt.Errorf("missing boot disk source: an image or a source snapshot is required")
+		}
+	}
+	if len(r.CustomLabels) > maxLabels {
+		return fmt.Errorf("instance labels cannot exceed %d items, including the internal and default labels", maxLabels)
+	}
+	if (r.ProvisionedIops > 0 || r.ProvisionedThroughput > 0) && !strings.Contains(r.DiskType, hyperdiskTypePrefix) {
+		return fmt.Errorf("provisioned iops and throughput are only supported by hyperdisk disk types")
+	}
+	if r.InstallOpsAgent && r.BootstrapParams.OSType == params.Linux {
+		if !hasAnyScope(r.ServiceAccounts, opsAgentLoggingScopes) || !hasAnyScope(r.ServiceAccounts, opsAgentMonitoringScopes) {
+			return fmt.Errorf("install_ops_agent requires a service account with the logging.write and monitoring.write scopes")
+		}
+	}
+	if r.EnableConfidentialCompute {
+		family := gcputil.GetMachineFamily(r.BootstrapParams.Flavor)
+		if !slices.Contains(confidentialComputeFamilies, family) {
+			return fmt.Errorf("confidential compute is not supported by machine type %s", r.BootstrapParams.Flavor)
+		}
+	}
 	return nil
 }
 
 func (r RunnerSpec) ComposeUserData() (string, error) {
+	if len(r.RawStartupScript) > 0 {
+		// The user supplied script replaces the generated one as it is.
+		return string(r.RawStartupScript), nil
+	}
+
 	bootstrapParams := r.BootstrapParams
 	bootstrapParams.UserDataOptions.EnableBootDebug = r.EnableBootDebug
 
@@ -268,6 +1001,19 @@ func (r RunnerSpec) ComposeUserData() (string, error) {
 		if err != nil {
 			return "", fmt.Errorf("failed to generate userdata: %w", err)
 		}
+		if r.InstallOpsAgent {
+			udata, err = prependRunCmds(udata, opsAgentInstallCmds...)
+			if err != nil {
+				return "", fmt.Errorf("failed to add ops agent install commands: %w", err)
+			}
+		}
+		if len(r.PreCreateCommands) > 0 {
+			// Added last, so they run before the ops agent install.
+			udata, err = prependRunCmds(udata, r.PreCreateCommands...)
+			if err != nil {
+				return "", fmt.Errorf("failed to add pre create commands: %w", err)
+			}
+		}
 		return udata, nil
 
 	case params.Windows:
@@ -275,7 +1021,145 @@ func (r RunnerSpec) ComposeUserData() (string, error) {
 		if err != nil {
 			return "", fmt.Errorf("failed to generate userdata: %w", err)
 		}
+		var preamble string
+		if r.WaitForGuestAttributes {
+			preamble += r.windowsGuestAttributesWaitScript()
+		}
+		if r.WindowsNetworkRetries > 0 {
+			preamble += r.windowsNetworkWaitScript()
+		}
+		if preamble != "" {
+			return insertWindowsNetworkWait(string(udata), preamble), nil
+		}
 		return string(udata), nil
 	}
-	return "", fmt.Errorf("unsupported OS type for cloud config: %s", r.BootstrapParams.OSType)
+	return "", fmt.Errorf("unsupported OS type %q for user data: only %s and %s are supported", r.BootstrapParams.OSType, params.Linux, params.Windows)
+}
+
+// windowsNetworkWaitScript returns a PowerShell snippet that waits until the
+// instance has a default route and can open a TCP connection to the garm
+// callback URL. Windows instances sometimes start the install script before
+// the network is ready, which makes the runner fail to call home. The script
+// carries on after the last retry, so the usual install errors are reported.
+func (r RunnerSpec) windowsNetworkWaitScript() string {
+	interval := r.WindowsNetworkRetryInterval
+	if interval <= 0 {
+		interval = defaultWindowsNetworkRetryInterval
+	}
+
+	var host, port string
+	if u, err := url.Parse(r.BootstrapParams.CallbackURL); err == nil && u.Hostname() != "" {
+		host = u.Hostname()
+		port = u.Port()
+		if port == "" {
+			port = "443"
+			if u.Scheme == "http" {
+				port = "80"
+			}
+		}
+	}
+
+	check := "$true"
+	target := "the default route"
+	if host != "" {
+		check = fmt.Sprintf("Test-GarmConnection -ComputerName %q -Port %s", host, port)
+		target = fmt.Sprintf("%s:%s", host, port)
+	}
+
+	return fmt.Sprintf(`function Test-GarmConnection {
+    Param([string]$ComputerName, [int]$Port)
+    $client = New-Object System.Net.Sockets.TcpClient
+    try {
+        $client.Connect($ComputerName, $Port)
+        return $true
+    } catch {
+        return $false
+    } finally {
+        $client.Dispose()
+    }
+}
+
+for ($garmNetworkRetry = 1; $garmNetworkRetry -le %d; $garmNetworkRetry++) {
+    $defaultRoute = Get-NetRoute -DestinationPrefix "0.0.0.0/0" -ErrorAction SilentlyContinue
+    if ($defaultRoute -and (%s)) {
+        break
+    }
+    Write-Output "Waiting for network connectivity to %s (attempt $garmNetworkRetry of %d)"
+    Start-Sleep -Seconds %d
+}
+`, r.WindowsNetworkRetries, check, target, r.WindowsNetworkRetries, interval)
+}
+
+// windowsGuestAttributesWaitScript returns a PowerShell snippet that waits
+// until the instance can write the garm/ready guest attribute, which means the
+// metadata server is reachable. The attribute can also be read through the
+// compute API to check how far the bootstrap got. Like the network checks,
+// the script carries on after the last retry.
+func (r RunnerSpec) windowsGuestAttributesWaitScript() string {
+	retries := r.WindowsNetworkRetries
+	if retries <= 0 {
+		retries = defaultWindowsNetworkRetries
+	}
+	interval := r.WindowsNetworkRetryInterval
+	if interval <= 0 {
+		interval = defaultWindowsNetworkRetryInterval
+	}
+
+	return fmt.Sprintf(`for ($garmGuestAttributesRetry = 1; $garmGuestAttributesRetry -le %d; $garmGuestAttributesRetry++) {
+    try {
+        Invoke-RestMethod -Method Put -Uri "%s" -Headers @{"Metadata-Flavor"="Google"} -Body "true" | Out-Null
+        break
+    } catch {
+        Write-Output "Waiting for the metadata server guest attributes (attempt $garmGuestAttributesRetry of %d)"
+        Start-Sleep -Seconds %d
+    }
+}
+`, retries, guestAttributesReadyURL, retries, interval)
+}
+
+// insertWindowsNetworkWait inserts the network wait snippet after the Param
+// block of the install script, as PowerShell requires Param to be the first
+// statement. Scripts without a Param block get the snippet after the
+// #ps1_sysnative marker, or at the very beginning.
+func insertWindowsNetworkWait(udata, waitScript string) string {
+	pos := 0
+	if strings.HasPrefix(udata, "#ps1_sysnative\n") {
+		pos = len("#ps1_sysnative\n")
+	}
+	if strings.HasPrefix(strings.TrimLeft(udata[pos:], "\r\n"), "Param(") {
+		if end := strings.Index(udata[pos:], "\n)"); end >= 0 {
+			pos += end + len("\n)")
+			if strings.HasPrefix(udata[pos:], "\n") {
+				pos++
+			}
+		}
+	}
+	return udata[:pos] + waitScript + udata[pos:]
+}
+
+// prependRunCmds adds the given commands to the beginning of the runcmd list of
+// a cloud-config, so they run before the runner is installed.
+func prependRunCmds(udata string, cmds ...string) (string, error) {
+	cloudCfg := &cloudconfig.CloudInit{}
+	if err := yaml.Unmarshal([]byte(udata), cloudCfg); err != nil {
+		return "", fmt.Errorf("failed to parse cloud config: %w", err)
+	}
+	cloudCfg.RunCmd = append(slices.Clone(cmds), cloudCfg.RunCmd...)
+
+	asStr, err := cloudCfg.Serialize()
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize cloud config: %w", err)
+	}
+	return asStr, nil
+}
+
+func hasAnyScope(serviceAccounts []*computepb.ServiceAccount, scopes []string) bool {
+	for _, sa := range serviceAccounts {
+		for _, scope := range sa.GetScopes() {
+			if slices.Contains(scopes, scope) {
+				return true
+			}
+		}
+	}
+	return false
 }
@@ -18,12 +18,19 @@ package spec
 import (
 	"encoding/json"
 	"fmt"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"cloud.google.com/go/compute/apiv1/computepb"
+	"github.com/cloudbase/garm-provider-common/cloudconfig"
+	"github.com/cloudbase/garm-provider-common/params"
+	"github.com/cloudbase/garm-provider-gcp/config"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"google.golang.org/protobuf/proto"
+	"gopkg.in/yaml.v3"
 )
 
 func TestJsonSchemaValidation(t *testing.T) {
@@ -44,12 +51,68 @@ func TestJsonSchemaValidation(t *testing.T) {
 				"custom_labels": {
 					"example_label": "example_value"
 				},
+				"disk_labels": {
+					"disk_label": "disk_value"
+				},
+				"resource_manager_tags": {
+					"tagKeys/123456": "tagValues/654321",
+					"my-project/env": "prod"
+				},
 				"network_tags": ["example_tag"],
 				"service_accounts": [{"email": "email", "scopes": ["scope"]}],
 				"service_accounts": [{"email": "email", "scopes": ["scope", "scope2"]}, {"email": "email2", "scopes": ["scope2"]}],
 				"source_snapshot": "snapshot-id",
-				"ssh_keys": ["ssh-key", "ssh-key2"],
+				"image_project": "debian-cloud",
+				"image_family": "debian-12",
+				"ssh_keys": ["user:ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIFakeKey user@host", "user2:ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABAQ=="],
 				"enable_boot_debug": true,
+				"provisioning_model": "SPOT",
+				"min_cpu_platform": "Intel Cascade Lake",
+				"custom_vcpus": 4,
+				"custom_memory_mb": 8192,
+				"network_interfaces": [{"network_id": "default", "subnetwork_id": "default", "nic_type": "GVNIC", "alias_ip_ranges": [{"ip_cidr_range": "/24"}]}],
+				"additional_disks": [{"size_gb": 100, "type": "pd-ssd", "auto_delete": false}],
+				"local_ssd_count": 2,
+				"boot_disk_interface": "NVME",
+				"boot_disk_auto_delete": false,
+				"boot_disk_name": "garm-boot-disk",
+				"boot_disk_device_name": "runner-root",
+				"disk_encryption_key": "projects/my-project/locations/europe-west1/keyRings/garm/cryptoKeys/runners",
+				"provisioned_iops": 5000,
+				"provisioned_throughput": 250,
+				"enable_confidential_compute": true,
+				"enable_secure_boot": true,
+				"enable_vtpm": true,
+				"enable_integrity_monitoring": true,
+				"on_host_maintenance": "TERMINATE",
+				"automatic_restart": false,
+				"deletion_protection": true,
+				"resource_policies": ["projects/my-project/regions/europe-west1/resourcePolicies/compact"],
+				"source_instance_template": "projects/my-project/global/instanceTemplates/garm-runner",
+				"source_machine_image": "projects/my-project/global/machineImages/garm-runner",
+				"network_ip": "10.10.0.5",
+				"description": "CI runner",
+				"hostname": "runner-1.ci.example.com",
+				"enable_external_ip": true,
+				"external_ip": "203.0.113.10",
+				"external_ip_network_tier": "STANDARD",
+				"access_config_name": "external-nat",
+				"host_project_id": "my-host-project",
+				"enable_ipv6": true,
+				"can_ip_forward": true,
+				"threads_per_core": 1,
+				"visible_core_count": 2,
+				"custom_metadata": {"team": "ci"},
+				"enable_oslogin": true,
+				"block_project_ssh_keys": true,
+				"install_ops_agent": true,
+				"pre_create_commands": ["mkfs.ext4 -F /dev/nvme0n1", "mount /dev/nvme0n1 /home/runner"],
+				"raw_startup_script": "IyEvYmluL2Jhc2gKZWNobyBoZWxsbw==",
+				"windows_startup_script_key": "windows-startup-script-ps1",
+				"windows_extra_sysprep_cmds": ["googet -noconfirm=true install git"],
+				"windows_network_retries": 60,
+				"windows_network_retry_interval": 5,
+				"wait_for_guest_attributes": true,
 				"runner_install_template": "IyEvYmluL2Jhc2gKZWNobyBJbnN0YWxsaW5nIHJ1bm5lci4uLg==", "pre_install_scripts": {"setup.sh": "IyEvYmluL2Jhc2gKZWNobyBTZXR1cCBzY3JpcHQuLi4="}, "extra_context": {"key": "value"}
 				}`),
 			errString: "",
@@ -112,6 +175,14 @@ func TestJsonSchemaValidation(t *testing.T) {
 			}`),
 			errString: "",
 		},
+		{
+			name: "Specs just with service_account_email",
+			input: json.RawMessage(`{
+				"service_account_email": "runner@my-project.iam.gserviceaccount.com",
+				"service_account_scopes": ["https://www.googleapis.com/auth/cloud-platform"]
+			}`),
+			errString: "",
+		},
 		{
 			name: "Specs just with service_accounts",
 			input: json.RawMessage(`{
@@ -129,7 +200,7 @@ func TestJsonSchemaValidation(t *testing.T) {
 		{
 			name: "Specs just with ssh_keys",
 			input: json.RawMessage(`{
-				"ssh_keys": ["ssh-key", "ssh-key2"]
+				"ssh_keys": ["user:ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIFakeKey user@host", "user2:ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABAQ=="]
 			}`),
 			errString: "",
 		},
@@ -140,6 +211,55 @@ func TestJsonSchemaValidation(t *testing.T) {
 			}`),
 			errString: "",
 		},
+		{
+			name: "Specs just with provisioning_model",
+			input: json.RawMessage(`{
+				"provisioning_model": "SPOT"
+			}`),
+			errString: "",
+		},
+		{
+			name: "Specs just with min_cpu_platform",
+			input: json.RawMessage(`{
+				"min_cpu_platform": "Intel Cascade Lake"
+			}`),
+			errString: "",
+		},
+		{
+			name: "Specs with custom machine type",
+			input: json.RawMessage(`{
+				"custom_vcpus": 4,
+				"custom_memory_mb": 8192
+			}`),
+			errString: "",
+		},
+		{
+			name: "Specs just with network_interfaces",
+			input: json.RawMessage(`{
+				"network_interfaces": [
+					{"subnetwork_id": "primary"},
+					{"network_id": "secondary", "subnetwork_id": "secondary", "alias_ip_ranges": [{"ip_cidr_range": "10.0.0.0/24", "subnetwork_range_name": "pods"}]}
+				]
+			}`),
+			errString: "",
+		},
+		{
+			name: "Specs just with additional_disks",
+			input: json.RawMessage(`{
+				"additional_disks": [
+					{"size_gb": 100},
+					{"size_gb": 200, "type": "pd-ssd", "source_snapshot": "snapshot-id", "auto_delete": false}
+				]
+			}`),
+			errString: "",
+		},
+		{
+			name: "Specs just with local_ssd_count",
+			input: json.RawMessage(`{
+				"local_ssd_count": 2
+			}`),
+			errString: "",
+		},
 		{
 			name: "Specs just with runner_install_template",
 			input: json.RawMessage(`{
@@ -228,6 +348,62 @@ func TestJsonSchemaValidation(t *testing.T) {
 			}`),
 			errString: "schema validation failed: [enable_boot_debug: Invalid type. Expected: boolean, given: string]",
 		},
+		{
+			name: "Invalid input for provisioning_model - wrong value",
+			input: json.RawMessage(`{
+				"provisioning_model": "PREEMPTIBLE"
+			}`),
+			errString: "provisioning_model must be one of the following",
+		},
+		{
+			name: "Invalid input for windows_startup_script_key - wrong value",
+			input: json.RawMessage(`{
+				"windows_startup_script_key": "windows-startup-script-cmd"
+			}`),
+			errString: "windows_startup_script_key must be one of the following",
+		},
+		{
+			name: "Invalid input for on_host_maintenance - wrong value",
+			input: json.RawMessage(`{
+				"on_host_maintenance": "RESTART"
+			}`),
+			errString: "on_host_maintenance must be one of the following",
+		},
+		{
+			name: "Invalid input for automatic_restart - wrong type",
+			input: json.RawMessage(`{
+				"automatic_restart": "true"
+			}`),
+			errString: "schema validation failed: [automatic_restart: Invalid type. Expected: boolean, given: string]",
+		},
+		{
+			name: "Invalid input for min_cpu_platform - wrong data type",
+			input: json.RawMessage(`{
+				"min_cpu_platform": 127
+			}`),
+			errString: "schema validation failed: [min_cpu_platform: Invalid type. Expected: string, given: integer]",
+		},
+		{
+			name: "Invalid input for custom_vcpus - wrong data type",
+			input: json.RawMessage(`{
+				"custom_vcpus": "4"
+			}`),
+			errString: "schema validation failed: [custom_vcpus: Invalid type. Expected: integer, given: string]",
+		},
+		{
+			name: "Invalid input for network_interfaces - missing subnetwork_id",
+			input: json.RawMessage(`{
+				"network_interfaces": [{"network_id": "default"}]
+			}`),
+			errString: "subnetwork_id is required",
+		},
+		{
+			name: "Invalid input for additional_disks - missing size_gb",
+			input: json.RawMessage(`{
+				"additional_disks": [{"type": "pd-ssd"}]
+			}`),
+			errString: "size_gb is required",
+		},
 		{
 			name: "Invalid input for runner_install_template - wrong data type",
 			input: json.RawMessage(`{
@@ -249,6 +425,13 @@ func TestJsonSchemaValidation(t *testing.T) {
 			}`),
 			errString: "schema validation failed: [extra_context: Invalid type. Expected: object, given: string]",
 		},
+		{
+			name: "Invalid input for nic_type - unsupported value",
+			input: json.RawMessage(`{
+				"nic_type": "E1000"
+			}`),
+			errString: "nic_type must be one of the following",
+		},
 		{
 			name: "Invalid input - additional property",
 			input: json.RawMessage(`{
@@ -305,9 +488,60 @@ func TestMergeExtraSpecs(t *testing.T) {
 						Scopes: []string{"scope"},
 					},
 				},
-				SourceSnapshot:  "projects/garm-testing/global/snapshots/garm-snapshot",
-				SSHKeys:         []string{"ssh-key1", "ssh-key2"},
-				EnableBootDebug: &enable_boot_debug,
+				SourceSnapshot:    "projects/garm-testing/global/snapshots/garm-snapshot",
+				SSHKeys:           []string{"user:ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIFakeKey1", "user:ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIFakeKey2"},
+				EnableBootDebug:   &enable_boot_debug,
+				ProvisioningModel: "SPOT",
+				MinCpuPlatform:    "Intel Cascade Lake",
+				CustomVCPUs:       4,
+				CustomMemoryMB:    8192,
+				NetworkInterfaces: []NetworkInterface{
+					{SubnetworkID: "primary"},
+					{SubnetworkID: "secondary", NicType: "GVNIC"},
+				},
+				AdditionalDisks: []AdditionalDisk{
+					{SizeGB: 100},
+				},
+				LocalSSDCount:               2,
+				BootDiskInterface:           "NVME",
+				BootDiskAutoDelete:          proto.Bool(false),
+				BootDiskName:                "garm-boot-disk",
+				BootDiskDeviceName:          "runner-root",
+				DiskEncryptionKey:           "projects/my-project/locations/europe-west1/keyRings/garm/cryptoKeys/runners",
+				ProvisionedIops:             5000,
+				ProvisionedThroughput:       250,
+				EnableConfidentialCompute:   true,
+				EnableSecureBoot:            true,
+				EnableVtpm:                  true,
+				EnableIntegrityMonitoring:   true,
+				OnHostMaintenance:           "TERMINATE",
+				AutomaticRestart:            proto.Bool(false),
+				DeletionProtection:          true,
+				ResourcePolicies:            []string{"compact", "daily-snapshots"},
+				SourceInstanceTemplate:      "global/instanceTemplates/garm-runner",
+				SourceMachineImage:          "global/machineImages/garm-runner",
+				NetworkIP:                   "10.10.0.5",
+				Description:                 "CI runner",
+				Hostname:                    "runner-1.ci.example.com",
+				ExternalIP:                  "203.0.113.10",
+				ExternalIPNetworkTier:       "STANDARD",
+				AccessConfigName:            "external-nat",
+				HostProjectID:               "my-host-project",
+				EnableIPv6:                  true,
+				CanIPForward:                true,
+				ThreadsPerCore:              1,
+				VisibleCoreCount:            2,
+				CustomMetadata:              map[string]string{"team": "ci"},
+				EnableOSLogin:               true,
+				BlockProjectSSHKeys:         true,
+				InstallOpsAgent:             true,
+				PreCreateCommands:           []string{"mkfs.ext4 -F /dev/nvme0n1", "mount /dev/nvme0n1 /home/runner"},
+				RawStartupScript:            []byte("#!/bin/bash\necho hello"),
+				WindowsStartupScriptKey:     "windows-startup-script-ps1",
+				WindowsExtraSysprepCmds:     []string{"googet -noconfirm=true install git"},
+				WindowsNetworkRetries:       60,
+				WindowsNetworkRetryInterval: 5,
+				WaitForGuestAttributes:      true,
 			},
 		},
 		{
@@ -369,185 +603,1874 @@ func TestMergeExtraSpecs(t *testing.T) {
 					}
 				}
 			}
+			if len(tt.extraSpecs.SSHKeys) > 0 {
+				assert.Equal(t, strings.Join(tt.extraSpecs.SSHKeys, "\n"), spec.SSHKeys)
+				assert.False(t, strings.HasPrefix(spec.SSHKeys, "\n"), "expected SSHKeys to not start with a newline")
+			}
 			if tt.extraSpecs.EnableBootDebug != nil {
 				if *tt.extraSpecs.EnableBootDebug != spec.EnableBootDebug {
 					assert.Equal(t, *tt.extraSpecs.EnableBootDebug, spec.EnableBootDebug, "expected EnableBootDebug to be %t, got %t", *tt.extraSpecs.EnableBootDebug, spec.EnableBootDebug)
 				}
 			}
+			if tt.extraSpecs.ProvisioningModel != "" {
+				assert.Equal(t, tt.extraSpecs.ProvisioningModel, spec.ProvisioningModel, "expected ProvisioningModel to be %s, got %s", tt.extraSpecs.ProvisioningModel, spec.ProvisioningModel)
+			}
+			if tt.extraSpecs.MinCpuPlatform != "" {
+				assert.Equal(t, tt.extraSpecs.MinCpuPlatform, spec.MinCpuPlatform, "expected MinCpuPlatform to be %s, got %s", tt.extraSpecs.MinCpuPlatform, spec.MinCpuPlatform)
+			}
+			if tt.extraSpecs.CustomVCPUs > 0 {
+				assert.Equal(t, "custom-4-8192", spec.BootstrapParams.Flavor, "expected Flavor to be %s, got %s", "custom-4-8192", spec.BootstrapParams.Flavor)
+			}
+			if len(tt.extraSpecs.NetworkInterfaces) > 0 {
+				assert.Len(t, spec.NetworkInterfaces, len(tt.extraSpecs.NetworkInterfaces))
+				// Interfaces without a NIC type inherit the NIC type of the spec.
+				assert.Equal(t, spec.NicType, spec.NetworkInterfaces[0].NicType)
+				assert.Equal(t, "GVNIC", spec.NetworkInterfaces[1].NicType)
+			}
+			if len(tt.extraSpecs.AdditionalDisks) > 0 {
+				assert.Equal(t, tt.extraSpecs.AdditionalDisks, spec.AdditionalDisks)
+			}
+			if tt.extraSpecs.LocalSSDCount > 0 {
+				assert.Equal(t, tt.extraSpecs.LocalSSDCount, spec.LocalSSDCount)
+			}
+			if tt.extraSpecs.BootDiskInterface != "" {
+				assert.Equal(t, tt.extraSpecs.BootDiskInterface, spec.BootDiskInterface)
+			}
+			if tt.extraSpecs.ProvisionedIops > 0 {
+				assert.Equal(t, tt.extraSpecs.ProvisionedIops, spec.ProvisionedIops)
+			}
+			if tt.extraSpecs.ProvisionedThroughput > 0 {
+				assert.Equal(t, tt.extraSpecs.ProvisionedThroughput, spec.ProvisionedThroughput)
+			}
+			assert.Equal(t, tt.extraSpecs.EnableConfidentialCompute, spec.EnableConfidentialCompute)
+			assert.Equal(t, tt.extraSpecs.EnableSecureBoot, spec.EnableSecureBoot)
+			assert.Equal(t, tt.extraSpecs.EnableVtpm, spec.EnableVtpm)
+			assert.Equal(t, tt.extraSpecs.EnableIntegrityMonitoring, spec.EnableIntegrityMonitoring)
+			if tt.extraSpecs.OnHostMaintenance != "" {
+				assert.Equal(t, tt.extraSpecs.OnHostMaintenance, spec.OnHostMaintenance)
+			}
+			assert.Equal(t, tt.extraSpecs.AutomaticRestart, spec.AutomaticRestart)
+			assert.Equal(t, tt.extraSpecs.BootDiskAutoDelete, spec.BootDiskAutoDelete)
+			assert.Equal(t, tt.extraSpecs.BootDiskName, spec.BootDiskName)
+			assert.Equal(t, tt.extraSpecs.BootDiskDeviceName, spec.BootDiskDeviceName)
+			assert.Equal(t, tt.extraSpecs.DiskEncryptionKey, spec.DiskEncryptionKey)
+			assert.Equal(t, tt.extraSpecs.DeletionProtection, spec.DeletionProtection)
+			if tt.extraSpecs.Description != "" {
+				assert.Equal(t, tt.extraSpecs.Description, spec.Description)
+			}
+			assert.Equal(t, tt.extraSpecs.Hostname, spec.Hostname)
+			assert.Equal(t, tt.extraSpecs.SourceInstanceTemplate, spec.SourceInstanceTemplate)
+			assert.Equal(t, tt.extraSpecs.SourceMachineImage, spec.SourceMachineImage)
+			if len(tt.extraSpecs.ResourcePolicies) > 0 {
+				assert.Equal(t, tt.extraSpecs.ResourcePolicies, spec.ResourcePolicies)
+			}
+			if tt.extraSpecs.NetworkIP != "" {
+				assert.Equal(t, tt.extraSpecs.NetworkIP, spec.NetworkIP)
+			}
+			assert.Equal(t, tt.extraSpecs.EnableExternalIP, spec.EnableExternalIP)
+			if tt.extraSpecs.ExternalIP != "" {
+				assert.Equal(t, tt.extraSpecs.ExternalIP, spec.ExternalIP)
+			}
+			assert.Equal(t, tt.extraSpecs.ExternalIPNetworkTier, spec.ExternalIPNetworkTier)
+			assert.Equal(t, tt.extraSpecs.AccessConfigName, spec.AccessConfigName)
+			assert.Equal(t, tt.extraSpecs.HostProjectID, spec.HostProjectID)
+			assert.Equal(t, tt.extraSpecs.EnableIPv6, spec.EnableIPv6)
+			assert.Equal(t, tt.extraSpecs.CanIPForward, spec.CanIPForward)
+			assert.Equal(t, tt.extraSpecs.ThreadsPerCore, spec.ThreadsPerCore)
+			assert.Equal(t, tt.extraSpecs.VisibleCoreCount, spec.VisibleCoreCount)
+			if len(tt.extraSpecs.CustomMetadata) > 0 {
+				assert.Equal(t, tt.extraSpecs.CustomMetadata, spec.CustomMetadata)
+			}
+			assert.Equal(t, tt.extraSpecs.EnableOSLogin, spec.EnableOSLogin)
+			assert.Equal(t, tt.extraSpecs.BlockProjectSSHKeys, spec.BlockProjectSSHKeys)
+			assert.Equal(t, tt.extraSpecs.InstallOpsAgent, spec.InstallOpsAgent)
+			assert.Equal(t, tt.extraSpecs.PreCreateCommands, spec.PreCreateCommands)
+			assert.Equal(t, tt.extraSpecs.RawStartupScript, spec.RawStartupScript)
+			assert.Equal(t, tt.extraSpecs.WindowsExtraSysprepCmds, spec.WindowsExtraSysprepCmds)
+			assert.Equal(t, tt.extraSpecs.WaitForGuestAttributes, spec.WaitForGuestAttributes)
+			assert.Equal(t, tt.extraSpecs.WindowsStartupScriptKey, spec.WindowsStartupScriptKey)
+			if tt.extraSpecs.WindowsNetworkRetries > 0 {
+				assert.Equal(t, tt.extraSpecs.WindowsNetworkRetries, spec.WindowsNetworkRetries)
+				assert.Equal(t, tt.extraSpecs.WindowsNetworkRetryInterval, spec.WindowsNetworkRetryInterval)
+			}
 
 		})
 	}
 }
 
-func TestRunnerSpec_Validate(t *testing.T) {
+func TestMergeExtraSpecsDiskLabels(t *testing.T) {
 	tests := []struct {
-		name      string
-		spec      *RunnerSpec
-		errString error
+		name       string
+		extraSpecs *extraSpecs
+		expected   map[string]string
 	}{
 		{
-			name: "ValidSpec",
-			spec: &RunnerSpec{
-				Zone:           "europe-west1-d",
-				NetworkID:      "projects/garm-testing/global/networks/garm-2",
-				SubnetworkID:   "projects/garm-testing/regions/europe-west1/subnetworks/garm",
-				ControllerID:   "my-controller",
-				NicType:        "VIRTIO_NET",
-				DiskSize:       50,
-				CustomLabels:   map[string]string{"key1": "value1"},
-				NetworkTags:    []string{"tag1", "tag2"},
-				SourceSnapshot: "projects/garm-testing/global/snapshots/garm-snapshot",
-			},
-			errString: nil,
+			name:       "NoLabels",
+			extraSpecs: &extraSpecs{},
+			expected:   nil,
 		},
 		{
-			name: "MissingZone",
-			spec: &RunnerSpec{
-				NetworkID:      "projects/garm-testing/global/networks/garm-2",
-				SubnetworkID:   "projects/garm-testing/regions/europe-west1/subnetworks/garm",
-				ControllerID:   "my-controller",
-				NicType:        "VIRTIO_NET",
-				DiskSize:       50,
-				CustomLabels:   map[string]string{"key1": "value1"},
-				NetworkTags:    []string{"tag1", "tag2"},
-				SourceSnapshot: "projects/garm-testing/global/snapshots/garm-snapshot",
+			name: "CustomLabels",
+			extraSpecs: &extraSpecs{
+				CustomLabels: map[string]string{"team": "ci"},
 			},
-			errString: fmt.Errorf("missing zone"),
+			expected: map[string]string{"team": "ci"},
 		},
 		{
-			name: "MissingNetworkID",
-			spec: &RunnerSpec{
-				Zone:           "europe-west1-d",
-				SubnetworkID:   "projects/garm-testing/regions/europe-west1/subnetworks/garm",
-				ControllerID:   "my-controller",
-				NicType:        "VIRTIO_NET",
-				DiskSize:       50,
-				CustomLabels:   map[string]string{"key1": "value1"},
-				NetworkTags:    []string{"tag1", "tag2"},
-				SourceSnapshot: "projects/garm-testing/global/snapshots/garm-snapshot",
+			name: "DiskLabelsOverride",
+			extraSpecs: &extraSpecs{
+				CustomLabels: map[string]string{"team": "ci"},
+				DiskLabels:   map[string]string{"backup": "none"},
 			},
-			errString: fmt.Errorf("missing network id"),
+			expected: map[string]string{"backup": "none"},
 		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := &RunnerSpec{
+				CustomLabels: map[string]string{
+					"garmpoolid":       "my-pool",
+					"garmcontrollerid": "my-controller",
+					"ostype":           "linux",
+				},
+			}
+			spec.MergeExtraSpecs(tt.extraSpecs)
+			assert.Equal(t, tt.expected, spec.DiskLabels)
+			assert.Equal(t, "my-pool", spec.CustomLabels["garmpoolid"])
+		})
+	}
+}
+
+func TestMergeExtraSpecsImage(t *testing.T) {
+	tests := []struct {
+		name       string
+		image      string
+		extraSpecs *extraSpecs
+		expected   string
+	}{
 		{
-			name: "MissingSubnetworkID",
-			spec: &RunnerSpec{
-				Zone:           "europe-west1-d",
-				NetworkID:      "projects/garm-testing/global/networks/garm-2",
-				ControllerID:   "my-controller",
-				NicType:        "VIRTIO_NET",
-				DiskSize:       50,
-				CustomLabels:   map[string]string{"key1": "value1"},
-				NetworkTags:    []string{"tag1", "tag2"},
-				SourceSnapshot: "projects/garm-testing/global/snapshots/garm-snapshot",
-			},
-			errString: fmt.Errorf("missing subnetwork id"),
+			name:       "Image name",
+			image:      "debian-cloud-init",
+			extraSpecs: &extraSpecs{ImageProject: "garm-testing"},
+			expected:   "projects/garm-testing/global/images/debian-cloud-init",
 		},
 		{
-			name: "MissingControllerID",
-			spec: &RunnerSpec{
-				Zone:           "europe-west1-d",
-				NetworkID:      "projects/garm-testing/global/networks/garm-2",
-				SubnetworkID:   "projects/garm-testing/regions/europe-west1/subnetworks/garm",
-				NicType:        "VIRTIO_NET",
-				DiskSize:       50,
-				CustomLabels:   map[string]string{"key1": "value1"},
-				NetworkTags:    []string{"tag1", "tag2"},
-				SourceSnapshot: "projects/garm-testing/global/snapshots/garm-snapshot",
-			},
-			errString: fmt.Errorf("missing controller id"),
+			name:       "Image family",
+			image:      "family/debian-12",
+			extraSpecs: &extraSpecs{ImageProject: "debian-cloud"},
+			expected:   "projects/debian-cloud/global/images/family/debian-12",
 		},
 		{
-			name: "MissingNicType",
-			spec: &RunnerSpec{
-				Zone:           "europe-west1-d",
-				NetworkID:      "projects/garm-testing/global/networks/garm-2",
-				SubnetworkID:   "projects/garm-testing/regions/europe-west1/subnetworks/garm",
-				ControllerID:   "my-controller",
-				DiskSize:       50,
-				CustomLabels:   map[string]string{"key1": "value1"},
-				NetworkTags:    []string{"tag1", "tag2"},
-				SourceSnapshot: "projects/garm-testing/global/snapshots/garm-snapshot",
-			},
-			errString: fmt.Errorf("missing nic type"),
+			name:       "Image path",
+			image:      "projects/windows-cloud/global/images/family/windows-2022",
+			extraSpecs: &extraSpecs{ImageProject: "debian-cloud"},
+			expected:   "projects/windows-cloud/global/images/family/windows-2022",
+		},
+		{
+			name:       "Image family with image project",
+			image:      "projects/garm-testing/global/images/garm-image",
+			extraSpecs: &extraSpecs{ImageProject: "debian-cloud", ImageFamily: "debian-12"},
+			expected:   "projects/debian-cloud/global/images/family/debian-12",
+		},
+		{
+			name:       "Image family without image project",
+			image:      "projects/garm-testing/global/images/garm-image",
+			extraSpecs: &extraSpecs{ImageFamily: "garm-runners"},
+			expected:   "global/images/family/garm-runners",
+		},
+		{
+			name:       "Image override",
+			image:      "projects/garm-testing/global/images/garm-image",
+			extraSpecs: &extraSpecs{Image: "projects/debian-cloud/global/images/debian-12-bookworm-v20240617"},
+			expected:   "projects/debian-cloud/global/images/debian-12-bookworm-v20240617",
+		},
+		{
+			name:       "Image override with image project",
+			image:      "projects/garm-testing/global/images/garm-image",
+			extraSpecs: &extraSpecs{Image: "debian-cloud-init", ImageProject: "garm-testing"},
+			expected:   "projects/garm-testing/global/images/debian-cloud-init",
+		},
+		{
+			name:       "No image project",
+			image:      "debian-cloud-init",
+			extraSpecs: &extraSpecs{},
+			expected:   "debian-cloud-init",
+		},
+		{
+			name:       "Source machine image",
+			image:      "projects/garm-testing/global/images/garm-image",
+			extraSpecs: &extraSpecs{SourceMachineImage: "projects/garm-testing/global/machineImages/garm-runner"},
+			expected:   "",
+		},
+		{
+			name:       "Source snapshot",
+			image:      "projects/garm-testing/global/images/garm-image",
+			extraSpecs: &extraSpecs{SourceSnapshot: "projects/garm-testing/global/snapshots/garm-snapshot"},
+			expected:   "",
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := tt.spec.Validate()
-			if tt.errString == nil {
-				require.Nil(t, err)
-			} else {
-				require.Equal(t, tt.errString, err)
+			spec := &RunnerSpec{
+				BootstrapParams: params.BootstrapInstance{
+					Image: tt.image,
+				},
 			}
-
+			spec.MergeExtraSpecs(tt.extraSpecs)
+			assert.Equal(t, tt.expected, spec.BootstrapParams.Image)
 		})
 	}
 }
 
-func TestExtraSpecsValidate(t *testing.T) {
+func TestMergeExtraSpecsServiceAccountShorthand(t *testing.T) {
 	tests := []struct {
-		name    string
-		specs   *extraSpecs
-		wantErr bool
-		errMsg  string
+		name       string
+		extraSpecs *extraSpecs
+		expected   []*computepb.ServiceAccount
 	}{
 		{
-			name: "Valid inputs",
-			specs: &extraSpecs{
-				CustomLabels: map[string]string{"key1": "value1", "key2": "value2"},
-				NetworkTags:  []string{"tag1", "tag2"},
+			name: "EmailWithDefaultScopes",
+			extraSpecs: &extraSpecs{
+				ServiceAccountEmail: "runner@my-project.iam.gserviceaccount.com",
 			},
-			wantErr: false,
-		},
-		{
-			name: "Too many custom labels",
-			specs: &extraSpecs{
-				CustomLabels: make(map[string]string),
-				NetworkTags:  []string{"tag1", "tag2"},
+			expected: []*computepb.ServiceAccount{
+				{
+					Email:  proto.String("runner@my-project.iam.gserviceaccount.com"),
+					Scopes: DefaultServiceAccountScopes,
+				},
 			},
-			wantErr: true,
-			errMsg:  "custom labels cannot exceed 61 items",
 		},
 		{
-			name: "Invalid custom label key",
-			specs: &extraSpecs{
-				CustomLabels: map[string]string{"!invalidKey": "value1"},
-				NetworkTags:  []string{"tag1", "tag2"},
+			name: "EmailWithScopes",
+			extraSpecs: &extraSpecs{
+				ServiceAccountEmail:  "runner@my-project.iam.gserviceaccount.com",
+				ServiceAccountScopes: []string{"https://www.googleapis.com/auth/cloud-platform"},
 			},
-			wantErr: true,
-			errMsg:  "custom label key '!invalidKey' does not match requirements",
-		},
-		{
-			name: "Invalid custom label value",
-			specs: &extraSpecs{
-				CustomLabels: map[string]string{"key1": "!invalidValue"},
-				NetworkTags:  []string{"tag1", "tag2"},
+			expected: []*computepb.ServiceAccount{
+				{
+					Email:  proto.String("runner@my-project.iam.gserviceaccount.com"),
+					Scopes: []string{"https://www.googleapis.com/auth/cloud-platform"},
+				},
 			},
-			wantErr: true,
-			errMsg:  "custom label value '!invalidValue' does not match requirements",
 		},
 		{
-			name: "Too many network tags",
-			specs: &extraSpecs{
-				CustomLabels: map[string]string{"key1": "value1"},
-				NetworkTags:  make([]string, 65),
-			},
-			wantErr: true,
+			name: "ServiceAccountsWin",
+			extraSpecs: &extraSpecs{
+				ServiceAccountEmail: "runner@my-project.iam.gserviceaccount.com",
+				ServiceAccounts: []*computepb.ServiceAccount{
+					{
+						Email:  proto.String("other@my-project.iam.gserviceaccount.com"),
+						Scopes: []string{"scope"},
+					},
+				},
+			},
+			expected: []*computepb.ServiceAccount{
+				{
+					Email:  proto.String("other@my-project.iam.gserviceaccount.com"),
+					Scopes: []string{"scope"},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := &RunnerSpec{}
+			spec.MergeExtraSpecs(tt.extraSpecs)
+			require.Len(t, spec.ServiceAccounts, len(tt.expected))
+			for idx, sa := range tt.expected {
+				assert.Equal(t, sa.GetEmail(), spec.ServiceAccounts[idx].GetEmail())
+				assert.Equal(t, sa.GetScopes(), spec.ServiceAccounts[idx].GetScopes())
+			}
+		})
+	}
+}
+
+func TestComposeUserDataOpsAgent(t *testing.T) {
+	DefaultCloudConfigFunc = func(bootstrapParams params.BootstrapInstance, tools params.RunnerApplicationDownload, runnerName string) (string, error) {
+		cloudCfg := cloudconfig.NewDefaultCloudInitConfig()
+		cloudCfg.AddRunCmd("su -l -c /install_runner.sh runner")
+		return cloudCfg.Serialize()
+	}
+	spec := &RunnerSpec{
+		BootstrapParams: params.BootstrapInstance{
+			Name:   "garm-instance",
+			OSType: params.Linux,
+		},
+	}
+
+	udata, err := spec.ComposeUserData()
+	require.NoError(t, err)
+	assert.NotContains(t, udata, "add-google-cloud-ops-agent-repo.sh")
+
+	spec.InstallOpsAgent = true
+	udata, err = spec.ComposeUserData()
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(udata, "#cloud-config\n"))
+
+	cloudCfg := &cloudconfig.CloudInit{}
+	require.NoError(t, yaml.Unmarshal([]byte(udata), cloudCfg))
+	require.Len(t, cloudCfg.RunCmd, len(opsAgentInstallCmds)+1)
+	assert.Equal(t, opsAgentInstallCmds, cloudCfg.RunCmd[:len(opsAgentInstallCmds)])
+	assert.Equal(t, "su -l -c /install_runner.sh runner", cloudCfg.RunCmd[len(opsAgentInstallCmds)])
+	assert.Equal(t, []string{"curl", "tar"}, cloudCfg.Packages)
+}
+
+func TestComposeUserDataUnsupportedOS(t *testing.T) {
+	for _, osType := range []params.OSType{"freebsd", ""} {
+		t.Run(string(osType), func(t *testing.T) {
+			spec := &RunnerSpec{
+				BootstrapParams: params.BootstrapInstance{
+					Name:   "garm-instance",
+					OSType: osType,
+				},
+			}
+			_, err := spec.ComposeUserData()
+			assert.EqualError(t, err, fmt.Sprintf("unsupported OS type %q for user data: only linux and windows are supported", osType))
+		})
+	}
+}
+
+func TestComposeUserDataPreCreateCommands(t *testing.T) {
+	DefaultCloudConfigFunc = func(bootstrapParams params.BootstrapInstance, tools params.RunnerApplicationDownload, runnerName string) (string, error) {
+		cloudCfg := cloudconfig.NewDefaultCloudInitConfig()
+		cloudCfg.AddRunCmd("su -l -c /install_runner.sh runner")
+		return cloudCfg.Serialize()
+	}
+	spec := &RunnerSpec{
+		BootstrapParams: params.BootstrapInstance{
+			Name:   "garm-instance",
+			OSType: params.Linux,
+		},
+		InstallOpsAgent:   true,
+		PreCreateCommands: []string{"mkfs.ext4 -F /dev/nvme0n1", "mount /dev/nvme0n1 /home/runner"},
+	}
+
+	udata, err := spec.ComposeUserData()
+	require.NoError(t, err)
+
+	cloudCfg := &cloudconfig.CloudInit{}
+	require.NoError(t, yaml.Unmarshal([]byte(udata), cloudCfg))
+	require.Len(t, cloudCfg.RunCmd, len(spec.PreCreateCommands)+len(opsAgentInstallCmds)+1)
+	assert.Equal(t, spec.PreCreateCommands, cloudCfg.RunCmd[:2])
+	assert.Equal(t, opsAgentInstallCmds, cloudCfg.RunCmd[2:len(cloudCfg.RunCmd)-1])
+	assert.Equal(t, "su -l -c /install_runner.sh runner", cloudCfg.RunCmd[len(cloudCfg.RunCmd)-1])
+}
+
+func TestComposeUserDataRawStartupScript(t *testing.T) {
+	DefaultCloudConfigFunc = func(bootstrapParams params.BootstrapInstance, tools params.RunnerApplicationDownload, runnerName string) (string, error) {
+		return "#cloud-config\n", nil
+	}
+	DefaultRunnerInstallScriptFunc = func(bootstrapParams params.BootstrapInstance, tools params.RunnerApplicationDownload, runnerName string) ([]byte, error) {
+		return []byte("#ps1_sysnative\n"), nil
+	}
+
+	tests := []struct {
+		name   string
+		osType params.OSType
+		script string
+	}{
+		{
+			name:   "Linux",
+			osType: params.Linux,
+			script: "#!/bin/bash\necho hello\n",
+		},
+		{
+			name:   "Windows",
+			osType: params.Windows,
+			script: "#ps1_sysnative\nWrite-Output hello\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := &RunnerSpec{
+				BootstrapParams: params.BootstrapInstance{
+					Name:   "garm-instance",
+					OSType: tt.osType,
+				},
+				InstallOpsAgent:       true,
+				WindowsNetworkRetries: 5,
+				RawStartupScript:      []byte(tt.script),
+			}
+			udata, err := spec.ComposeUserData()
+			require.NoError(t, err)
+			assert.Equal(t, tt.script, udata)
+		})
+	}
+}
+
+func TestComposeUserDataWindowsNetworkWait(t *testing.T) {
+	DefaultRunnerInstallScriptFunc = func(bootstrapParams params.BootstrapInstance, tools params.RunnerApplicationDownload, runnerName string) ([]byte, error) {
+		return []byte("#ps1_sysnative\nParam(\n\t[string]$Token=\"token\"\n)\n\n$ErrorActionPreference=\"Stop\"\n"), nil
+	}
+	spec := &RunnerSpec{
+		BootstrapParams: params.BootstrapInstance{
+			Name:        "garm-instance",
+			OSType:      params.Windows,
+			CallbackURL: "https://garm.example.com:9997/api/v1/callbacks",
+		},
+	}
+
+	udata, err := spec.ComposeUserData()
+	require.NoError(t, err)
+	assert.NotContains(t, udata, "Test-GarmConnection")
+
+	spec.WindowsNetworkRetries = 5
+	spec.WindowsNetworkRetryInterval = 3
+	udata, err = spec.ComposeUserData()
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(udata, "#ps1_sysnative\nParam(\n\t[string]$Token=\"token\"\n)\nfunction Test-GarmConnection {"))
+	assert.Contains(t, udata, "$garmNetworkRetry -le 5;")
+	assert.Contains(t, udata, `Test-GarmConnection -ComputerName "garm.example.com" -Port 9997`)
+	assert.Contains(t, udata, "Start-Sleep -Seconds 3")
+	assert.True(t, strings.HasSuffix(udata, "\n$ErrorActionPreference=\"Stop\"\n"))
+}
+
+func TestComposeUserDataWindowsGuestAttributesWait(t *testing.T) {
+	DefaultRunnerInstallScriptFunc = func(bootstrapParams params.BootstrapInstance, tools params.RunnerApplicationDownload, runnerName string) ([]byte, error) {
+		return []byte("#ps1_sysnative\nParam(\n\t[string]$Token=\"token\"\n)\n\n$ErrorActionPreference=\"Stop\"\n"), nil
+	}
+	spec := &RunnerSpec{
+		BootstrapParams: params.BootstrapInstance{
+			Name:        "garm-instance",
+			OSType:      params.Windows,
+			CallbackURL: "https://garm.example.com:9997/api/v1/callbacks",
+		},
+	}
+
+	udata, err := spec.ComposeUserData()
+	require.NoError(t, err)
+	assert.NotContains(t, udata, guestAttributesReadyURL)
+
+	spec.WaitForGuestAttributes = true
+	udata, err = spec.ComposeUserData()
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(udata, "#ps1_sysnative\nParam(\n\t[string]$Token=\"token\"\n)\nfor ($garmGuestAttributesRetry = 1; $garmGuestAttributesRetry -le 30;"))
+	assert.Contains(t, udata, fmt.Sprintf(`Invoke-RestMethod -Method Put -Uri "%s"`, guestAttributesReadyURL))
+	assert.Contains(t, udata, "Start-Sleep -Seconds 10")
+	assert.True(t, strings.HasSuffix(udata, "\n$ErrorActionPreference=\"Stop\"\n"))
+
+	// The guest attributes wait runs before the network checks.
+	spec.WindowsNetworkRetries = 5
+	udata, err = spec.ComposeUserData()
+	require.NoError(t, err)
+	assert.Less(t, strings.Index(udata, guestAttributesReadyURL), strings.Index(udata, "function Test-GarmConnection"))
+}
+
+func TestInsertWindowsNetworkWait(t *testing.T) {
+	tests := []struct {
+		name     string
+		udata    string
+		expected string
+	}{
+		{
+			name:     "ParamBlock",
+			udata:    "#ps1_sysnative\nParam(\n\t$Token\n)\nInstall\n",
+			expected: "#ps1_sysnative\nParam(\n\t$Token\n)\nWAIT\nInstall\n",
+		},
+		{
+			name:     "NoParamBlock",
+			udata:    "#ps1_sysnative\nInstall\n",
+			expected: "#ps1_sysnative\nWAIT\nInstall\n",
+		},
+		{
+			name:     "ParamBlockWithoutMarker",
+			udata:    "Param(\n\t$Token\n)\nInstall\n",
+			expected: "Param(\n\t$Token\n)\nWAIT\nInstall\n",
+		},
+		{
+			name:     "PlainScript",
+			udata:    "Install\n",
+			expected: "WAIT\nInstall\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, insertWindowsNetworkWait(tt.udata, "WAIT\n"))
+		})
+	}
+}
+
+func TestGetRunnerSpecFromBootstrapParamsLabels(t *testing.T) {
+	DefaultToolFetch = func(osType params.OSType, osArch params.OSArch, tools []params.RunnerApplicationDownload) (params.RunnerApplicationDownload, error) {
+		return params.RunnerApplicationDownload{}, nil
+	}
+	cfg := &config.Config{
+		Zone:         "europe-west1-d",
+		ProjectId:    "my-project",
+		NetworkID:    "my-network",
+		SubnetworkID: "my-subnetwork",
+	}
+	data := params.BootstrapInstance{
+		Name:       "garm-instance",
+		PoolID:     "my-pool",
+		OSType:     params.Linux,
+		OSArch:     params.Arm64,
+		Flavor:     "t2a-standard-1",
+		Image:      "projects/debian-cloud/global/images/debian-12-bookworm-arm64-v20240110",
+		ExtraSpecs: json.RawMessage(`{}`),
+	}
+
+	spec, err := GetRunnerSpecFromBootstrapParams(cfg, data, "my-controller")
+	require.NoError(t, err)
+	createdAt, err := strconv.ParseInt(spec.CustomLabels["garmcreatedat"], 10, 64)
+	require.NoError(t, err)
+	assert.Positive(t, createdAt)
+	delete(spec.CustomLabels, "garmcreatedat")
+	assert.Equal(t, map[string]string{
+		"garmpoolid":       "my-pool",
+		"garmcontrollerid": "my-controller",
+		"ostype":           "linux",
+		"garmosarch":       "arm64",
+	}, spec.CustomLabels)
+}
+
+func TestGetRunnerSpecFromBootstrapParamsDefaultLabels(t *testing.T) {
+	DefaultToolFetch = func(osType params.OSType, osArch params.OSArch, tools []params.RunnerApplicationDownload) (params.RunnerApplicationDownload, error) {
+		return params.RunnerApplicationDownload{}, nil
+	}
+	cfg := &config.Config{
+		Zone:         "europe-west1-d",
+		ProjectId:    "my-project",
+		NetworkID:    "my-network",
+		SubnetworkID: "my-subnetwork",
+		DefaultLabels: map[string]string{
+			"team":       "platform",
+			"env":        "prod",
+			"garmpoolid": "not-my-pool",
+		},
+	}
+	data := params.BootstrapInstance{
+		Name:       "garm-instance",
+		PoolID:     "my-pool",
+		OSType:     params.Linux,
+		OSArch:     params.Amd64,
+		Flavor:     "n1-standard-1",
+		Image:      "projects/garm-testing/global/images/garm-image",
+		ExtraSpecs: json.RawMessage(`{"custom_labels": {"team": "ci"}}`),
+	}
+
+	spec, err := GetRunnerSpecFromBootstrapParams(cfg, data, "my-controller")
+	require.NoError(t, err)
+	assert.Equal(t, "ci", spec.CustomLabels["team"])
+	assert.Equal(t, "prod", spec.CustomLabels["env"])
+	assert.Equal(t, "my-pool", spec.CustomLabels["garmpoolid"])
+	assert.Equal(t, map[string]string{
+		"team": "ci",
+		"env":  "prod",
+	}, spec.DiskLabels)
+	assert.Equal(t, "platform", cfg.DefaultLabels["team"])
+}
+
+func TestGetRunnerSpecFromBootstrapParamsTooManyLabels(t *testing.T) {
+	DefaultToolFetch = func(osType params.OSType, osArch params.OSArch, tools []params.RunnerApplicationDownload) (params.RunnerApplicationDownload, error) {
+		return params.RunnerApplicationDownload{}, nil
+	}
+	defaultLabels := make(map[string]string)
+	for i := 0; i < 55; i++ {
+		defaultLabels[fmt.Sprintf("default%d", i)] = "value"
+	}
+	cfg := &config.Config{
+		Zone:          "europe-west1-d",
+		ProjectId:     "my-project",
+		NetworkID:     "my-network",
+		SubnetworkID:  "my-subnetwork",
+		DefaultLabels: defaultLabels,
+	}
+	data := params.BootstrapInstance{
+		Name:       "garm-instance",
+		PoolID:     "my-pool",
+		OSType:     params.Linux,
+		OSArch:     params.Amd64,
+		Flavor:     "n1-standard-1",
+		Image:      "projects/garm-testing/global/images/garm-image",
+		ExtraSpecs: json.RawMessage(`{"custom_labels": {"key1": "a", "key2": "b", "key3": "c", "key4": "d", "key5": "e"}}`),
+	}
+
+	_, err := GetRunnerSpecFromBootstrapParams(cfg, data, "my-controller")
+	require.EqualError(t, err, "failed to validate runner spec: instance labels cannot exceed 64 items, including the internal and default labels")
+
+	// Custom labels that replace default labels do not add to the count.
+	data.ExtraSpecs = json.RawMessage(`{"custom_labels": {"default1": "a", "default2": "b", "key3": "c", "key4": "d"}}`)
+	_, err = GetRunnerSpecFromBootstrapParams(cfg, data, "my-controller")
+	require.NoError(t, err)
+}
+
+func TestGetRunnerSpecFromBootstrapParamsCreatedAt(t *testing.T) {
+	DefaultToolFetch = func(osType params.OSType, osArch params.OSArch, tools []params.RunnerApplicationDownload) (params.RunnerApplicationDownload, error) {
+		return params.RunnerApplicationDownload{}, nil
+	}
+	defer func() { timeNow = time.Now }()
+	timeNow = func() time.Time {
+		return time.Date(2024, time.July, 1, 12, 30, 0, 0, time.UTC)
+	}
+	cfg := &config.Config{
+		Zone:         "europe-west1-d",
+		ProjectId:    "my-project",
+		NetworkID:    "my-network",
+		SubnetworkID: "my-subnetwork",
+	}
+	data := params.BootstrapInstance{
+		Name:       "garm-instance",
+		PoolID:     "my-pool",
+		OSType:     params.Linux,
+		OSArch:     params.Amd64,
+		Flavor:     "n1-standard-1",
+		Image:      "projects/garm-testing/global/images/garm-image",
+		ExtraSpecs: json.RawMessage(`{}`),
+	}
+
+	spec, err := GetRunnerSpecFromBootstrapParams(cfg, data, "my-controller")
+	require.NoError(t, err)
+	assert.Equal(t, "1719837000", spec.CustomLabels["garmcreatedat"])
+	assert.Regexp(t, customLabelValueRegex, spec.CustomLabels["garmcreatedat"])
+}
+
+func TestGetRunnerSpecFromBootstrapParamsDescription(t *testing.T) {
+	DefaultToolFetch = func(osType params.OSType, osArch params.OSArch, tools []params.RunnerApplicationDownload) (params.RunnerApplicationDownload, error) {
+		return params.RunnerApplicationDownload{}, nil
+	}
+	cfg := &config.Config{
+		Zone:         "europe-west1-d",
+		ProjectId:    "my-project",
+		NetworkID:    "my-network",
+		SubnetworkID: "my-subnetwork",
+	}
+	data := params.BootstrapInstance{
+		Name:       "garm-instance",
+		PoolID:     "my-pool",
+		OSType:     params.Linux,
+		OSArch:     params.Amd64,
+		Flavor:     "n1-standard-1",
+		Image:      "projects/garm-testing/global/images/garm-image",
+		ExtraSpecs: json.RawMessage(`{}`),
+	}
+
+	spec, err := GetRunnerSpecFromBootstrapParams(cfg, data, "my-controller")
+	require.NoError(t, err)
+	assert.Equal(t, "garm runner my-pool", spec.Description)
+
+	data.ExtraSpecs = json.RawMessage(`{"description": "CI runner"}`)
+	spec, err = GetRunnerSpecFromBootstrapParams(cfg, data, "my-controller")
+	require.NoError(t, err)
+	assert.Equal(t, "CI runner", spec.Description)
+}
+
+func TestGetRunnerSpecFromBootstrapParamsAccessConfigName(t *testing.T) {
+	DefaultToolFetch = func(osType params.OSType, osArch params.OSArch, tools []params.RunnerApplicationDownload) (params.RunnerApplicationDownload, error) {
+		return params.RunnerApplicationDownload{}, nil
+	}
+	cfg := &config.Config{
+		Zone:         "europe-west1-d",
+		ProjectId:    "my-project",
+		NetworkID:    "my-network",
+		SubnetworkID: "my-subnetwork",
+	}
+	data := params.BootstrapInstance{
+		Name:       "garm-instance",
+		PoolID:     "my-pool",
+		OSType:     params.Linux,
+		OSArch:     params.Amd64,
+		Flavor:     "n1-standard-1",
+		Image:      "projects/garm-testing/global/images/garm-image",
+		ExtraSpecs: json.RawMessage(`{}`),
+	}
+
+	spec, err := GetRunnerSpecFromBootstrapParams(cfg, data, "my-controller")
+	require.NoError(t, err)
+	assert.Equal(t, "External NAT", spec.AccessConfigName)
+
+	data.ExtraSpecs = json.RawMessage(`{"access_config_name": "external-nat"}`)
+	spec, err = GetRunnerSpecFromBootstrapParams(cfg, data, "my-controller")
+	require.NoError(t, err)
+	assert.Equal(t, "external-nat", spec.AccessConfigName)
+}
+
+func TestGetRunnerSpecFromBootstrapParamsDiskType(t *testing.T) {
+	DefaultToolFetch = func(osType params.OSType, osArch params.OSArch, tools []params.RunnerApplicationDownload) (params.RunnerApplicationDownload, error) {
+		return params.RunnerApplicationDownload{}, nil
+	}
+	cfg := &config.Config{
+		Zone:         "europe-west1-d",
+		ProjectId:    "my-project",
+		NetworkID:    "my-network",
+		SubnetworkID: "my-subnetwork",
+		DiskType:     "pd-balanced",
+	}
+	tests := []struct {
+		name       string
+		extraSpecs json.RawMessage
+		expected   string
+	}{
+		{
+			name:       "ConfigDefault",
+			extraSpecs: json.RawMessage(`{}`),
+			expected:   "pd-balanced",
+		},
+		{
+			name:       "ExtraSpecsOverride",
+			extraSpecs: json.RawMessage(`{"disktype": "pd-ssd"}`),
+			expected:   "pd-ssd",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := params.BootstrapInstance{
+				Name:       "garm-instance",
+				OSType:     params.Linux,
+				PoolID:     "my-pool",
+				Image:      "projects/garm-testing/global/images/garm-image",
+				ExtraSpecs: tt.extraSpecs,
+			}
+			spec, err := GetRunnerSpecFromBootstrapParams(cfg, data, "my-controller")
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, spec.DiskType)
+		})
+	}
+}
+
+func TestGetRunnerSpecFromBootstrapParamsNetworkTier(t *testing.T) {
+	DefaultToolFetch = func(osType params.OSType, osArch params.OSArch, tools []params.RunnerApplicationDownload) (params.RunnerApplicationDownload, error) {
+		return params.RunnerApplicationDownload{}, nil
+	}
+	tests := []struct {
+		name        string
+		networkTier string
+		extraSpecs  json.RawMessage
+		expected    string
+	}{
+		{
+			name:       "GCPDefault",
+			extraSpecs: json.RawMessage(`{}`),
+			expected:   "",
+		},
+		{
+			name:        "ConfigDefault",
+			networkTier: "STANDARD",
+			extraSpecs:  json.RawMessage(`{}`),
+			expected:    "STANDARD",
+		},
+		{
+			name:        "ExtraSpecsOverride",
+			networkTier: "STANDARD",
+			extraSpecs:  json.RawMessage(`{"external_ip_network_tier": "PREMIUM"}`),
+			expected:    "PREMIUM",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{
+				Zone:         "europe-west1-d",
+				ProjectId:    "my-project",
+				NetworkID:    "my-network",
+				SubnetworkID: "my-subnetwork",
+				NetworkTier:  tt.networkTier,
+			}
+			data := params.BootstrapInstance{
+				Name:       "garm-instance",
+				OSType:     params.Linux,
+				PoolID:     "my-pool",
+				Image:      "projects/garm-testing/global/images/garm-image",
+				ExtraSpecs: tt.extraSpecs,
+			}
+			spec, err := GetRunnerSpecFromBootstrapParams(cfg, data, "my-controller")
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, spec.ExternalIPNetworkTier)
+		})
+	}
+}
+
+func TestRunnerSpec_Validate(t *testing.T) {
+	tests := []struct {
+		name      string
+		spec      *RunnerSpec
+		errString error
+	}{
+		{
+			name: "ValidSpec",
+			spec: &RunnerSpec{
+				Zone:           "europe-west1-d",
+				NetworkID:      "projects/garm-testing/global/networks/garm-2",
+				SubnetworkID:   "projects/garm-testing/regions/europe-west1/subnetworks/garm",
+				ControllerID:   "my-controller",
+				NicType:        "VIRTIO_NET",
+				DiskSize:       50,
+				CustomLabels:   map[string]string{"key1": "value1"},
+				NetworkTags:    []string{"tag1", "tag2"},
+				SourceSnapshot: "projects/garm-testing/global/snapshots/garm-snapshot",
+			},
+			errString: nil,
+		},
+		{
+			name: "MissingZone",
+			spec: &RunnerSpec{
+				NetworkID:      "projects/garm-testing/global/networks/garm-2",
+				SubnetworkID:   "projects/garm-testing/regions/europe-west1/subnetworks/garm",
+				ControllerID:   "my-controller",
+				NicType:        "VIRTIO_NET",
+				DiskSize:       50,
+				CustomLabels:   map[string]string{"key1": "value1"},
+				NetworkTags:    []string{"tag1", "tag2"},
+				SourceSnapshot: "projects/garm-testing/global/snapshots/garm-snapshot",
+			},
+			errString: fmt.Errorf("missing zone"),
+		},
+		{
+			name: "MissingNetworkID",
+			spec: &RunnerSpec{
+				Zone:           "europe-west1-d",
+				SubnetworkID:   "projects/garm-testing/regions/europe-west1/subnetworks/garm",
+				ControllerID:   "my-controller",
+				NicType:        "VIRTIO_NET",
+				DiskSize:       50,
+				CustomLabels:   map[string]string{"key1": "value1"},
+				NetworkTags:    []string{"tag1", "tag2"},
+				SourceSnapshot: "projects/garm-testing/global/snapshots/garm-snapshot",
+			},
+			errString: fmt.Errorf("missing network id"),
+		},
+		{
+			name: "MissingSubnetworkID",
+			spec: &RunnerSpec{
+				Zone:           "europe-west1-d",
+				NetworkID:      "projects/garm-testing/global/networks/garm-2",
+				ControllerID:   "my-controller",
+				NicType:        "VIRTIO_NET",
+				DiskSize:       50,
+				CustomLabels:   map[string]string{"key1": "value1"},
+				NetworkTags:    []string{"tag1", "tag2"},
+				SourceSnapshot: "projects/garm-testing/global/snapshots/garm-snapshot",
+			},
+			errString: fmt.Errorf("missing subnetwork id"),
+		},
+		{
+			name: "MissingControllerID",
+			spec: &RunnerSpec{
+				Zone:           "europe-west1-d",
+				NetworkID:      "projects/garm-testing/global/networks/garm-2",
+				SubnetworkID:   "projects/garm-testing/regions/europe-west1/subnetworks/garm",
+				NicType:        "VIRTIO_NET",
+				DiskSize:       50,
+				CustomLabels:   map[string]string{"key1": "value1"},
+				NetworkTags:    []string{"tag1", "tag2"},
+				SourceSnapshot: "projects/garm-testing/global/snapshots/garm-snapshot",
+			},
+			errString: fmt.Errorf("missing controller id"),
+		},
+		{
+			name: "MissingNicType",
+			spec: &RunnerSpec{
+				Zone:           "europe-west1-d",
+				NetworkID:      "projects/garm-testing/global/networks/garm-2",
+				SubnetworkID:   "projects/garm-testing/regions/europe-west1/subnetworks/garm",
+				ControllerID:   "my-controller",
+				DiskSize:       50,
+				CustomLabels:   map[string]string{"key1": "value1"},
+				NetworkTags:    []string{"tag1", "tag2"},
+				SourceSnapshot: "projects/garm-testing/global/snapshots/garm-snapshot",
+			},
+			errString: fmt.Errorf("missing nic type"),
+		},
+		{
+			name: "ConfidentialComputeSupportedFamily",
+			spec: &RunnerSpec{
+				Zone:                      "europe-west1-d",
+				NetworkID:                 "projects/garm-testing/global/networks/garm-2",
+				SubnetworkID:              "projects/garm-testing/regions/europe-west1/subnetworks/garm",
+				ControllerID:              "my-controller",
+				NicType:                   "VIRTIO_NET",
+				BootstrapParams:           params.BootstrapInstance{Flavor: "n2d-standard-2", Image: "projects/garm-testing/global/images/garm-image"},
+				EnableConfidentialCompute: true,
+			},
+			errString: nil,
+		},
+		{
+			name: "ConfidentialComputeUnsupportedFamily",
+			spec: &RunnerSpec{
+				Zone:                      "europe-west1-d",
+				NetworkID:                 "projects/garm-testing/global/networks/garm-2",
+				SubnetworkID:              "projects/garm-testing/regions/europe-west1/subnetworks/garm",
+				ControllerID:              "my-controller",
+				NicType:                   "VIRTIO_NET",
+				BootstrapParams:           params.BootstrapInstance{Flavor: "n1-standard-1", Image: "projects/garm-testing/global/images/garm-image"},
+				EnableConfidentialCompute: true,
+			},
+			errString: fmt.Errorf("confidential compute is not supported by machine type n1-standard-1"),
+		},
+		{
+			name: "ProvisionedIopsWithHyperdisk",
+			spec: &RunnerSpec{
+				Zone:            "europe-west1-d",
+				NetworkID:       "projects/garm-testing/global/networks/garm-2",
+				SubnetworkID:    "projects/garm-testing/regions/europe-west1/subnetworks/garm",
+				ControllerID:    "my-controller",
+				NicType:         "VIRTIO_NET",
+				DiskType:        "hyperdisk-balanced",
+				ProvisionedIops: 5000,
+				SourceSnapshot:  "projects/garm-testing/global/snapshots/garm-snapshot",
+			},
+			errString: nil,
+		},
+		{
+			name: "ProvisionedIopsWithoutHyperdisk",
+			spec: &RunnerSpec{
+				Zone:            "europe-west1-d",
+				NetworkID:       "projects/garm-testing/global/networks/garm-2",
+				SubnetworkID:    "projects/garm-testing/regions/europe-west1/subnetworks/garm",
+				ControllerID:    "my-controller",
+				NicType:         "VIRTIO_NET",
+				DiskType:        "pd-ssd",
+				ProvisionedIops: 5000,
+				SourceSnapshot:  "projects/garm-testing/global/snapshots/garm-snapshot",
+			},
+			errString: fmt.Errorf("provisioned iops and throughput are only supported by hyperdisk disk types"),
+		},
+		{
+			name: "ProvisionedThroughputWithoutDiskType",
+			spec: &RunnerSpec{
+				Zone:                  "europe-west1-d",
+				NetworkID:             "projects/garm-testing/global/networks/garm-2",
+				SubnetworkID:          "projects/garm-testing/regions/europe-west1/subnetworks/garm",
+				ControllerID:          "my-controller",
+				NicType:               "VIRTIO_NET",
+				ProvisionedThroughput: 250,
+				SourceSnapshot:        "projects/garm-testing/global/snapshots/garm-snapshot",
+			},
+			errString: fmt.Errorf("provisioned iops and throughput are only supported by hyperdisk disk types"),
+		},
+		{
+			name: "OpsAgentWithScopes",
+			spec: &RunnerSpec{
+				Zone:            "europe-west1-d",
+				NetworkID:       "projects/garm-testing/global/networks/garm-2",
+				SubnetworkID:    "projects/garm-testing/regions/europe-west1/subnetworks/garm",
+				ControllerID:    "my-controller",
+				NicType:         "VIRTIO_NET",
+				BootstrapParams: params.BootstrapInstance{OSType: params.Linux, Image: "projects/garm-testing/global/images/garm-image"},
+				ServiceAccounts: []*computepb.ServiceAccount{
+					{
+						Email:  proto.String("runner@my-project.iam.gserviceaccount.com"),
+						Scopes: DefaultServiceAccountScopes,
+					},
+				},
+				InstallOpsAgent: true,
+			},
+			errString: nil,
+		},
+		{
+			name: "OpsAgentWithoutServiceAccount",
+			spec: &RunnerSpec{
+				Zone:            "europe-west1-d",
+				NetworkID:       "projects/garm-testing/global/networks/garm-2",
+				SubnetworkID:    "projects/garm-testing/regions/europe-west1/subnetworks/garm",
+				ControllerID:    "my-controller",
+				NicType:         "VIRTIO_NET",
+				BootstrapParams: params.BootstrapInstance{OSType: params.Linux, Image: "projects/garm-testing/global/images/garm-image"},
+				InstallOpsAgent: true,
+			},
+			errString: fmt.Errorf("install_ops_agent requires a service account with the logging.write and monitoring.write scopes"),
+		},
+		{
+			name: "OpsAgentWithoutMonitoringScope",
+			spec: &RunnerSpec{
+				Zone:            "europe-west1-d",
+				NetworkID:       "projects/garm-testing/global/networks/garm-2",
+				SubnetworkID:    "projects/garm-testing/regions/europe-west1/subnetworks/garm",
+				ControllerID:    "my-controller",
+				NicType:         "VIRTIO_NET",
+				BootstrapParams: params.BootstrapInstance{OSType: params.Linux, Image: "projects/garm-testing/global/images/garm-image"},
+				ServiceAccounts: []*computepb.ServiceAccount{
+					{
+						Email:  proto.String("runner@my-project.iam.gserviceaccount.com"),
+						Scopes: []string{"https://www.googleapis.com/auth/logging.write"},
+					},
+				},
+				InstallOpsAgent: true,
+			},
+			errString: fmt.Errorf("install_ops_agent requires a service account with the logging.write and monitoring.write scopes"),
+		}, {
+			name: "ImageOnly",
+			spec: &RunnerSpec{
+				Zone:            "europe-west1-d",
+				NetworkID:       "projects/garm-testing/global/networks/garm-2",
+				SubnetworkID:    "projects/garm-testing/regions/europe-west1/subnetworks/garm",
+				ControllerID:    "my-controller",
+				NicType:         "VIRTIO_NET",
+				BootstrapParams: params.BootstrapInstance{Image: "projects/garm-testing/global/images/garm-image"},
+			},
+			errString: nil,
+		},
+		{
+			name: "ImageAndSourceSnapshot",
+			spec: &RunnerSpec{
+				Zone:            "europe-west1-d",
+				NetworkID:       "projects/garm-testing/global/networks/garm-2",
+				SubnetworkID:    "projects/garm-testing/regions/europe-west1/subnetworks/garm",
+				ControllerID:    "my-controller",
+				NicType:         "VIRTIO_NET",
+				BootstrapParams: params.BootstrapInstance{Image: "projects/garm-testing/global/images/garm-image"},
+				SourceSnapshot:  "projects/garm-testing/global/snapshots/garm-snapshot",
+			},
+			errString: fmt.Errorf("only one of image or source snapshot can be set for the boot disk"),
+		},
+		{
+			name: "MissingBootDiskSource",
+			spec: &RunnerSpec{
+				Zone:         "europe-west1-d",
+				NetworkID:    "projects/garm-testing/global/networks/garm-2",
+				SubnetworkID: "projects/garm-testing/regions/europe-west1/subnetworks/garm",
+				ControllerID: "my-controller",
+				NicType:      "VIRTIO_NET",
+			},
+			errString: fmt.Errorf("missing boot disk source: an image or a source snapshot is required"),
+		},
+		{
+			name: "SourceMachineImageWithoutBootDiskSource",
+			spec: &RunnerSpec{
+				Zone:               "europe-west1-d",
+				NetworkID:          "projects/garm-testing/global/networks/garm-2",
+				SubnetworkID:       "projects/garm-testing/regions/europe-west1/subnetworks/garm",
+				ControllerID:       "my-controller",
+				NicType:            "VIRTIO_NET",
+				SourceMachineImage: "global/machineImages/garm-runner",
+			},
+			errString: nil,
+		},
+		{
+			name: "SourceInstanceTemplateWithoutBootDiskSource",
+			spec: &RunnerSpec{
+				Zone:                   "europe-west1-d",
+				NetworkID:              "projects/garm-testing/global/networks/garm-2",
+				SubnetworkID:           "projects/garm-testing/regions/europe-west1/subnetworks/garm",
+				ControllerID:           "my-controller",
+				NicType:                "VIRTIO_NET",
+				SourceInstanceTemplate: "global/instanceTemplates/garm-runner",
+			},
+			errString: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.spec.Validate()
+			if tt.errString == nil {
+				require.Nil(t, err)
+			} else {
+				require.Equal(t, tt.errString, err)
+			}
+
+		})
+	}
+}
+
+func TestExtraSpecsValidateReservedLabels(t *testing.T) {
+	for _, key := range []string{"garmpoolid", "garmcontrollerid", "ostype", "garmosarch", "garmcreatedat"} {
+		t.Run(key, func(t *testing.T) {
+			specs := &extraSpecs{
+				CustomLabels: map[string]string{"key1": "value1", key: "value"},
+			}
+			err := specs.Validate()
+			assert.EqualError(t, err, fmt.Sprintf("custom label key '%s' is reserved by the provider", key))
+		})
+	}
+}
+
+func TestExtraSpecsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		specs   *extraSpecs
+		wantErr bool
+		errMsg  string
+	}{
+		{
+			name: "Valid inputs",
+			specs: &extraSpecs{
+				CustomLabels: map[string]string{"key1": "value1", "key2": "value2"},
+				NetworkTags:  []string{"tag1", "tag2"},
+			},
+			wantErr: false,
+		},
+		{
+			name: "Too many custom labels",
+			specs: &extraSpecs{
+				CustomLabels: make(map[string]string),
+				NetworkTags:  []string{"tag1", "tag2"},
+			},
+			wantErr: true,
+			errMsg:  "custom labels cannot exceed 59 items",
+		},
+		{
+			name: "Invalid custom label key",
+			specs: &extraSpecs{
+				CustomLabels: map[string]string{"!invalidKey": "value1"},
+				NetworkTags:  []string{"tag1", "tag2"},
+			},
+			wantErr: true,
+			errMsg:  "custom label key '!invalidKey' does not match requirements",
+		},
+		{
+			name: "Reserved custom label key",
+			specs: &extraSpecs{
+				CustomLabels: map[string]string{"garmpoolid": "my-pool"},
+			},
+			wantErr: true,
+			errMsg:  "custom label key 'garmpoolid' is reserved by the provider",
+		},
+		{
+			name: "Invalid custom label value",
+			specs: &extraSpecs{
+				CustomLabels: map[string]string{"key1": "!invalidValue"},
+				NetworkTags:  []string{"tag1", "tag2"},
+			},
+			wantErr: true,
+			errMsg:  "custom label value '!invalidValue' does not match requirements",
+		},
+		{
+			name: "Too many network tags",
+			specs: &extraSpecs{
+				CustomLabels: map[string]string{"key1": "value1"},
+				NetworkTags:  make([]string, 65),
+			},
+			wantErr: true,
 			errMsg:  "network tags cannot exceed 64 items",
 		},
 		{
-			name: "Invalid network tag",
+			name: "Invalid network tag",
+			specs: &extraSpecs{
+				CustomLabels: map[string]string{"key1": "value1"},
+				NetworkTags:  []string{"!invalidTag"},
+			},
+			wantErr: true,
+			errMsg:  "network tag '!invalidTag' does not match requirements",
+		},
+		{
+			name: "Valid custom machine type",
+			specs: &extraSpecs{
+				CustomVCPUs:    2,
+				CustomMemoryMB: 4096,
+			},
+			wantErr: false,
+		},
+		{
+			name: "Custom vcpus without memory",
+			specs: &extraSpecs{
+				CustomVCPUs: 2,
+			},
+			wantErr: true,
+			errMsg:  "custom_vcpus and custom_memory_mb must be set together",
+		},
+		{
+			name: "Custom memory not a multiple of 256",
+			specs: &extraSpecs{
+				CustomVCPUs:    2,
+				CustomMemoryMB: 4000,
+			},
+			wantErr: true,
+			errMsg:  "custom_memory_mb must be a multiple of 256",
+		},
+		{
+			name: "Valid network interfaces",
+			specs: &extraSpecs{
+				NetworkInterfaces: []NetworkInterface{
+					{SubnetworkID: "primary"},
+					{SubnetworkID: "secondary"},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "Empty network interfaces",
+			specs: &extraSpecs{
+				NetworkInterfaces: []NetworkInterface{},
+			},
+			wantErr: true,
+			errMsg:  "network interfaces must contain at least one interface",
+		},
+		{
+			name: "Network interface without subnetwork",
+			specs: &extraSpecs{
+				NetworkInterfaces: []NetworkInterface{
+					{NetworkID: "primary"},
+				},
+			},
+			wantErr: true,
+			errMsg:  "network interface 0 is missing the subnetwork id",
+		},
+		{
+			name: "Too many network interfaces",
+			specs: &extraSpecs{
+				NetworkInterfaces: make([]NetworkInterface, 9),
+			},
+			wantErr: true,
+			errMsg:  "network interfaces cannot exceed 8 items",
+		},
+		{
+			name: "Valid additional disks",
+			specs: &extraSpecs{
+				AdditionalDisks: []AdditionalDisk{
+					{SizeGB: 100},
+					{SizeGB: 200, SourceImage: "projects/garm-testing/global/images/data"},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "Valid disk size",
+			specs: &extraSpecs{
+				DiskSize: 10,
+			},
+			wantErr: false,
+		},
+		{
+			name: "Zero disk size uses the default",
+			specs: &extraSpecs{
+				DiskSize: 0,
+			},
+			wantErr: false,
+		},
+		{
+			name: "Disk size too small",
+			specs: &extraSpecs{
+				DiskSize: 9,
+			},
+			wantErr: true,
+			errMsg:  "disk size must be between 10 and 65536 GB",
+		},
+		{
+			name: "Negative disk size",
+			specs: &extraSpecs{
+				DiskSize: -10,
+			},
+			wantErr: true,
+			errMsg:  "disk size must be between 10 and 65536 GB",
+		},
+		{
+			name: "Disk size too large",
+			specs: &extraSpecs{
+				DiskSize: 65537,
+			},
+			wantErr: true,
+			errMsg:  "disk size must be between 10 and 65536 GB",
+		},
+		{
+			name: "Additional disk with zero size",
+			specs: &extraSpecs{
+				AdditionalDisks: []AdditionalDisk{
+					{SizeGB: 0},
+				},
+			},
+			wantErr: true,
+			errMsg:  "additional disk 0 size must be between 1 and 65536 GB",
+		},
+		{
+			name: "Additional disk too large",
+			specs: &extraSpecs{
+				AdditionalDisks: []AdditionalDisk{
+					{SizeGB: 65537},
+				},
+			},
+			wantErr: true,
+			errMsg:  "additional disk 0 size must be between 1 and 65536 GB",
+		},
+		{
+			name: "Additional disk with image and snapshot",
+			specs: &extraSpecs{
+				AdditionalDisks: []AdditionalDisk{
+					{SizeGB: 100, SourceImage: "image", SourceSnapshot: "snapshot"},
+				},
+			},
+			wantErr: true,
+			errMsg:  "additional disk 0 cannot have both a source image and a source snapshot",
+		},
+		{
+			name: "Too many local SSDs",
+			specs: &extraSpecs{
+				LocalSSDCount: 25,
+			},
+			wantErr: true,
+			errMsg:  "local SSD count must be between 0 and 24",
+		},
+		{
+			name: "Valid disk encryption key",
+			specs: &extraSpecs{
+				DiskEncryptionKey: "projects/my-project/locations/global/keyRings/garm/cryptoKeys/runners/cryptoKeyVersions/3",
+			},
+			wantErr: false,
+		},
+		{
+			name: "Invalid disk encryption key",
+			specs: &extraSpecs{
+				DiskEncryptionKey: "my-key",
+			},
+			wantErr: true,
+			errMsg:  "disk encryption key 'my-key' is not a valid Cloud KMS key resource path",
+		},
+		{
+			name: "Valid image project",
+			specs: &extraSpecs{
+				ImageProject: "debian-cloud",
+			},
+			wantErr: false,
+		},
+		{
+			name: "Invalid image project",
+			specs: &extraSpecs{
+				ImageProject: "projects/debian-cloud",
+			},
+			wantErr: true,
+			errMsg:  "image project 'projects/debian-cloud' is not a valid project ID",
+		},
+		{
+			name: "Image and image family",
+			specs: &extraSpecs{
+				Image:       "projects/debian-cloud/global/images/debian-12-bookworm-v20240617",
+				ImageFamily: "debian-12",
+			},
+			wantErr: true,
+			errMsg:  "image and image_family cannot be set at the same time",
+		},
+		{
+			name: "Source snapshot and image",
+			specs: &extraSpecs{
+				Image:          "projects/debian-cloud/global/images/debian-12-bookworm-v20240617",
+				SourceSnapshot: "projects/garm-testing/global/snapshots/garm-snapshot",
+			},
+			wantErr: true,
+			errMsg:  "source_snapshot cannot be set together with image, image_family or image_project",
+		},
+		{
+			name: "Valid image family",
+			specs: &extraSpecs{
+				ImageFamily: "debian-12",
+			},
+			wantErr: false,
+		},
+		{
+			name: "Invalid image family",
+			specs: &extraSpecs{
+				ImageFamily: "family/debian-12",
+			},
+			wantErr: true,
+			errMsg:  "image family 'family/debian-12' does not match requirements",
+		},
+		{
+			name: "Valid host project id",
+			specs: &extraSpecs{
+				HostProjectID: "my-host-project",
+			},
+			wantErr: false,
+		},
+		{
+			name: "Invalid host project id",
+			specs: &extraSpecs{
+				HostProjectID: "projects/my-host-project",
+			},
+			wantErr: true,
+			errMsg:  "host project id 'projects/my-host-project' is not a valid project ID",
+		},
+		{
+			name: "Valid nic type",
+			specs: &extraSpecs{
+				NicType: "GVNIC",
+			},
+			wantErr: false,
+		},
+		{
+			name: "Invalid nic type",
+			specs: &extraSpecs{
+				NicType: "E1000",
+			},
+			wantErr: true,
+			errMsg:  "nic type must be one of VIRTIO_NET, GVNIC, IDPF",
+		},
+		{
+			name: "Invalid network interface nic type",
+			specs: &extraSpecs{
+				NetworkInterfaces: []NetworkInterface{
+					{SubnetworkID: "primary", NicType: "IDPF"},
+					{SubnetworkID: "secondary", NicType: "virtio"},
+				},
+			},
+			wantErr: true,
+			errMsg:  "network interface 1 nic type must be one of VIRTIO_NET, GVNIC, IDPF",
+		},
+		{
+			name: "Valid boot disk interface",
+			specs: &extraSpecs{
+				BootDiskInterface: "SCSI",
+			},
+			wantErr: false,
+		},
+		{
+			name: "Invalid boot disk interface",
+			specs: &extraSpecs{
+				BootDiskInterface: "IDE",
+			},
+			wantErr: true,
+			errMsg:  "boot disk interface must be one of SCSI or NVME",
+		},
+		{
+			name: "Valid boot disk names",
+			specs: &extraSpecs{
+				BootDiskName:       "garm-boot-disk",
+				BootDiskDeviceName: "runner-root",
+			},
+			wantErr: false,
+		},
+		{
+			name: "Invalid boot disk name",
+			specs: &extraSpecs{
+				BootDiskName: "Boot_Disk",
+			},
+			wantErr: true,
+			errMsg:  "boot disk name 'Boot_Disk' does not match requirements",
+		},
+		{
+			name: "Invalid boot disk device name",
+			specs: &extraSpecs{
+				BootDiskDeviceName: "1-root-",
+			},
+			wantErr: true,
+			errMsg:  "boot disk device name '1-root-' does not match requirements",
+		},
+		{
+			name: "Provisioned performance with hyperdisk",
+			specs: &extraSpecs{
+				DiskType:              "hyperdisk-balanced",
+				ProvisionedIops:       5000,
+				ProvisionedThroughput: 250,
+			},
+			wantErr: false,
+		},
+		{
+			name: "Negative provisioned iops",
+			specs: &extraSpecs{
+				DiskType:        "hyperdisk-balanced",
+				ProvisionedIops: -1,
+			},
+			wantErr: true,
+			errMsg:  "provisioned iops and throughput cannot be negative",
+		},
+		{
+			name: "Valid on host maintenance",
+			specs: &extraSpecs{
+				OnHostMaintenance: "MIGRATE",
+				AutomaticRestart:  proto.Bool(true),
+			},
+			wantErr: false,
+		},
+		{
+			name: "Valid resource manager tags",
 			specs: &extraSpecs{
-				CustomLabels: map[string]string{"key1": "value1"},
-				NetworkTags:  []string{"!invalidTag"},
+				ResourceManagerTags: map[string]string{
+					"tagKeys/123456": "tagValues/654321",
+					"my-project/env": "prod",
+					"123456789/team": "ci-runners",
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "Invalid resource manager tag key",
+			specs: &extraSpecs{
+				ResourceManagerTags: map[string]string{"env": "prod"},
 			},
 			wantErr: true,
-			errMsg:  "network tag '!invalidTag' does not match requirements",
+			errMsg:  "resource manager tag key 'env' does not match requirements",
+		},
+		{
+			name: "Invalid resource manager tag value",
+			specs: &extraSpecs{
+				ResourceManagerTags: map[string]string{"tagKeys/123456": "tagValues/abc"},
+			},
+			wantErr: true,
+			errMsg:  "resource manager tag value 'tagValues/abc' does not match requirements",
+		},
+		{
+			name: "Invalid disk label key",
+			specs: &extraSpecs{
+				DiskLabels: map[string]string{"Invalid": "value"},
+			},
+			wantErr: true,
+			errMsg:  "disk label key 'Invalid' does not match requirements",
+		},
+		{
+			name: "Negative windows network retries",
+			specs: &extraSpecs{
+				WindowsNetworkRetries: -1,
+			},
+			wantErr: true,
+			errMsg:  "windows network retries and retry interval cannot be negative",
+		},
+		{
+			name: "Invalid windows startup script key",
+			specs: &extraSpecs{
+				WindowsStartupScriptKey: "windows-startup-script-cmd",
+			},
+			wantErr: true,
+			errMsg:  "windows startup script key must be one of sysprep-specialize-script-ps1 or windows-startup-script-ps1",
+		},
+		{
+			name: "Invalid on host maintenance",
+			specs: &extraSpecs{
+				OnHostMaintenance: "RESTART",
+			},
+			wantErr: true,
+			errMsg:  "on host maintenance must be one of MIGRATE or TERMINATE",
+		},
+		{
+			name: "Live migration with spot",
+			specs: &extraSpecs{
+				ProvisioningModel: "SPOT",
+				OnHostMaintenance: "MIGRATE",
+			},
+			wantErr: true,
+			errMsg:  "spot and confidential instances cannot be live migrated",
+		},
+		{
+			name: "Automatic restart with spot",
+			specs: &extraSpecs{
+				ProvisioningModel: "SPOT",
+				AutomaticRestart:  proto.Bool(true),
+			},
+			wantErr: true,
+			errMsg:  "spot instances cannot be restarted automatically",
+		},
+		{
+			name: "Valid network ip",
+			specs: &extraSpecs{
+				NetworkIP: "10.10.0.5",
+			},
+			wantErr: false,
+		},
+		{
+			name: "Empty resource policy",
+			specs: &extraSpecs{
+				ResourcePolicies: []string{"compact", " "},
+			},
+			wantErr: true,
+			errMsg:  "resource policy 1 cannot be empty",
+		},
+		{
+			name: "Valid source instance template",
+			specs: &extraSpecs{
+				SourceInstanceTemplate: "projects/my-project/regions/europe-west1/instanceTemplates/garm-runner",
+			},
+			wantErr: false,
+		},
+		{
+			name: "Valid source instance template URL",
+			specs: &extraSpecs{
+				SourceInstanceTemplate: "https://www.googleapis.com/compute/v1/projects/my-project/global/instanceTemplates/garm-runner",
+			},
+			wantErr: false,
+		},
+		{
+			name: "Invalid source instance template",
+			specs: &extraSpecs{
+				SourceInstanceTemplate: "projects/my-project/global/images/garm-runner",
+			},
+			wantErr: true,
+			errMsg:  "source instance template 'projects/my-project/global/images/garm-runner' is not a valid instance template URL",
+		},
+		{
+			name: "Valid source machine image",
+			specs: &extraSpecs{
+				SourceMachineImage: "https://www.googleapis.com/compute/v1/projects/my-project/global/machineImages/garm-runner",
+			},
+			wantErr: false,
+		},
+		{
+			name: "Invalid source machine image",
+			specs: &extraSpecs{
+				SourceMachineImage: "projects/my-project/global/images/garm-runner",
+			},
+			wantErr: true,
+			errMsg:  "source machine image 'projects/my-project/global/images/garm-runner' is not a valid machine image URL",
+		},
+		{
+			name: "Source machine image and source snapshot",
+			specs: &extraSpecs{
+				SourceMachineImage: "projects/my-project/global/machineImages/garm-runner",
+				SourceSnapshot:     "projects/garm-testing/global/snapshots/garm-snapshot",
+			},
+			wantErr: true,
+			errMsg:  "source_machine_image cannot be set together with source_snapshot, source_instance_template, image, image_family or image_project",
+		},
+		{
+			name: "Valid description",
+			specs: &extraSpecs{
+				Description: "CI runner",
+			},
+			wantErr: false,
+		},
+		{
+			name: "Description too long",
+			specs: &extraSpecs{
+				Description: strings.Repeat("a", 2049),
+			},
+			wantErr: true,
+			errMsg:  "description cannot be longer than 2048 characters",
+		},
+		{
+			name: "Valid hostname",
+			specs: &extraSpecs{
+				Hostname: "runner-1.ci.example.com",
+			},
+			wantErr: false,
+		},
+		{
+			name: "Hostname without domain",
+			specs: &extraSpecs{
+				Hostname: "runner-1",
+			},
+			wantErr: true,
+			errMsg:  "hostname 'runner-1' is not a fully qualified domain name",
+		},
+		{
+			name: "Hostname with invalid characters",
+			specs: &extraSpecs{
+				Hostname: "Runner_1.example.com",
+			},
+			wantErr: true,
+			errMsg:  "hostname 'Runner_1.example.com' is not a valid fully qualified domain name",
+		},
+		{
+			name: "Hostname with empty label",
+			specs: &extraSpecs{
+				Hostname: "runner-1..example.com",
+			},
+			wantErr: true,
+			errMsg:  "hostname 'runner-1..example.com' is not a valid fully qualified domain name",
+		},
+		{
+			name: "Hostname too long",
+			specs: &extraSpecs{
+				Hostname: strings.Repeat("a.", 127) + "com",
+			},
+			wantErr: true,
+			errMsg:  "hostname cannot exceed 253 characters",
+		},
+		{
+			name: "Valid threads per core",
+			specs: &extraSpecs{
+				ThreadsPerCore:   1,
+				VisibleCoreCount: 4,
+			},
+			wantErr: false,
+		},
+		{
+			name: "Invalid threads per core",
+			specs: &extraSpecs{
+				ThreadsPerCore: 4,
+			},
+			wantErr: true,
+			errMsg:  "threads_per_core must be 1 or 2",
+		},
+		{
+			name: "Negative visible core count",
+			specs: &extraSpecs{
+				VisibleCoreCount: -1,
+			},
+			wantErr: true,
+			errMsg:  "visible_core_count cannot be negative",
+		},
+		{
+			name: "Invalid network ip",
+			specs: &extraSpecs{
+				NetworkIP: "10.10.0.256",
+			},
+			wantErr: true,
+			errMsg:  "network ip '10.10.0.256' is not a valid IPv4 address",
+		},
+		{
+			name: "IPv6 network ip",
+			specs: &extraSpecs{
+				NetworkIP: "fd20::5",
+			},
+			wantErr: true,
+			errMsg:  "network ip 'fd20::5' is not a valid IPv4 address",
+		},
+		{
+			name: "Valid external ip",
+			specs: &extraSpecs{
+				ExternalIP: "203.0.113.10",
+			},
+			wantErr: false,
+		},
+		{
+			name: "Invalid external ip",
+			specs: &extraSpecs{
+				ExternalIP: "my-address",
+			},
+			wantErr: true,
+			errMsg:  "external ip 'my-address' is not a valid IPv4 address",
+		},
+		{
+			name: "Valid external ip network tier",
+			specs: &extraSpecs{
+				ExternalIPNetworkTier: "STANDARD",
+			},
+			wantErr: false,
+		},
+		{
+			name: "Invalid external ip network tier",
+			specs: &extraSpecs{
+				ExternalIPNetworkTier: "BASIC",
+			},
+			wantErr: true,
+			errMsg:  "external ip network tier must be one of PREMIUM or STANDARD",
+		},
+		{
+			name: "Valid host project id",
+			specs: &extraSpecs{
+				HostProjectID: "my-host-project",
+			},
+			wantErr: false,
+		},
+		{
+			name: "Invalid host project id",
+			specs: &extraSpecs{
+				HostProjectID: "projects/my-host-project",
+			},
+			wantErr: true,
+			errMsg:  "host project id 'projects/my-host-project' is not a valid project ID",
+		},
+		{
+			name: "Empty pre create command",
+			specs: &extraSpecs{
+				PreCreateCommands: []string{"echo hello", " "},
+			},
+			wantErr: true,
+			errMsg:  "pre create command 1 cannot be empty",
+		},
+		{
+			name: "Valid raw startup script",
+			specs: &extraSpecs{
+				RawStartupScript: []byte("#!/bin/bash\necho hello"),
+			},
+			wantErr: false,
+		},
+		{
+			name: "Invalid UTF-8 raw startup script",
+			specs: &extraSpecs{
+				RawStartupScript: []byte{0xff, 0xfe, 0xfd},
+			},
+			wantErr: true,
+			errMsg:  "raw startup script must be valid UTF-8",
+		},
+		{
+			name: "Empty windows extra sysprep command",
+			specs: &extraSpecs{
+				WindowsExtraSysprepCmds: []string{""},
+			},
+			wantErr: true,
+			errMsg:  "windows extra sysprep command 0 cannot be empty",
+		},
+		{
+			name: "Valid custom metadata",
+			specs: &extraSpecs{
+				CustomMetadata: map[string]string{"enable-oslogin": "TRUE", "app_config": "debug"},
+			},
+			wantErr: false,
+		},
+		{
+			name: "Invalid custom metadata key",
+			specs: &extraSpecs{
+				CustomMetadata: map[string]string{"app config": "debug"},
+			},
+			wantErr: true,
+			errMsg:  "custom metadata key 'app config' does not match requirements",
+		},
+		{
+			name: "Custom metadata key too long",
+			specs: &extraSpecs{
+				CustomMetadata: map[string]string{strings.Repeat("a", 129): "debug"},
+			},
+			wantErr: true,
+			errMsg:  fmt.Sprintf("custom metadata key '%s' does not match requirements", strings.Repeat("a", 129)),
+		},
+		{
+			name: "Custom metadata value too long",
+			specs: &extraSpecs{
+				CustomMetadata: map[string]string{"app-config": strings.Repeat("a", 256*1024+1)},
+			},
+			wantErr: true,
+			errMsg:  "custom metadata value for key 'app-config' exceeds 262144 bytes",
+		},
+		{
+			name: "Single service account",
+			specs: &extraSpecs{
+				ServiceAccounts: []*computepb.ServiceAccount{
+					{Email: proto.String("runner@my-project.iam.gserviceaccount.com")},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "Multiple service accounts",
+			specs: &extraSpecs{
+				ServiceAccounts: []*computepb.ServiceAccount{
+					{Email: proto.String("runner@my-project.iam.gserviceaccount.com")},
+					{Email: proto.String("other@my-project.iam.gserviceaccount.com")},
+				},
+			},
+			wantErr: true,
+			errMsg:  "only one service account can be attached to an instance, got 2",
+		},
+		{
+			name: "Service account scopes without email",
+			specs: &extraSpecs{
+				ServiceAccountScopes: []string{"https://www.googleapis.com/auth/cloud-platform"},
+			},
+			wantErr: true,
+			errMsg:  "service_account_scopes requires service_account_email to be set",
+		},
+		{
+			name: "Valid ssh keys",
+			specs: &extraSpecs{
+				SSHKeys: []string{
+					"user:ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIFakeKey",
+					"user.name:ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABAQ== user@host",
+					"user:ecdsa-sha2-nistp256 AAAAE2VjZHNhLXNoYTItbmlzdHAyNTY= comment with spaces",
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "SSH key without username",
+			specs: &extraSpecs{
+				SSHKeys: []string{"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIFakeKey"},
+			},
+			wantErr: true,
+			errMsg:  "ssh key 0 does not match the USERNAME:KEY_TYPE KEY [COMMENT] format",
+		},
+		{
+			name: "SSH key without key type",
+			specs: &extraSpecs{
+				SSHKeys: []string{"user:AAAAC3NzaC1lZDI1NTE5AAAAIFakeKey"},
+			},
+			wantErr: true,
+			errMsg:  "ssh key 0 does not match the USERNAME:KEY_TYPE KEY [COMMENT] format",
+		},
+		{
+			name: "SSH key with invalid key data",
+			specs: &extraSpecs{
+				SSHKeys: []string{"user:ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIFakeKey", "user:ssh-rsa not-base64!"},
+			},
+			wantErr: true,
+			errMsg:  "ssh key 1 does not match the USERNAME:KEY_TYPE KEY [COMMENT] format",
 		},
 	}
 
-	// Generate 62 keys for the "Too many custom labels" test
+	// Generate 62 keys for the "Too many custom labels" test. Together with
+	// the internal labels they exceed the GCP limit of 64 labels.
 	for i := 0; i < 62; i++ {
 		tests[1].specs.CustomLabels[fmt.Sprintf("key%d", i)] = fmt.Sprintf("value%d", i)
 	}
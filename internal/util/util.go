@@ -17,6 +17,7 @@ package util
 
 import (
 	"fmt"
+	"regexp"
 	"strings"
 
 	"cloud.google.com/go/compute/apiv1/computepb"
@@ -24,13 +25,101 @@ import (
 )
 
 func GetMachineType(zone, flavor string) string {
+	if _, name, ok := strings.Cut(flavor, "/machineTypes/"); ok {
+		// The flavor is a machine type URL. Only keep the name, so the
+		// machine type follows the instance when it fails over to
+		// another zone.
+		flavor = name
+	}
 	machine := fmt.Sprintf("zones/%s/machineTypes/%s", zone, flavor)
 	return machine
 }
 
+// GetCustomMachineType returns the name of a custom machine type with the
+// given number of vCPUs and amount of memory in MB.
+func GetCustomMachineType(vcpus, memoryMB int64) string {
+	return fmt.Sprintf("custom-%d-%d", vcpus, memoryMB)
+}
+
+// GetMachineFamily returns the machine family of a machine type, for example
+// "n2d" for "n2d-standard-2". The machine type may also be a machine type URL.
+func GetMachineFamily(machineType string) string {
+	name := machineType[strings.LastIndex(machineType, "/")+1:]
+	family, _, _ := strings.Cut(name, "-")
+	return family
+}
+
+var zoneRegex = regexp.MustCompile(`^([a-z]+-[a-z]+[0-9]+)-[a-z]$`)
+
+// RegionFromZone returns the region of a zone, for example "europe-west1" for
+// "europe-west1-d".
+func RegionFromZone(zone string) (string, error) {
+	matches := zoneRegex.FindStringSubmatch(zone)
+	if matches == nil {
+		return "", fmt.Errorf("invalid zone %q", zone)
+	}
+	return matches[1], nil
+}
+
+// BuildNetworkURL returns the resource URL of a network in the given project.
+// Networks that are already given as a resource path are returned unchanged.
+func BuildNetworkURL(projectID, network string) string {
+	if strings.Contains(network, "/") {
+		return network
+	}
+	return fmt.Sprintf("projects/%s/global/networks/%s", projectID, network)
+}
+
+// BuildSubnetworkURL returns the resource URL of a subnetwork in the given
+// project and region. Subnetworks that are already given as a resource path
+// are returned unchanged.
+func BuildSubnetworkURL(projectID, region, subnet string) string {
+	if strings.Contains(subnet, "/") {
+		return subnet
+	}
+	return fmt.Sprintf("projects/%s/regions/%s/subnetworks/%s", projectID, region, subnet)
+}
+
+// BuildImageURL returns the resource URL of an image in the given project.
+// Image families are given as family/<family>. Images that are already given
+// as a resource path are returned unchanged.
+func BuildImageURL(projectID, image string) string {
+	if family, ok := strings.CutPrefix(image, "family/"); ok && !strings.Contains(family, "/") {
+		return fmt.Sprintf("projects/%s/global/images/family/%s", projectID, family)
+	}
+	if strings.Contains(image, "/") {
+		return image
+	}
+	return fmt.Sprintf("projects/%s/global/images/%s", projectID, image)
+}
+
+const (
+	// OSArchLabel is the instance label holding the OS architecture of the runner.
+	OSArchLabel string = "garmosarch"
+	// maxInstanceNameLength is the maximum length of a GCP instance name.
+	maxInstanceNameLength int = 63
+	// instanceNamePrefix is added to names that do not start with a letter.
+	instanceNamePrefix string = "garm-"
+)
+
+var invalidInstanceNameChars = regexp.MustCompile("[^a-z0-9-]")
+
+// GetInstanceName converts a garm runner name to a valid GCP instance name.
+// GCP requires names to match [a-z]([-a-z0-9]*[a-z0-9])? and to be at most
+// 63 characters long. The conversion is deterministic, so the same runner name
+// always maps to the same instance name.
 func GetInstanceName(name string) string {
-	lowerName := strings.ToLower(name)
-	return lowerName
+	if name == "" {
+		return ""
+	}
+	instanceName := invalidInstanceNameChars.ReplaceAllString(strings.ToLower(name), "-")
+	if instanceName[0] < 'a' || instanceName[0] > 'z' {
+		instanceName = instanceNamePrefix + instanceName
+	}
+	if len(instanceName) > maxInstanceNameLength {
+		instanceName = instanceName[:maxInstanceNameLength]
+	}
+	return strings.TrimRight(instanceName, "-")
 }
 
 func getNameForInstance(instance *computepb.Instance) (string, error) {
@@ -54,6 +143,56 @@ func getNameForInstance(instance *computepb.Instance) (string, error) {
 	return name, nil
 }
 
+// getArchForInstance returns the OS architecture of an instance. The garmosarch
+// label set at creation time is preferred. Older instances fall back to the
+// architecture of the boot disk, which GCP does not always set, and finally
+// to amd64.
+func getArchForInstance(instance *computepb.Instance) params.OSArch {
+	if arch, ok := instance.GetLabels()[OSArchLabel]; ok && arch != "" {
+		return normalizeArch(arch)
+	}
+	disks := instance.GetDisks()
+	if len(disks) > 0 && disks[0].GetArchitecture() != "" {
+		return normalizeArch(disks[0].GetArchitecture())
+	}
+	return params.Amd64
+}
+
+// normalizeArch maps the GCP architecture names to the ones used by garm.
+func normalizeArch(arch string) params.OSArch {
+	switch strings.ToLower(arch) {
+	case "x86_64", "amd64":
+		return params.Amd64
+	case "arm64", "aarch64":
+		return params.Arm64
+	default:
+		return params.OSArch(strings.ToLower(arch))
+	}
+}
+
+// getAddressesForInstance returns the internal IP of each network interface of
+// an instance, each followed by the external (NAT) IPs of that interface.
+func getAddressesForInstance(instance *computepb.Instance) []params.Address {
+	var addresses []params.Address
+	for _, nic := range instance.GetNetworkInterfaces() {
+		if nic.GetNetworkIP() != "" {
+			addresses = append(addresses, params.Address{
+				Address: nic.GetNetworkIP(),
+				Type:    params.PrivateAddress,
+			})
+		}
+		for _, accessConfig := range nic.GetAccessConfigs() {
+			if accessConfig.GetNatIP() != "" {
+				addresses = append(addresses, params.Address{
+					Address: accessConfig.GetNatIP(),
+					Type:    params.PublicAddress,
+				})
+			}
+		}
+	}
+	return addresses
+}
+
 func GcpInstanceToParamsInstance(gcpInstance *computepb.Instance) (params.ProviderInstance, error) {
 	if gcpInstance == nil {
 		return params.ProviderInstance{}, fmt.Errorf("instance ID is nil")
@@ -70,17 +209,25 @@ func GcpInstanceToParamsInstance(gcpInstance *computepb.Instance) (params.Provid
 		ProviderID: GetInstanceName(*gcpInstance.Name),
 		Name:       name,
 		OSType:     params.OSType(gcpInstance.Labels["ostype"]),
-		OSArch:     params.OSArch(*gcpInstance.Disks[0].Architecture),
+		OSArch:     getArchForInstance(gcpInstance),
+		Addresses:  getAddressesForInstance(gcpInstance),
 	}
 
-	switch gcpInstance.GetStatus() {
-	case "RUNNING", "STAGING", "PROVISIONING":
-		details.Status = params.InstanceRunning
-	case "STOPPING", "TERMINATED", "SUSPENDED":
-		details.Status = params.InstanceStopped
-	default:
-		details.Status = params.InstanceStatusUnknown
-	}
+	details.Status = MapGcpStatus(gcpInstance.GetStatus())
 
 	return details, nil
 }
+
+// MapGcpStatus maps the status of a GCP instance to the garm instance status.
+// Instances that are starting or being repaired are reported as running, and
+// instances that are stopping, suspending or being torn down as stopped.
+func MapGcpStatus(status string) params.InstanceStatus {
+	switch status {
+	case "RUNNING", "STAGING", "PROVISIONING", "REPAIRING":
+		return params.InstanceRunning
+	case "STOPPING", "STOPPED", "TERMINATED", "SUSPENDING", "SUSPENDED", "DEPROVISIONING":
+		return params.InstanceStopped
+	default:
+		return params.InstanceStatusUnknown
+	}
+}
@@ -16,6 +16,7 @@
 package util
 
 import (
+	"strings"
 	"testing"
 
 	"cloud.google.com/go/compute/apiv1/computepb"
@@ -43,7 +44,7 @@ func TestGcpInstanceToParamsInstance(t *testing.T) {
 				ProviderID: "garm-instance",
 				Name:       "garm-instance",
 				OSType:     "linux",
-				OSArch:     "x86_64",
+				OSArch:     "amd64",
 				Status:     "running",
 			},
 			errString: "",
@@ -65,11 +66,127 @@ func TestGcpInstanceToParamsInstance(t *testing.T) {
 				ProviderID: "garm-instance",
 				Name:       "Garm-Instance",
 				OSType:     "linux",
-				OSArch:     "x86_64",
+				OSArch:     "amd64",
 				Status:     "running",
 			},
 			errString: "",
 		},
+		{
+			name: "ArchFromLabel",
+			gcpInstance: &computepb.Instance{
+				Name:   proto.String("garm-instance"),
+				Labels: map[string]string{"ostype": "linux", "garmosarch": "arm64"},
+				Disks:  []*computepb.AttachedDisk{{Architecture: proto.String("x86_64")}},
+				Status: proto.String("RUNNING"),
+			},
+			expected: params.ProviderInstance{
+				ProviderID: "garm-instance",
+				Name:       "garm-instance",
+				OSType:     "linux",
+				OSArch:     "arm64",
+				Status:     "running",
+			},
+			errString: "",
+		},
+		{
+			name: "ArchFromDiskNormalized",
+			gcpInstance: &computepb.Instance{
+				Name:   proto.String("garm-instance"),
+				Labels: map[string]string{"ostype": "linux"},
+				Disks:  []*computepb.AttachedDisk{{Architecture: proto.String("ARM64")}},
+				Status: proto.String("RUNNING"),
+			},
+			expected: params.ProviderInstance{
+				ProviderID: "garm-instance",
+				Name:       "garm-instance",
+				OSType:     "linux",
+				OSArch:     "arm64",
+				Status:     "running",
+			},
+			errString: "",
+		},
+		{
+			name: "MissingDiskArchitecture",
+			gcpInstance: &computepb.Instance{
+				Name:   proto.String("garm-instance"),
+				Labels: map[string]string{"ostype": "linux"},
+				Disks:  []*computepb.AttachedDisk{{}},
+				Status: proto.String("RUNNING"),
+			},
+			expected: params.ProviderInstance{
+				ProviderID: "garm-instance",
+				Name:       "garm-instance",
+				OSType:     "linux",
+				OSArch:     "amd64",
+				Status:     "running",
+			},
+			errString: "",
+		},
+		{
+			name: "NilDisks",
+			gcpInstance: &computepb.Instance{
+				Name:   proto.String("garm-instance"),
+				Labels: map[string]string{"ostype": "linux"},
+				Status: proto.String("TERMINATED"),
+			},
+			expected: params.ProviderInstance{
+				ProviderID: "garm-instance",
+				Name:       "garm-instance",
+				OSType:     "linux",
+				OSArch:     "amd64",
+				Status:     "stopped",
+			},
+			errString: "",
+		},
+		{
+			name: "EmptyDisks",
+			gcpInstance: &computepb.Instance{
+				Name:   proto.String("garm-instance"),
+				Labels: map[string]string{"ostype": "linux"},
+				Disks:  []*computepb.AttachedDisk{},
+				Status: proto.String("PROVISIONING"),
+			},
+			expected: params.ProviderInstance{
+				ProviderID: "garm-instance",
+				Name:       "garm-instance",
+				OSType:     "linux",
+				OSArch:     "amd64",
+				Status:     "running",
+			},
+			errString: "",
+		},
+		{
+			name: "Addresses",
+			gcpInstance: &computepb.Instance{
+				Name:   proto.String("garm-instance"),
+				Labels: map[string]string{"ostype": "linux"},
+				Status: proto.String("RUNNING"),
+				NetworkInterfaces: []*computepb.NetworkInterface{
+					{
+						NetworkIP: proto.String("10.0.0.2"),
+						AccessConfigs: []*computepb.AccessConfig{
+							{NatIP: proto.String("203.0.113.10")},
+						},
+					},
+					{
+						NetworkIP: proto.String("10.1.0.2"),
+					},
+				},
+			},
+			expected: params.ProviderInstance{
+				ProviderID: "garm-instance",
+				Name:       "garm-instance",
+				OSType:     "linux",
+				OSArch:     "amd64",
+				Status:     "running",
+				Addresses: []params.Address{
+					{Address: "10.0.0.2", Type: params.PrivateAddress},
+					{Address: "203.0.113.10", Type: params.PublicAddress},
+					{Address: "10.1.0.2", Type: params.PrivateAddress},
+				},
+			},
+			errString: "",
+		},
 		{
 			name:        "NilGcpInstance",
 			gcpInstance: nil,
@@ -121,29 +238,315 @@ func TestGetInstanceName(t *testing.T) {
 			instance: "",
 			expected: "",
 		},
+		{
+			name:     "StartsWithDigit",
+			instance: "1-runner",
+			expected: "garm-1-runner",
+		},
+		{
+			name:     "StartsWithHyphen",
+			instance: "-runner",
+			expected: "garm--runner",
+		},
+		{
+			name:     "UnderscoresAndDots",
+			instance: "garm_runner.example",
+			expected: "garm-runner-example",
+		},
+		{
+			name:     "TrailingHyphens",
+			instance: "garm-runner__",
+			expected: "garm-runner",
+		},
+		{
+			name:     "LongerThan63Characters",
+			instance: "garm-" + strings.Repeat("a", 70),
+			expected: "garm-" + strings.Repeat("a", 58),
+		},
+		{
+			name:     "TruncatedBeforeHyphen",
+			instance: strings.Repeat("a", 62) + "-b",
+			expected: strings.Repeat("a", 62),
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			instance := GetInstanceName(tt.instance)
 			assert.Equal(t, tt.expected, instance, "expected %s, got %s", tt.expected, instance)
+			// Converting an already converted name must not change it.
+			assert.Equal(t, instance, GetInstanceName(instance))
 		})
 	}
 }
 
 func TestGetMachineType(t *testing.T) {
-	test := struct {
+	tests := []struct {
 		name     string
 		zone     string
 		flavor   string
 		expected string
 	}{
-		name:     "ValidMachineType",
-		zone:     "us-central1-a",
-		flavor:   "n1-standard-1",
-		expected: "zones/us-central1-a/machineTypes/n1-standard-1",
+		{
+			name:     "ValidMachineType",
+			zone:     "us-central1-a",
+			flavor:   "n1-standard-1",
+			expected: "zones/us-central1-a/machineTypes/n1-standard-1",
+		},
+		{
+			name:     "CustomMachineType",
+			zone:     "us-central1-a",
+			flavor:   "e2-custom-2-4096",
+			expected: "zones/us-central1-a/machineTypes/e2-custom-2-4096",
+		},
+		{
+			name:     "MachineTypeURL",
+			zone:     "us-central1-a",
+			flavor:   "zones/us-central1-a/machineTypes/custom-4-8192",
+			expected: "zones/us-central1-a/machineTypes/custom-4-8192",
+		},
+		{
+			name:     "MachineTypeURLInAnotherZone",
+			zone:     "us-central1-b",
+			flavor:   "https://www.googleapis.com/compute/v1/projects/my-project/zones/us-central1-a/machineTypes/n2-standard-4",
+			expected: "zones/us-central1-b/machineTypes/n2-standard-4",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			machine := GetMachineType(tt.zone, tt.flavor)
+			assert.Equal(t, tt.expected, machine, "expected %s, got %s", tt.expected, machine)
+		})
 	}
+}
+
+func TestGetCustomMachineType(t *testing.T) {
+	machine := GetCustomMachineType(4, 8192)
+	assert.Equal(t, "custom-4-8192", machine, "expected %s, got %s", "custom-4-8192", machine)
+}
 
-	machine := GetMachineType(test.zone, test.flavor)
-	assert.Equal(t, test.expected, machine, "expected %s, got %s", test.expected, machine)
+func TestGetMachineFamily(t *testing.T) {
+	tests := []struct {
+		name        string
+		machineType string
+		expected    string
+	}{
+		{
+			name:        "Machine type name",
+			machineType: "n2d-standard-2",
+			expected:    "n2d",
+		},
+		{
+			name:        "Machine type URL",
+			machineType: "zones/europe-west1-d/machineTypes/c2d-highcpu-4",
+			expected:    "c2d",
+		},
+		{
+			name:        "Custom machine type",
+			machineType: "custom-2-4096",
+			expected:    "custom",
+		},
+		{
+			name:        "Empty machine type",
+			machineType: "",
+			expected:    "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, GetMachineFamily(tt.machineType))
+		})
+	}
+}
+
+func TestBuildNetworkURL(t *testing.T) {
+	tests := []struct {
+		name     string
+		network  string
+		expected string
+	}{
+		{
+			name:     "Network name",
+			network:  "default",
+			expected: "projects/my-project/global/networks/default",
+		},
+		{
+			name:     "Network path",
+			network:  "projects/other-project/global/networks/shared",
+			expected: "projects/other-project/global/networks/shared",
+		},
+		{
+			name:     "Network URL",
+			network:  "https://www.googleapis.com/compute/v1/projects/other-project/global/networks/shared",
+			expected: "https://www.googleapis.com/compute/v1/projects/other-project/global/networks/shared",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, BuildNetworkURL("my-project", tt.network))
+		})
+	}
+}
+
+func TestBuildSubnetworkURL(t *testing.T) {
+	tests := []struct {
+		name     string
+		subnet   string
+		expected string
+	}{
+		{
+			name:     "Subnetwork name",
+			subnet:   "default",
+			expected: "projects/my-project/regions/europe-west1/subnetworks/default",
+		},
+		{
+			name:     "Subnetwork path",
+			subnet:   "projects/other-project/regions/us-central1/subnetworks/shared",
+			expected: "projects/other-project/regions/us-central1/subnetworks/shared",
+		},
+		{
+			name:     "Subnetwork URL",
+			subnet:   "https://www.googleapis.com/compute/v1/projects/other-project/regions/us-central1/subnetworks/shared",
+			expected: "https://www.googleapis.com/compute/v1/projects/other-project/regions/us-central1/subnetworks/shared",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, BuildSubnetworkURL("my-project", "europe-west1", tt.subnet))
+		})
+	}
+}
+
+func TestRegionFromZone(t *testing.T) {
+	tests := []struct {
+		name     string
+		zone     string
+		expected string
+		errMsg   string
+	}{
+		{
+			name:     "Valid zone",
+			zone:     "europe-west1-d",
+			expected: "europe-west1",
+		},
+		{
+			name:     "Valid zone with long region",
+			zone:     "northamerica-northeast2-a",
+			expected: "northamerica-northeast2",
+		},
+		{
+			name:   "Empty zone",
+			zone:   "",
+			errMsg: `invalid zone ""`,
+		},
+		{
+			name:   "Region instead of zone",
+			zone:   "europe-west1",
+			errMsg: `invalid zone "europe-west1"`,
+		},
+		{
+			name:   "Zone with multiple letters",
+			zone:   "europe-west1-dd",
+			errMsg: `invalid zone "europe-west1-dd"`,
+		},
+		{
+			name:   "Zone path",
+			zone:   "zones/europe-west1-d",
+			errMsg: `invalid zone "zones/europe-west1-d"`,
+		},
+		{
+			name:   "Uppercase zone",
+			zone:   "EUROPE-WEST1-D",
+			errMsg: `invalid zone "EUROPE-WEST1-D"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			region, err := RegionFromZone(tt.zone)
+			if tt.errMsg != "" {
+				assert.EqualError(t, err, tt.errMsg)
+				assert.Empty(t, region)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, region)
+		})
+	}
+}
+
+func TestMapGcpStatus(t *testing.T) {
+	tests := []struct {
+		status   string
+		expected params.InstanceStatus
+	}{
+		{status: "PROVISIONING", expected: params.InstanceRunning},
+		{status: "STAGING", expected: params.InstanceRunning},
+		{status: "RUNNING", expected: params.InstanceRunning},
+		{status: "REPAIRING", expected: params.InstanceRunning},
+		{status: "STOPPING", expected: params.InstanceStopped},
+		{status: "STOPPED", expected: params.InstanceStopped},
+		{status: "TERMINATED", expected: params.InstanceStopped},
+		{status: "SUSPENDING", expected: params.InstanceStopped},
+		{status: "SUSPENDED", expected: params.InstanceStopped},
+		{status: "DEPROVISIONING", expected: params.InstanceStopped},
+		{status: "UNDEFINED_STATUS", expected: params.InstanceStatusUnknown},
+		{status: "", expected: params.InstanceStatusUnknown},
+		{status: "UNKNOWN", expected: params.InstanceStatusUnknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.status, func(t *testing.T) {
+			assert.Equal(t, tt.expected, MapGcpStatus(tt.status))
+		})
+	}
+}
+
+func TestMapGcpStatusKnownStates(t *testing.T) {
+	// Every status defined by the compute API must map to a known status.
+	for value, status := range computepb.Instance_Status_name {
+		if value == int32(computepb.Instance_UNDEFINED_STATUS) {
+			continue
+		}
+		assert.NotEqual(t, params.InstanceStatusUnknown, MapGcpStatus(status), "status %s is not mapped", status)
+	}
+}
+
+func TestBuildImageURL(t *testing.T) {
+	tests := []struct {
+		name     string
+		image    string
+		expected string
+	}{
+		{
+			name:     "Image name",
+			image:    "debian-cloud-init",
+			expected: "projects/my-images/global/images/debian-cloud-init",
+		},
+		{
+			name:     "Image family",
+			image:    "family/debian-12",
+			expected: "projects/my-images/global/images/family/debian-12",
+		},
+		{
+			name:     "Image path",
+			image:    "projects/debian-cloud/global/images/debian-12-bookworm-v20240617",
+			expected: "projects/debian-cloud/global/images/debian-12-bookworm-v20240617",
+		},
+		{
+			name:     "Image family path",
+			image:    "projects/windows-cloud/global/images/family/windows-2022",
+			expected: "projects/windows-cloud/global/images/family/windows-2022",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, BuildImageURL("my-images", tt.image))
+		})
+	}
 }
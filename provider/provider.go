@@ -17,14 +17,18 @@ package provider
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync"
 
+	"cloud.google.com/go/compute/apiv1/computepb"
 	execution "github.com/cloudbase/garm-provider-common/execution/v0.1.0"
 	"github.com/cloudbase/garm-provider-common/params"
 	"github.com/cloudbase/garm-provider-gcp/config"
 	"github.com/cloudbase/garm-provider-gcp/internal/client"
 	"github.com/cloudbase/garm-provider-gcp/internal/spec"
 	"github.com/cloudbase/garm-provider-gcp/internal/util"
+	"golang.org/x/sync/errgroup"
 )
 
 var _ execution.ExternalProvider = &GcpProvider{}
@@ -60,7 +64,30 @@ func (g *GcpProvider) CreateInstance(ctx context.Context, bootstrapParams params
 	}
 	inst, err := g.gcpCli.CreateInstance(ctx, spec)
 	if err != nil {
-		return params.ProviderInstance{}, fmt.Errorf("error creating instance: %w", err)
+		// Quota and capacity errors will not go away by retrying right away.
+		// Make them stand out so the pool can back off.
+		switch {
+		case errors.Is(err, client.ErrQuotaExceeded):
+			return params.ProviderInstance{}, fmt.Errorf("quota exceeded while creating instance %s: %w", spec.BootstrapParams.Name, err)
+		case errors.Is(err, client.ErrResourcesExhausted):
+			return params.ProviderInstance{}, fmt.Errorf("no capacity left in zones %v to create instance %s: %w", g.gcpCli.Config().GetZones(), spec.BootstrapParams.Name, err)
+		case errors.Is(err, client.ErrInstanceNotRunning):
+			// The instance exists, but it did not start. Handing it back
+			// would hide the failure from garm.
+			return params.ProviderInstance{}, fmt.Errorf("instance %s did not reach the RUNNING state: %w", spec.BootstrapParams.Name, err)
+		}
+		// The instance may exist even though the create request failed, for
+		// example if waiting on the operation timed out. Return it if so, but
+		// keep the original error if it can not be found.
+		existing, getErr := g.gcpCli.GetInstance(ctx, spec.BootstrapParams.Name)
+		if getErr != nil {
+			return params.ProviderInstance{}, fmt.Errorf("error creating instance: %w", err)
+		}
+		instance, convErr := util.GcpInstanceToParamsInstance(existing)
+		if convErr != nil {
+			return params.ProviderInstance{}, fmt.Errorf("error converting instance: %w", convErr)
+		}
+		return instance, nil
 	}
 	instance := params.ProviderInstance{
 		ProviderID: *inst.Name,
@@ -72,6 +99,20 @@ func (g *GcpProvider) CreateInstance(ctx context.Context, bootstrapParams params
 	return instance, nil
 }
 
+// PreviewUserData returns the user data an instance would be created with,
+// without creating anything. It helps debug bootstrap issues of an image.
+func (g *GcpProvider) PreviewUserData(ctx context.Context, bootstrapParams params.BootstrapInstance) (string, error) {
+	spec, err := spec.GetRunnerSpecFromBootstrapParams(g.gcpCli.Config(), bootstrapParams, g.controllerID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get runner spec: %w", err)
+	}
+	udata, err := spec.ComposeUserData()
+	if err != nil {
+		return "", fmt.Errorf("failed to compose user data: %w", err)
+	}
+	return udata, nil
+}
+
 func (g *GcpProvider) GetInstance(ctx context.Context, instance string) (params.ProviderInstance, error) {
 	inst, err := g.gcpCli.GetInstance(ctx, instance)
 	if err != nil {
@@ -84,6 +125,15 @@ func (g *GcpProvider) GetInstance(ctx context.Context, instance string) (params.
 	return instanceParams, nil
 }
 
+// GetInstanceStatus returns only the status of an instance.
+func (g *GcpProvider) GetInstanceStatus(ctx context.Context, instance string) (params.InstanceStatus, error) {
+	inst, err := g.gcpCli.GetInstance(ctx, instance)
+	if err != nil {
+		return params.InstanceStatusUnknown, fmt.Errorf("error getting instance: %w", err)
+	}
+	return util.MapGcpStatus(inst.GetStatus()), nil
+}
+
 func (g *GcpProvider) DeleteInstance(ctx context.Context, instance string) error {
 	err := g.gcpCli.DeleteInstance(ctx, instance)
 	if err != nil {
@@ -93,7 +143,13 @@ func (g *GcpProvider) DeleteInstance(ctx context.Context, instance string) error
 }
 
 func (g *GcpProvider) ListInstances(ctx context.Context, poolID string) ([]params.ProviderInstance, error) {
-	gcpInstances, err := g.gcpCli.ListDescribedInstances(ctx, poolID)
+	var gcpInstances []*computepb.Instance
+	var err error
+	if g.gcpCli.Config().RegionWide {
+		gcpInstances, err = g.gcpCli.ListDescribedInstancesAggregated(ctx, poolID)
+	} else {
+		gcpInstances, err = g.gcpCli.ListDescribedInstances(ctx, poolID)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to list instances: %w", err)
 	}
@@ -110,17 +166,74 @@ func (g *GcpProvider) ListInstances(ctx context.Context, poolID string) ([]param
 }
 
 func (g *GcpProvider) RemoveAllInstances(ctx context.Context) error {
-	return nil
+	gcpInstances, err := g.gcpCli.ListInstancesByController(ctx, g.controllerID)
+	if err != nil {
+		return fmt.Errorf("failed to list instances: %w", err)
+	}
+
+	// Delete the instances in parallel, with a bounded number of deletes in
+	// flight. A failed delete does not stop the others, and all the errors
+	// are reported.
+	var (
+		mux  sync.Mutex
+		errs []error
+	)
+	eg := new(errgroup.Group)
+	eg.SetLimit(g.gcpCli.Config().GetDeleteConcurrency())
+	for _, inst := range gcpInstances {
+		name := inst.GetName()
+		eg.Go(func() error {
+			if err := g.gcpCli.DeleteInstance(ctx, name); err != nil {
+				mux.Lock()
+				errs = append(errs, fmt.Errorf("error deleting instance %s: %w", name, err))
+				mux.Unlock()
+			}
+			return nil
+		})
+	}
+	_ = eg.Wait()
+	return errors.Join(errs...)
 }
 
 func (g *GcpProvider) Stop(ctx context.Context, instance string, force bool) error {
+	if g.gcpCli.Config().UseSuspend {
+		return g.gcpCli.SuspendInstance(ctx, instance)
+	}
 	return g.gcpCli.StopInstance(ctx, instance)
 }
 
 func (g *GcpProvider) Start(ctx context.Context, instance string) error {
+	if g.gcpCli.Config().UseSuspend {
+		return g.gcpCli.ResumeInstance(ctx, instance)
+	}
 	return g.gcpCli.StartInstance(ctx, instance)
 }
 
+// Reset hard-reboots an instance. It can be used to recover runners that
+// stopped responding.
+func (g *GcpProvider) Reset(ctx context.Context, instance string) error {
+	return g.gcpCli.ResetInstance(ctx, instance)
+}
+
+// ResizeDisk grows the boot disk of an instance. It can be used for long lived
+// runners that run out of disk space.
+func (g *GcpProvider) ResizeDisk(ctx context.Context, instance string, newSizeGb int64) error {
+	if err := g.gcpCli.ResizeDisk(ctx, instance, newSizeGb); err != nil {
+		return fmt.Errorf("error resizing disk: %w", err)
+	}
+	return nil
+}
+
+// GetSerialPortOutput returns the serial console output of an instance. It can
+// be used to debug runners that never register with garm.
+func (g *GcpProvider) GetSerialPortOutput(ctx context.Context, instance string, port int32) (string, error) {
+	output, err := g.gcpCli.GetSerialPortOutput(ctx, instance, port)
+	if err != nil {
+		return "", fmt.Errorf("error getting serial port output: %w", err)
+	}
+	return output, nil
+}
+
 func (g *GcpProvider) GetVersion(ctx context.Context) string {
 	return Version
 }
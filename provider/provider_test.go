@@ -18,7 +18,10 @@ package provider
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	compute "cloud.google.com/go/compute/apiv1"
 	"cloud.google.com/go/compute/apiv1/computepb"
@@ -32,6 +35,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -49,6 +53,10 @@ func TestCreateInstance(t *testing.T) {
 	client.WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
 		return nil
 	}
+	client.OperationProto = func(op *compute.Operation) *computepb.Operation {
+		return &computepb.Operation{}
+	}
+	defer func() { client.OperationProto = (*compute.Operation).Proto }()
 	gcpProvider := &GcpProvider{
 		gcpCli:       &client.GcpCli{},
 		controllerID: "my-controller",
@@ -110,6 +118,10 @@ func TestCreateInstanceError(t *testing.T) {
 	client.WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
 		return nil
 	}
+	client.OperationProto = func(op *compute.Operation) *computepb.Operation {
+		return &computepb.Operation{}
+	}
+	defer func() { client.OperationProto = (*compute.Operation).Proto }()
 	gcpProvider := &GcpProvider{
 		gcpCli:       &client.GcpCli{},
 		controllerID: "my-controller",
@@ -130,6 +142,7 @@ func TestCreateInstanceError(t *testing.T) {
 		Code: 404,
 	})
 	mockClient.On("Insert", mock.Anything, mock.Anything, mock.Anything).Return(mockOperation, mockErr)
+	mockClient.On("Get", mock.Anything, mock.Anything, mock.Anything).Return(&computepb.Instance{}, mockErr)
 	bootstrapParams := params.BootstrapInstance{
 		Name:   "garm-instance",
 		Flavor: "n1-standard-1",
@@ -150,10 +163,314 @@ func TestCreateInstanceError(t *testing.T) {
 	expectedInstance := params.ProviderInstance{}
 
 	result, err := gcpProvider.CreateInstance(ctx, bootstrapParams)
-	assert.Error(t, err)
+	assert.ErrorContains(t, err, "error creating instance")
+	assert.NotContains(t, err.Error(), "error getting instance")
 	assert.Equal(t, expectedInstance, result)
 }
 
+func TestCreateInstanceWaitForRunningTerminated(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(client.MockGcpClient)
+	spec.DefaultToolFetch = func(osType params.OSType, osArch params.OSArch, tools []params.RunnerApplicationDownload) (params.RunnerApplicationDownload, error) {
+		return params.RunnerApplicationDownload{
+			OS:           proto.String("linux"),
+			Architecture: proto.String("amd64"),
+			DownloadURL:  proto.String("MockURL"),
+			Filename:     proto.String("garm-runner"),
+		}, nil
+	}
+	client.WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return nil
+	}
+	client.OperationProto = func(op *compute.Operation) *computepb.Operation {
+		return &computepb.Operation{}
+	}
+	defer func() { client.OperationProto = (*compute.Operation).Proto }()
+	gcpProvider := &GcpProvider{
+		gcpCli:       &client.GcpCli{},
+		controllerID: "my-controller",
+	}
+	config := config.Config{
+		Zone:             "europe-west1-d",
+		ProjectId:        "my-project",
+		NetworkID:        "my-network",
+		SubnetworkID:     "my-subnetwork",
+		CredentialsFile:  "path/to/credentials.json",
+		ExternalIPAccess: true,
+		WaitForRunning:   true,
+	}
+	gcpProvider.gcpCli.SetClient(mockClient)
+	gcpProvider.gcpCli.SetConfig(&config)
+
+	mockClient.On("Insert", mock.Anything, mock.Anything, mock.Anything).Return(&compute.Operation{}, nil)
+	mockClient.On("Get", mock.Anything, mock.Anything, mock.Anything).Return(&computepb.Instance{
+		Name:   proto.String("garm-instance"),
+		Status: proto.String("TERMINATED"),
+	}, nil)
+	bootstrapParams := params.BootstrapInstance{
+		Name:       "garm-instance",
+		Flavor:     "n1-standard-1",
+		Image:      "projects/garm-testing/global/images/garm-image",
+		OSType:     params.Linux,
+		OSArch:     params.Amd64,
+		PoolID:     "my-pool",
+		ExtraSpecs: json.RawMessage(`{}`),
+	}
+
+	result, err := gcpProvider.CreateInstance(ctx, bootstrapParams)
+	assert.ErrorIs(t, err, client.ErrInstanceNotRunning)
+	assert.ErrorContains(t, err, "instance garm-instance did not reach the RUNNING state")
+	assert.Equal(t, params.ProviderInstance{}, result)
+}
+
+func TestCreateInstanceErrorExistingInstance(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(client.MockGcpClient)
+	spec.DefaultToolFetch = func(osType params.OSType, osArch params.OSArch, tools []params.RunnerApplicationDownload) (params.RunnerApplicationDownload, error) {
+		return params.RunnerApplicationDownload{
+			OS:           proto.String("linux"),
+			Architecture: proto.String("amd64"),
+			DownloadURL:  proto.String("MockURL"),
+			Filename:     proto.String("garm-runner"),
+		}, nil
+	}
+	client.WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return context.DeadlineExceeded
+	}
+	client.OperationProto = func(op *compute.Operation) *computepb.Operation {
+		return &computepb.Operation{}
+	}
+	defer func() { client.OperationProto = (*compute.Operation).Proto }()
+	gcpProvider := &GcpProvider{
+		gcpCli:       &client.GcpCli{},
+		controllerID: "my-controller",
+	}
+	config := config.Config{
+		Zone:             "europe-west1-d",
+		ProjectId:        "my-project",
+		NetworkID:        "my-network",
+		SubnetworkID:     "my-subnetwork",
+		CredentialsFile:  "path/to/credentials.json",
+		ExternalIPAccess: true,
+	}
+	gcpProvider.gcpCli.SetClient(mockClient)
+	gcpProvider.gcpCli.SetConfig(&config)
+
+	mockOperation := &compute.Operation{}
+	mockClient.On("Insert", mock.Anything, mock.Anything, mock.Anything).Return(mockOperation, nil)
+	mockClient.On("Get", mock.Anything, mock.Anything, mock.Anything).Return(&computepb.Instance{
+		Name:   proto.String("garm-instance"),
+		Labels: map[string]string{"ostype": "linux"},
+		Disks:  []*computepb.AttachedDisk{{Architecture: proto.String("X86_64")}},
+		Status: proto.String("PROVISIONING"),
+	}, nil)
+	bootstrapParams := params.BootstrapInstance{
+		Name:   "garm-instance",
+		Flavor: "n1-standard-1",
+		Image:  "projects/garm-testing/global/images/garm-image",
+		Tools: []params.RunnerApplicationDownload{
+			{
+				OS:           proto.String("linux"),
+				Architecture: proto.String("amd64"),
+				DownloadURL:  proto.String("MockURL"),
+				Filename:     proto.String("garm-runner"),
+			},
+		},
+		OSType:     params.Linux,
+		OSArch:     params.Amd64,
+		PoolID:     "my-pool",
+		ExtraSpecs: json.RawMessage(`{}`),
+	}
+	expectedInstance := params.ProviderInstance{
+		ProviderID: "garm-instance",
+		Name:       "garm-instance",
+		OSType:     "linux",
+		OSArch:     "amd64",
+		Status:     "running",
+	}
+
+	result, err := gcpProvider.CreateInstance(ctx, bootstrapParams)
+	assert.NoError(t, err)
+	assert.Equal(t, expectedInstance, result)
+}
+
+func TestCreateInstanceQuotaExceeded(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(client.MockGcpClient)
+	spec.DefaultToolFetch = func(osType params.OSType, osArch params.OSArch, tools []params.RunnerApplicationDownload) (params.RunnerApplicationDownload, error) {
+		return params.RunnerApplicationDownload{
+			OS:           proto.String("linux"),
+			Architecture: proto.String("amd64"),
+			DownloadURL:  proto.String("MockURL"),
+			Filename:     proto.String("garm-runner"),
+		}, nil
+	}
+	client.WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return nil
+	}
+	client.OperationProto = func(op *compute.Operation) *computepb.Operation {
+		return &computepb.Operation{}
+	}
+	defer func() { client.OperationProto = (*compute.Operation).Proto }()
+	gcpProvider := &GcpProvider{
+		gcpCli:       &client.GcpCli{},
+		controllerID: "my-controller",
+	}
+	config := config.Config{
+		Zone:             "europe-west1-d",
+		ProjectId:        "my-project",
+		NetworkID:        "my-network",
+		SubnetworkID:     "my-subnetwork",
+		CredentialsFile:  "path/to/credentials.json",
+		ExternalIPAccess: true,
+	}
+	gcpProvider.gcpCli.SetClient(mockClient)
+	gcpProvider.gcpCli.SetConfig(&config)
+
+	mockOperation := &compute.Operation{}
+	mockErr, _ := apierror.FromError(&googleapi.Error{
+		Code:   403,
+		Errors: []googleapi.ErrorItem{{Reason: "quotaExceeded"}},
+	})
+	mockClient.On("Insert", mock.Anything, mock.Anything, mock.Anything).Return(mockOperation, mockErr)
+	bootstrapParams := params.BootstrapInstance{
+		Name:   "garm-instance",
+		Flavor: "n1-standard-1",
+		Image:  "projects/garm-testing/global/images/garm-image",
+		Tools: []params.RunnerApplicationDownload{
+			{
+				OS:           proto.String("linux"),
+				Architecture: proto.String("amd64"),
+				DownloadURL:  proto.String("MockURL"),
+				Filename:     proto.String("garm-runner"),
+			},
+		},
+		OSType:     params.Linux,
+		OSArch:     params.Amd64,
+		PoolID:     "my-pool",
+		ExtraSpecs: json.RawMessage(`{}`),
+	}
+	expectedInstance := params.ProviderInstance{}
+
+	result, err := gcpProvider.CreateInstance(ctx, bootstrapParams)
+	assert.ErrorIs(t, err, client.ErrQuotaExceeded)
+	assert.ErrorContains(t, err, "quota exceeded while creating instance garm-instance")
+	assert.Equal(t, expectedInstance, result)
+}
+
+func TestCreateInstanceResourcesExhausted(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(client.MockGcpClient)
+	spec.DefaultToolFetch = func(osType params.OSType, osArch params.OSArch, tools []params.RunnerApplicationDownload) (params.RunnerApplicationDownload, error) {
+		return params.RunnerApplicationDownload{
+			OS:           proto.String("linux"),
+			Architecture: proto.String("amd64"),
+			DownloadURL:  proto.String("MockURL"),
+			Filename:     proto.String("garm-runner"),
+		}, nil
+	}
+	client.WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return nil
+	}
+	client.OperationProto = func(op *compute.Operation) *computepb.Operation {
+		return &computepb.Operation{}
+	}
+	defer func() { client.OperationProto = (*compute.Operation).Proto }()
+	gcpProvider := &GcpProvider{
+		gcpCli:       &client.GcpCli{},
+		controllerID: "my-controller",
+	}
+	config := config.Config{
+		Zone:             "europe-west1-d",
+		ProjectId:        "my-project",
+		NetworkID:        "my-network",
+		SubnetworkID:     "my-subnetwork",
+		CredentialsFile:  "path/to/credentials.json",
+		ExternalIPAccess: true,
+	}
+	gcpProvider.gcpCli.SetClient(mockClient)
+	gcpProvider.gcpCli.SetConfig(&config)
+
+	mockOperation := &compute.Operation{}
+	mockErr, _ := apierror.FromError(&googleapi.Error{
+		Code:    400,
+		Message: "ZONE_RESOURCE_POOL_EXHAUSTED",
+	})
+	mockClient.On("Insert", mock.Anything, mock.Anything, mock.Anything).Return(mockOperation, mockErr)
+	bootstrapParams := params.BootstrapInstance{
+		Name:   "garm-instance",
+		Flavor: "n1-standard-1",
+		Image:  "projects/garm-testing/global/images/garm-image",
+		Tools: []params.RunnerApplicationDownload{
+			{
+				OS:           proto.String("linux"),
+				Architecture: proto.String("amd64"),
+				DownloadURL:  proto.String("MockURL"),
+				Filename:     proto.String("garm-runner"),
+			},
+		},
+		OSType:     params.Linux,
+		OSArch:     params.Amd64,
+		PoolID:     "my-pool",
+		ExtraSpecs: json.RawMessage(`{}`),
+	}
+	expectedInstance := params.ProviderInstance{}
+
+	result, err := gcpProvider.CreateInstance(ctx, bootstrapParams)
+	assert.ErrorIs(t, err, client.ErrResourcesExhausted)
+	assert.ErrorContains(t, err, "no capacity left in zones [europe-west1-d] to create instance garm-instance")
+	assert.Equal(t, expectedInstance, result)
+}
+
+func TestPreviewUserData(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(client.MockGcpClient)
+	spec.DefaultToolFetch = func(osType params.OSType, osArch params.OSArch, tools []params.RunnerApplicationDownload) (params.RunnerApplicationDownload, error) {
+		return params.RunnerApplicationDownload{
+			OS:           proto.String("linux"),
+			Architecture: proto.String("amd64"),
+			DownloadURL:  proto.String("MockURL"),
+			Filename:     proto.String("garm-runner"),
+		}, nil
+	}
+	defaultCloudConfigFunc := spec.DefaultCloudConfigFunc
+	defer func() { spec.DefaultCloudConfigFunc = defaultCloudConfigFunc }()
+	spec.DefaultCloudConfigFunc = func(bootstrapParams params.BootstrapInstance, tools params.RunnerApplicationDownload, runnerName string) (string, error) {
+		return "#cloud-config\nrunner: " + runnerName + "\n", nil
+	}
+	gcpProvider := &GcpProvider{
+		gcpCli:       &client.GcpCli{},
+		controllerID: "my-controller",
+	}
+	gcpProvider.gcpCli.SetClient(mockClient)
+	gcpProvider.gcpCli.SetConfig(&config.Config{
+		Zone:         "europe-west1-d",
+		ProjectId:    "my-project",
+		NetworkID:    "my-network",
+		SubnetworkID: "my-subnetwork",
+	})
+
+	bootstrapParams := params.BootstrapInstance{
+		Name:       "garm-instance",
+		Flavor:     "n1-standard-1",
+		Image:      "projects/garm-testing/global/images/garm-image",
+		OSType:     params.Linux,
+		OSArch:     params.Amd64,
+		PoolID:     "my-pool",
+		ExtraSpecs: json.RawMessage(`{}`),
+	}
+
+	udata, err := gcpProvider.PreviewUserData(ctx, bootstrapParams)
+	assert.NoError(t, err)
+	assert.Equal(t, "#cloud-config\nrunner: garm-instance\n", udata)
+	// Nothing is created.
+	mockClient.AssertNotCalled(t, "Insert", mock.Anything, mock.Anything, mock.Anything)
+
+	bootstrapParams.ExtraSpecs = json.RawMessage(`{"disksize": "big"}`)
+	_, err = gcpProvider.PreviewUserData(ctx, bootstrapParams)
+	assert.ErrorContains(t, err, "failed to get runner spec")
+}
+
 func TestGetInstance(t *testing.T) {
 	ctx := context.Background()
 	mockClient := new(client.MockGcpClient)
@@ -193,11 +510,74 @@ func TestGetInstance(t *testing.T) {
 		Instance: util.GetInstanceName(instanceName),
 	}, mock.Anything).Return(expectedInstance, nil)
 
-	result, err := gcpProvider.GetInstance(ctx, instanceName)
-	assert.NoError(t, err)
-	assert.Equal(t, expectedInstanceParams, result)
-	mockClient.AssertExpectations(t)
+	result, err := gcpProvider.GetInstance(ctx, instanceName)
+	assert.NoError(t, err)
+	assert.Equal(t, expectedInstanceParams, result)
+	mockClient.AssertExpectations(t)
+
+}
+
+func TestGetInstanceStatus(t *testing.T) {
+	tests := []struct {
+		status   string
+		expected params.InstanceStatus
+	}{
+		{status: "RUNNING", expected: params.InstanceRunning},
+		{status: "STAGING", expected: params.InstanceRunning},
+		{status: "TERMINATED", expected: params.InstanceStopped},
+		{status: "UNKNOWN", expected: params.InstanceStatusUnknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.status, func(t *testing.T) {
+			ctx := context.Background()
+			mockClient := new(client.MockGcpClient)
+			gcpProvider := &GcpProvider{
+				gcpCli:       &client.GcpCli{},
+				controllerID: "my-controller",
+			}
+			gcpProvider.gcpCli.SetClient(mockClient)
+			gcpProvider.gcpCli.SetConfig(&config.Config{
+				Zone:      "europe-west1-d",
+				ProjectId: "my-project",
+			})
+
+			mockClient.On("Get", ctx, &computepb.GetInstanceRequest{
+				Project:  "my-project",
+				Zone:     "europe-west1-d",
+				Instance: "my-instance",
+			}, mock.Anything).Return(&computepb.Instance{
+				Name:   proto.String("my-instance"),
+				Status: proto.String(tt.status),
+			}, nil)
+
+			status, err := gcpProvider.GetInstanceStatus(ctx, "my-instance")
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, status)
+			mockClient.AssertExpectations(t)
+		})
+	}
+}
+
+func TestGetInstanceStatusError(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(client.MockGcpClient)
+	gcpProvider := &GcpProvider{
+		gcpCli:       &client.GcpCli{},
+		controllerID: "my-controller",
+	}
+	gcpProvider.gcpCli.SetClient(mockClient)
+	gcpProvider.gcpCli.SetConfig(&config.Config{
+		Zone:      "europe-west1-d",
+		ProjectId: "my-project",
+	})
+
+	mockClient.On("Get", ctx, mock.Anything, mock.Anything).Return(&computepb.Instance{}, fmt.Errorf("error getting instance"))
 
+	status, err := gcpProvider.GetInstanceStatus(ctx, "my-instance")
+	assert.Error(t, err)
+	assert.Equal(t, params.InstanceStatusUnknown, status)
+	mockClient.AssertExpectations(t)
 }
 
 func TestDeleteInstance(t *testing.T) {
@@ -223,6 +603,7 @@ func TestDeleteInstance(t *testing.T) {
 	gcpProvider.gcpCli.SetConfig(&config)
 
 	instanceName := "my-instance"
+	mockClient.On("Get", ctx, mock.AnythingOfType("*computepb.GetInstanceRequest"), []gax.CallOption(nil)).Return(&computepb.Instance{}, nil)
 	mockClient.On("Delete", ctx, mock.AnythingOfType("*computepb.DeleteInstanceRequest"), []gax.CallOption(nil)).Return(mockOperation, nil)
 
 	err := gcpProvider.DeleteInstance(ctx, instanceName)
@@ -325,13 +706,14 @@ func TestListInstances(t *testing.T) {
 			it++
 			return toBeIteratedInstances[it-1], nil
 		}
-		return nil, nil
+		return nil, iterator.Done
 	}
 
 	mockClient.On("List", ctx, &computepb.ListInstancesRequest{
-		Project: gcpProvider.gcpCli.Config().ProjectId,
-		Zone:    gcpProvider.gcpCli.Config().Zone,
-		Filter:  proto.String("labels.garmpoolid=garm-pool"),
+		Project:    gcpProvider.gcpCli.Config().ProjectId,
+		Zone:       gcpProvider.gcpCli.Config().Zone,
+		Filter:     proto.String("labels.garmpoolid=garm-pool"),
+		MaxResults: proto.Uint32(500),
 	}, mock.Anything).Return(&compute.InstanceIterator{}, nil)
 
 	resultInstances, err := gcpProvider.ListInstances(ctx, poolID)
@@ -340,6 +722,345 @@ func TestListInstances(t *testing.T) {
 
 }
 
+func TestListInstancesRegionWide(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(client.MockGcpClient)
+	poolID := "garm-pool"
+	gcpProvider := &GcpProvider{
+		gcpCli:       &client.GcpCli{},
+		controllerID: "my-controller",
+	}
+	config := config.Config{
+		Zone:             "europe-west1-d",
+		ProjectId:        "my-project",
+		NetworkID:        "my-network",
+		SubnetworkID:     "my-subnetwork",
+		CredentialsFile:  "path/to/credentials.json",
+		Zones:            []string{"europe-west1-b"},
+		ExternalIPAccess: true,
+		RegionWide:       true,
+	}
+	gcpProvider.gcpCli.SetClient(mockClient)
+	gcpProvider.gcpCli.SetConfig(&config)
+	pairs := []compute.InstancesScopedListPair{
+		{
+			Key: "zones/europe-west1-b",
+			Value: &computepb.InstancesScopedList{
+				Instances: []*computepb.Instance{
+					{
+						Name:   proto.String("garm-instance-1"),
+						Status: proto.String("RUNNING"),
+						Labels: map[string]string{
+							"garmpoolid": poolID,
+							"ostype":     "linux",
+						},
+						Disks: []*computepb.AttachedDisk{{Architecture: proto.String("amd64")}},
+					},
+				},
+			},
+		},
+		{
+			Key: "zones/europe-west1-d",
+			Value: &computepb.InstancesScopedList{
+				Instances: []*computepb.Instance{
+					{
+						Name:   proto.String("garm-instance-2"),
+						Status: proto.String("TERMINATED"),
+						Labels: map[string]string{
+							"garmpoolid": poolID,
+							"ostype":     "linux",
+						},
+						Disks: []*computepb.AttachedDisk{{Architecture: proto.String("amd64")}},
+					},
+				},
+			},
+		},
+	}
+	expectedInstances := []params.ProviderInstance{
+		{
+			ProviderID: "garm-instance-1",
+			Name:       "garm-instance-1",
+			OSType:     "linux",
+			OSArch:     "amd64",
+			Status:     "running",
+		},
+		{
+			ProviderID: "garm-instance-2",
+			Name:       "garm-instance-2",
+			OSType:     "linux",
+			OSArch:     "amd64",
+			Status:     "stopped",
+		},
+	}
+
+	it := 0
+	client.NextAggregatedIt = func(*compute.InstancesScopedListPairIterator) (compute.InstancesScopedListPair, error) {
+		if it < len(pairs) {
+			it++
+			return pairs[it-1], nil
+		}
+		return compute.InstancesScopedListPair{}, iterator.Done
+	}
+	defer func() { client.NextAggregatedIt = (*compute.InstancesScopedListPairIterator).Next }()
+
+	mockClient.On("AggregatedList", ctx, mock.Anything, mock.Anything).Return(&compute.InstancesScopedListPairIterator{})
+
+	resultInstances, err := gcpProvider.ListInstances(ctx, poolID)
+	assert.NoError(t, err)
+	assert.Equal(t, expectedInstances, resultInstances)
+	mockClient.AssertExpectations(t)
+	mockClient.AssertNotCalled(t, "List", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestRemoveAllInstances(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(client.MockGcpClient)
+	mockOperation := &compute.Operation{}
+	client.WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return nil
+	}
+	gcpProvider := &GcpProvider{
+		gcpCli:       &client.GcpCli{},
+		controllerID: "my-controller",
+	}
+	config := config.Config{
+		Zone:             "europe-west1-d",
+		ProjectId:        "my-project",
+		NetworkID:        "my-network",
+		SubnetworkID:     "my-subnetwork",
+		CredentialsFile:  "path/to/credentials.json",
+		ExternalIPAccess: true,
+	}
+	gcpProvider.gcpCli.SetClient(mockClient)
+	gcpProvider.gcpCli.SetConfig(&config)
+	toBeIteratedInstances := []*computepb.Instance{
+		{Name: proto.String("garm-instance-1")},
+		{Name: proto.String("garm-instance-2")},
+		{Name: proto.String("garm-instance-3")},
+	}
+
+	it := 0
+	client.NextIt = func(*compute.InstanceIterator) (*computepb.Instance, error) {
+		if it < len(toBeIteratedInstances) {
+			it++
+			return toBeIteratedInstances[it-1], nil
+		}
+		return nil, iterator.Done
+	}
+
+	mockClient.On("List", ctx, &computepb.ListInstancesRequest{
+		Project:    config.ProjectId,
+		Zone:       config.Zone,
+		Filter:     proto.String("labels.garmcontrollerid=my-controller"),
+		MaxResults: proto.Uint32(500),
+	}, mock.Anything).Return(&compute.InstanceIterator{}, nil)
+	mockClient.On("Get", ctx, mock.Anything, mock.Anything).Return(&computepb.Instance{}, nil)
+	for _, inst := range toBeIteratedInstances {
+		mockClient.On("Delete", ctx, &computepb.DeleteInstanceRequest{
+			Project:  config.ProjectId,
+			Zone:     config.Zone,
+			Instance: inst.GetName(),
+		}, mock.Anything).Return(mockOperation, nil).Once()
+	}
+
+	err := gcpProvider.RemoveAllInstances(ctx)
+	assert.NoError(t, err)
+	mockClient.AssertExpectations(t)
+	mockClient.AssertNumberOfCalls(t, "Delete", len(toBeIteratedInstances))
+}
+
+func TestRemoveAllInstancesConcurrency(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(client.MockGcpClient)
+	client.WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return nil
+	}
+	gcpProvider := &GcpProvider{
+		gcpCli:       &client.GcpCli{},
+		controllerID: "my-controller",
+	}
+	config := config.Config{
+		Zone:              "europe-west1-d",
+		ProjectId:         "my-project",
+		NetworkID:         "my-network",
+		SubnetworkID:      "my-subnetwork",
+		CredentialsFile:   "path/to/credentials.json",
+		DeleteConcurrency: 2,
+	}
+	gcpProvider.gcpCli.SetClient(mockClient)
+	gcpProvider.gcpCli.SetConfig(&config)
+	var toBeIteratedInstances []*computepb.Instance
+	for i := 0; i < 6; i++ {
+		toBeIteratedInstances = append(toBeIteratedInstances, &computepb.Instance{
+			Name: proto.String(fmt.Sprintf("garm-instance-%d", i)),
+		})
+	}
+
+	it := 0
+	client.NextIt = func(*compute.InstanceIterator) (*computepb.Instance, error) {
+		if it < len(toBeIteratedInstances) {
+			it++
+			return toBeIteratedInstances[it-1], nil
+		}
+		return nil, iterator.Done
+	}
+
+	var inFlight, maxInFlight atomic.Int32
+	mockClient.On("List", ctx, mock.Anything, mock.Anything).Return(&compute.InstanceIterator{}, nil)
+	mockClient.On("Get", ctx, mock.Anything, mock.Anything).Return(&computepb.Instance{}, nil)
+	mockClient.On("Delete", ctx, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		current := inFlight.Add(1)
+		for {
+			observed := maxInFlight.Load()
+			if current <= observed || maxInFlight.CompareAndSwap(observed, current) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		inFlight.Add(-1)
+	}).Return(&compute.Operation{}, nil)
+
+	err := gcpProvider.RemoveAllInstances(ctx)
+	assert.NoError(t, err)
+	mockClient.AssertNumberOfCalls(t, "Delete", len(toBeIteratedInstances))
+	for _, inst := range toBeIteratedInstances {
+		mockClient.AssertCalled(t, "Delete", ctx, &computepb.DeleteInstanceRequest{
+			Project:  config.ProjectId,
+			Zone:     config.Zone,
+			Instance: inst.GetName(),
+		}, mock.Anything)
+	}
+	assert.LessOrEqual(t, maxInFlight.Load(), int32(2))
+	assert.Greater(t, maxInFlight.Load(), int32(0))
+}
+
+func TestRemoveAllInstancesError(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(client.MockGcpClient)
+	mockOperation := &compute.Operation{}
+	client.WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return nil
+	}
+	gcpProvider := &GcpProvider{
+		gcpCli:       &client.GcpCli{},
+		controllerID: "my-controller",
+	}
+	config := config.Config{
+		Zone:             "europe-west1-d",
+		ProjectId:        "my-project",
+		NetworkID:        "my-network",
+		SubnetworkID:     "my-subnetwork",
+		CredentialsFile:  "path/to/credentials.json",
+		ExternalIPAccess: true,
+	}
+	gcpProvider.gcpCli.SetClient(mockClient)
+	gcpProvider.gcpCli.SetConfig(&config)
+	toBeIteratedInstances := []*computepb.Instance{
+		{Name: proto.String("garm-instance-1")},
+		{Name: proto.String("garm-instance-2")},
+		{Name: proto.String("garm-instance-3")},
+	}
+
+	it := 0
+	client.NextIt = func(*compute.InstanceIterator) (*computepb.Instance, error) {
+		if it < len(toBeIteratedInstances) {
+			it++
+			return toBeIteratedInstances[it-1], nil
+		}
+		return nil, iterator.Done
+	}
+
+	notFoundErr, _ := apierror.FromError(&googleapi.Error{
+		Code: 404,
+	})
+	forbiddenErr, _ := apierror.FromError(&googleapi.Error{
+		Code: 403,
+	})
+	mockClient.On("List", ctx, mock.Anything, mock.Anything).Return(&compute.InstanceIterator{}, nil)
+	mockClient.On("Get", ctx, mock.Anything, mock.Anything).Return(&computepb.Instance{}, nil)
+	mockClient.On("Delete", ctx, &computepb.DeleteInstanceRequest{
+		Project:  config.ProjectId,
+		Zone:     config.Zone,
+		Instance: "garm-instance-1",
+	}, mock.Anything).Return(mockOperation, notFoundErr)
+	mockClient.On("Delete", ctx, &computepb.DeleteInstanceRequest{
+		Project:  config.ProjectId,
+		Zone:     config.Zone,
+		Instance: "garm-instance-2",
+	}, mock.Anything).Return(mockOperation, forbiddenErr)
+	mockClient.On("Delete", ctx, &computepb.DeleteInstanceRequest{
+		Project:  config.ProjectId,
+		Zone:     config.Zone,
+		Instance: "garm-instance-3",
+	}, mock.Anything).Return(mockOperation, nil)
+
+	err := gcpProvider.RemoveAllInstances(ctx)
+	assert.ErrorContains(t, err, "garm-instance-2")
+	assert.NotContains(t, err.Error(), "garm-instance-1")
+	mockClient.AssertExpectations(t)
+}
+
+func TestRemoveAllInstancesListError(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(client.MockGcpClient)
+	gcpProvider := &GcpProvider{
+		gcpCli:       &client.GcpCli{},
+		controllerID: "my-controller",
+	}
+	config := config.Config{
+		Zone:             "europe-west1-d",
+		ProjectId:        "my-project",
+		NetworkID:        "my-network",
+		SubnetworkID:     "my-subnetwork",
+		CredentialsFile:  "path/to/credentials.json",
+		ExternalIPAccess: true,
+	}
+	gcpProvider.gcpCli.SetClient(mockClient)
+	gcpProvider.gcpCli.SetConfig(&config)
+
+	forbiddenErr, _ := apierror.FromError(&googleapi.Error{
+		Code: 403,
+	})
+	client.NextIt = func(*compute.InstanceIterator) (*computepb.Instance, error) {
+		return nil, forbiddenErr
+	}
+	mockClient.On("List", ctx, mock.Anything, mock.Anything).Return(&compute.InstanceIterator{}, nil)
+
+	err := gcpProvider.RemoveAllInstances(ctx)
+	assert.ErrorIs(t, err, client.ErrPermissionDenied)
+	assert.ErrorContains(t, err, "failed to list instances")
+	mockClient.AssertExpectations(t)
+	mockClient.AssertNotCalled(t, "Delete", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestGetSerialPortOutput(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(client.MockGcpClient)
+	gcpProvider := &GcpProvider{
+		gcpCli:       &client.GcpCli{},
+		controllerID: "my-controller",
+	}
+	config := config.Config{
+		Zone:             "europe-west1-d",
+		ProjectId:        "my-project",
+		NetworkID:        "my-network",
+		SubnetworkID:     "my-subnetwork",
+		CredentialsFile:  "path/to/credentials.json",
+		ExternalIPAccess: true,
+	}
+	gcpProvider.gcpCli.SetClient(mockClient)
+	gcpProvider.gcpCli.SetConfig(&config)
+
+	mockClient.On("GetSerialPortOutput", ctx, mock.AnythingOfType("*computepb.GetSerialPortOutputInstanceRequest"), []gax.CallOption(nil)).Return(&computepb.SerialPortOutput{
+		Contents: proto.String("MockSerialOutput"),
+	}, nil)
+
+	output, err := gcpProvider.GetSerialPortOutput(ctx, "my-instance", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "MockSerialOutput", output)
+	mockClient.AssertExpectations(t)
+}
+
 func TestStop(t *testing.T) {
 	ctx := context.Background()
 	mockClient := new(client.MockGcpClient)
@@ -370,6 +1091,86 @@ func TestStop(t *testing.T) {
 	mockClient.AssertExpectations(t)
 }
 
+func TestStopStartUseSuspend(t *testing.T) {
+	ctx := context.Background()
+	client.WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return nil
+	}
+
+	tests := []struct {
+		name       string
+		useSuspend bool
+		stopCall   string
+		startCall  string
+	}{
+		{name: "StopStart", useSuspend: false, stopCall: "Stop", startCall: "Start"},
+		{name: "SuspendResume", useSuspend: true, stopCall: "Suspend", startCall: "Resume"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockClient := new(client.MockGcpClient)
+			gcpProvider := &GcpProvider{
+				gcpCli:       &client.GcpCli{},
+				controllerID: "my-controller",
+			}
+			config := config.Config{
+				Zone:             "europe-west1-d",
+				ProjectId:        "my-project",
+				NetworkID:        "my-network",
+				SubnetworkID:     "my-subnetwork",
+				CredentialsFile:  "path/to/credentials.json",
+				ExternalIPAccess: true,
+				UseSuspend:       tt.useSuspend,
+			}
+			gcpProvider.gcpCli.SetClient(mockClient)
+			gcpProvider.gcpCli.SetConfig(&config)
+
+			mockClient.On(tt.stopCall, ctx, mock.Anything, mock.Anything).Return(&compute.Operation{}, nil)
+			mockClient.On(tt.startCall, ctx, mock.Anything, mock.Anything).Return(&compute.Operation{}, nil)
+
+			err := gcpProvider.Stop(ctx, "my-instance", false)
+			assert.NoError(t, err)
+			err = gcpProvider.Start(ctx, "my-instance")
+			assert.NoError(t, err)
+
+			mockClient.AssertExpectations(t)
+			mockClient.AssertNumberOfCalls(t, tt.stopCall, 1)
+			mockClient.AssertNumberOfCalls(t, tt.startCall, 1)
+		})
+	}
+}
+
+func TestReset(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(client.MockGcpClient)
+	mockOperation := &compute.Operation{}
+	client.WaitOp = func(op *compute.Operation, ctx context.Context, opts ...gax.CallOption) error {
+		return nil
+	}
+	gcpProvider := &GcpProvider{
+		gcpCli:       &client.GcpCli{},
+		controllerID: "my-controller",
+	}
+	config := config.Config{
+		Zone:             "europe-west1-d",
+		ProjectId:        "my-project",
+		NetworkID:        "my-network",
+		SubnetworkID:     "my-subnetwork",
+		CredentialsFile:  "path/to/credentials.json",
+		ExternalIPAccess: true,
+	}
+	gcpProvider.gcpCli.SetClient(mockClient)
+	gcpProvider.gcpCli.SetConfig(&config)
+
+	instanceName := "my-instance"
+	mockClient.On("Reset", ctx, mock.AnythingOfType("*computepb.ResetInstanceRequest"), []gax.CallOption(nil)).Return(mockOperation, nil)
+
+	err := gcpProvider.Reset(ctx, instanceName)
+	assert.NoError(t, err)
+	mockClient.AssertExpectations(t)
+}
+
 func TestStart(t *testing.T) {
 	ctx := context.Background()
 	mockClient := new(client.MockGcpClient)
@@ -399,3 +1200,15 @@ func TestStart(t *testing.T) {
 	assert.NoError(t, err)
 	mockClient.AssertExpectations(t)
 }
+
+func TestGetVersion(t *testing.T) {
+	ctx := context.Background()
+	gcpProvider := &GcpProvider{}
+
+	assert.Equal(t, Version, gcpProvider.GetVersion(ctx))
+
+	oldVersion := Version
+	defer func() { Version = oldVersion }()
+	Version = "v1.2.3"
+	assert.Equal(t, "v1.2.3", gcpProvider.GetVersion(ctx))
+}